@@ -0,0 +1,277 @@
+package sagas
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// States of a saga shown in the admin dashboard
+const (
+	stateRunning     = "running"
+	stateAborting    = "aborting"
+	stateCommitted   = "committed"
+	stateCompensated = "compensated"
+	stateStopped     = "stopped"
+)
+
+//go:embed admin
+var adminFiles embed.FS
+
+type adminSaga struct {
+	ID       string        `json:"id"`
+	State    string        `json:"state"`
+	Vertices []adminVertex `json:"vertices,omitempty"`
+	Edges    []adminEdge   `json:"edges,omitempty"`
+	Logs     []adminLog    `json:"logs,omitempty"`
+	// Redacted is set when bodies and replies are left out because logs are encrypted
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+type adminVertex struct {
+	ID             string   `json:"id"`
+	Status         string   `json:"status"`
+	T              *Func    `json:"t"`
+	C              *Func    `json:"c"`
+	TransferFields []string `json:"transferFields"`
+	Kind           string   `json:"kind"`
+	Attempts       uint32   `json:"attempts,omitempty"`
+	CAttempts      uint32   `json:"cAttempts,omitempty"`
+	LastError      string   `json:"lastError,omitempty"`
+	ReadOnly       bool     `json:"readOnly,omitempty"`
+}
+
+type adminEdge struct {
+	From           string   `json:"from"`
+	To             string   `json:"to"`
+	TransferFields []string `json:"transferFields"`
+	Predicate      string   `json:"predicate,omitempty"`
+}
+
+type adminLog struct {
+	Lsn    uint64 `json:"lsn"`
+	Type   string `json:"type"`
+	Vertex string `json:"vertex,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// serveAdmin serves the admin dashboard and its API on addr
+func serveAdmin(addr string, c *Coordinator) *http.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+
+	srv := &http.Server{Handler: c.adminHandler()}
+
+	go srv.Serve(lis)
+
+	return srv
+}
+
+// adminHandler serves the dashboard at / and its API at /api/sagas
+func (c *Coordinator) adminHandler() http.Handler {
+	static, err := fs.Sub(adminFiles, "admin")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.HandleFunc("/api/sagas", c.handleListSagas)
+	mux.HandleFunc("/api/sagas/", c.handleSaga)
+	return mux
+}
+
+// handleListSagas lists sagas, optionally only those in the state query parameter
+func (c *Coordinator) handleListSagas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := r.URL.Query().Get("state")
+
+	c.mtx.Lock()
+	list := make([]adminSaga, 0, len(c.sagas))
+	for id, saga := range c.sagas {
+		s := adminSaga{ID: id, State: sagaState(saga)}
+		if state == "" || state == s.State {
+			list = append(list, s)
+		}
+	}
+	c.mtx.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return lessID(list[i].ID, list[j].ID) })
+	writeJSON(w, list)
+}
+
+// handleSaga serves GET /api/sagas/<id> and POST /api/sagas/<id>/abort
+func (c *Coordinator) handleSaga(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/sagas/")
+	sagaID := strings.TrimSuffix(path, "/abort")
+
+	switch {
+	case path != sagaID && r.Method == http.MethodPost:
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		switch err := c.Abort(sagaID); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrSagaIDNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case ErrSagaFinished, ErrSagaAlreadyAborted, ErrSagaPastPivot:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case path == sagaID && r.Method == http.MethodGet:
+		// Vertices are copied under the lock, since updates replace them and write their maps
+		c.mtx.Lock()
+		saga, ok := c.sagas[sagaID]
+		var s adminSaga
+		if ok {
+			s = c.adminSaga(saga)
+		}
+		c.mtx.Unlock()
+		if !ok {
+			http.Error(w, ErrSagaIDNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err := c.adminLogs(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, s)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminSaga describes a saga without its log history. Must hold c.mtx
+func (c *Coordinator) adminSaga(saga Saga) adminSaga {
+	s := adminSaga{
+		ID:    saga.ID,
+		State: sagaState(saga),
+		// The dashboard has no authentication, so it must not reveal what is encrypted at rest
+		Redacted: c.Config.EncryptionKey != nil,
+	}
+
+	for tuple := range saga.Vertices.IterBuffered() {
+		vtx := tuple.Val.(Vertex)
+		s.Vertices = append(s.Vertices, adminVertex{
+			ID:             vtx.Id,
+			Status:         vtx.Status.String(),
+			T:              adminFunc(vtx.T, s.Redacted),
+			C:              adminFunc(vtx.C, s.Redacted),
+			TransferFields: vtx.TransferFields,
+			Kind:           vtx.Kind.String(),
+			Attempts:       vtx.Attempts,
+			CAttempts:      vtx.CAttempts,
+			LastError:      vtx.LastError,
+			ReadOnly:       vtx.ReadOnly,
+		})
+	}
+	sort.Slice(s.Vertices, func(i, j int) bool { return s.Vertices[i].ID < s.Vertices[j].ID })
+
+	saga.dagMtx.RLock()
+	for from, children := range saga.DAG {
+		for to, fields := range children {
+			s.Edges = append(s.Edges, adminEdge{From: from, To: to, TransferFields: fields, Predicate: saga.Predicates[from][to]})
+		}
+	}
+	saga.dagMtx.RUnlock()
+	sort.Slice(s.Edges, func(i, j int) bool {
+		if s.Edges[i].From != s.Edges[j].From {
+			return s.Edges[i].From < s.Edges[j].From
+		}
+		return s.Edges[i].To < s.Edges[j].To
+	})
+
+	return s
+}
+
+// adminLogs adds the log history of a saga to s
+func (c *Coordinator) adminLogs(s *adminSaga) error {
+	it := c.logs.ScanSaga(s.ID)
+	defer it.Close()
+	for it.Next() {
+		log := it.Log()
+		l := adminLog{
+			Lsn:  log.Lsn,
+			Type: strings.ToLower(log.LogType.GoString()),
+		}
+		if log.LogType == VertexLog {
+			vtx, err := log.Vertex()
+			if err != nil {
+				return err
+			}
+			l.Vertex = vtx.Id
+			l.Status = vtx.Status.String()
+		}
+		s.Logs = append(s.Logs, l)
+	}
+	return it.Err()
+}
+
+// adminFunc returns a copy of f, without its body and reply if redacted
+func adminFunc(f *Func, redacted bool) *Func {
+	if f == nil || !redacted {
+		return f.copy()
+	}
+	return &Func{Url: f.Url, Method: f.Method, RequestId: f.RequestId}
+}
+
+// sameOrigin returns whether a request may change state. Browsers mark requests
+// from other sites, which would otherwise let any page an operator visits abort
+// sagas. Requests without these headers do not come from a browser
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// sagaState summarizes a saga's progress
+func sagaState(saga Saga) string {
+	finished, aborted := CheckFinishedOrAbort(saga)
+	switch {
+	case finished && aborted && saga.Recovery == RecoveryMode_FORWARD:
+		// Forward sagas are never compensated, so an aborted one has only stopped
+		return stateStopped
+	case finished && aborted:
+		return stateCompensated
+	case finished:
+		return stateCommitted
+	case aborted:
+		return stateAborting
+	default:
+		return stateRunning
+	}
+}
+
+// lessID orders numeric saga IDs numerically
+func lessID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
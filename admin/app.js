@@ -0,0 +1,214 @@
+// Colours of vertex statuses
+const colours = {
+  NOT_REACHED: "#bbbbbb",
+  START_T: "#7fb3e6",
+  END_T: "#5cb85c",
+  START_C: "#f0ad4e",
+  END_C: "#9b7fd4",
+  ABORT: "#d9534f",
+};
+
+const states = ["running", "aborting", "committed", "compensated", "stopped"];
+
+let selectedSaga = null;
+let selectedVertex = null;
+
+async function getJSON(url) {
+  const resp = await fetch(url);
+  if (!resp.ok) {
+    throw new Error(await resp.text());
+  }
+  return resp.json();
+}
+
+async function loadSagas() {
+  const state = document.getElementById("state").value;
+  const sagas = await getJSON("api/sagas" + (state ? "?state=" + state : ""));
+  const nav = document.getElementById("sagas");
+  nav.innerHTML = "";
+  for (const s of states) {
+    const group = sagas.filter((saga) => saga.state === s);
+    if (group.length === 0) {
+      continue;
+    }
+    const h = document.createElement("h3");
+    h.textContent = s + " (" + group.length + ")";
+    nav.appendChild(h);
+    for (const saga of group) {
+      const a = document.createElement("a");
+      a.textContent = "Saga " + saga.id;
+      a.className = saga.id === selectedSaga ? "selected" : "";
+      a.onclick = () => {
+        selectedSaga = saga.id;
+        selectedVertex = null;
+        refresh();
+      };
+      nav.appendChild(a);
+    }
+  }
+}
+
+async function loadSaga() {
+  const section = document.getElementById("saga");
+  if (selectedSaga === null) {
+    section.hidden = true;
+    return;
+  }
+  const saga = await getJSON("api/sagas/" + encodeURIComponent(selectedSaga));
+  section.hidden = false;
+  document.getElementById("saga-title").textContent = "Saga " + saga.id + " — " + saga.state;
+  document.getElementById("abort").hidden = saga.state !== "running";
+  renderDAG(saga);
+  renderVertex(saga);
+}
+
+// levels assigns each vertex the length of the longest path from a source
+function levels(saga) {
+  const level = {};
+  for (const v of saga.vertices) {
+    level[v.id] = 0;
+  }
+  const edges = saga.edges || [];
+  for (let i = 0; i < saga.vertices.length; i++) {
+    for (const e of edges) {
+      level[e.to] = Math.max(level[e.to], level[e.from] + 1);
+    }
+  }
+  return level;
+}
+
+function renderDAG(saga) {
+  const svg = document.getElementById("dag");
+  const ns = "http://www.w3.org/2000/svg";
+  svg.innerHTML = "";
+
+  const level = levels(saga);
+  const columns = [];
+  for (const v of saga.vertices) {
+    (columns[level[v.id]] = columns[level[v.id]] || []).push(v.id);
+  }
+  const pos = {};
+  columns.forEach((ids, x) => {
+    ids.forEach((id, y) => {
+      pos[id] = { x: 80 + x * 140, y: 40 + y * 60 };
+    });
+  });
+
+  for (const e of saga.edges || []) {
+    const line = document.createElementNS(ns, "line");
+    line.setAttribute("x1", pos[e.from].x + 40);
+    line.setAttribute("y1", pos[e.from].y);
+    line.setAttribute("x2", pos[e.to].x - 40);
+    line.setAttribute("y2", pos[e.to].y);
+    line.setAttribute("stroke", "#999");
+    svg.appendChild(line);
+  }
+
+  for (const v of saga.vertices) {
+    const g = document.createElementNS(ns, "g");
+    g.setAttribute("class", "vertex");
+    g.onclick = () => {
+      selectedVertex = v.id;
+      renderVertex(saga);
+    };
+    const rect = document.createElementNS(ns, "rect");
+    rect.setAttribute("x", pos[v.id].x - 40);
+    rect.setAttribute("y", pos[v.id].y - 15);
+    rect.setAttribute("width", 80);
+    rect.setAttribute("height", 30);
+    rect.setAttribute("rx", 6);
+    rect.setAttribute("fill", colours[v.status]);
+    rect.setAttribute("stroke", v.id === selectedVertex ? "#000" : "none");
+    const text = document.createElementNS(ns, "text");
+    text.setAttribute("x", pos[v.id].x);
+    text.setAttribute("y", pos[v.id].y);
+    text.textContent = v.id;
+    g.appendChild(rect);
+    g.appendChild(text);
+    svg.appendChild(g);
+  }
+
+  const legend = document.getElementById("legend");
+  legend.innerHTML = "";
+  for (const [status, colour] of Object.entries(colours)) {
+    const span = document.createElement("span");
+    const i = document.createElement("i");
+    i.style.background = colour;
+    span.appendChild(i);
+    span.appendChild(document.createTextNode(status));
+    legend.appendChild(span);
+  }
+}
+
+function renderVertex(saga) {
+  const div = document.getElementById("vertex");
+  div.innerHTML = "";
+  const v = saga.vertices.find((v) => v.id === selectedVertex);
+  if (!v) {
+    return;
+  }
+
+  const h = document.createElement("h3");
+  h.textContent = "Vertex " + v.id + " — " + v.status;
+  div.appendChild(h);
+  if (v.attempts || v.cAttempts) {
+    const p = document.createElement("p");
+    p.textContent = "T attempts: " + (v.attempts || 0) + ", C attempts: " + (v.cAttempts || 0) +
+      (v.lastError ? " — last error: " + v.lastError : "");
+    div.appendChild(p);
+  }
+  for (const [name, f] of [["T", v.t], ["C", v.c]]) {
+    const h4 = document.createElement("h4");
+    h4.textContent = name + (f ? " " + (f.method || "") + " " + (f.url || "") : "");
+    const pre = document.createElement("pre");
+    pre.textContent = saga.redacted
+      ? "Body and reply are hidden because logs are encrypted"
+      : JSON.stringify({ body: f && f.body, resp: f && f.resp }, null, 2);
+    div.appendChild(h4);
+    div.appendChild(pre);
+  }
+
+  const table = document.createElement("table");
+  table.innerHTML = "<tr><th>LSN</th><th>Type</th><th>Status</th></tr>";
+  for (const log of saga.logs || []) {
+    if (log.type === "vertex" && log.vertex !== v.id) {
+      continue;
+    }
+    const tr = document.createElement("tr");
+    for (const cell of [log.lsn, log.type, log.status || ""]) {
+      const td = document.createElement("td");
+      td.textContent = cell;
+      tr.appendChild(td);
+    }
+    table.appendChild(tr);
+  }
+  const h4 = document.createElement("h4");
+  h4.textContent = "Log history";
+  div.appendChild(h4);
+  div.appendChild(table);
+}
+
+async function abortSaga() {
+  if (!confirm("Abort saga " + selectedSaga + "?")) {
+    return;
+  }
+  const resp = await fetch("api/sagas/" + encodeURIComponent(selectedSaga) + "/abort", { method: "POST" });
+  if (!resp.ok) {
+    alert(await resp.text());
+  }
+  refresh();
+}
+
+async function refresh() {
+  try {
+    await loadSagas();
+    await loadSaga();
+  } catch (err) {
+    console.error(err);
+  }
+}
+
+document.getElementById("state").onchange = refresh;
+document.getElementById("abort").onclick = abortSaga;
+refresh();
+setInterval(refresh, 2000);
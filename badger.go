@@ -1,13 +1,28 @@
 package sagas
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/dgraph-io/badger"
 	"github.com/triplewy/sagas/utils"
 )
 
+var (
+	// ErrReadOnly is used when writing to a log store that was opened read-only
+	ErrReadOnly = errors.New("log store is read-only")
+	// ErrStoreNotClosed is used when a store cannot be opened read-only because it
+	// was not closed cleanly or a coordinator still has it open
+	ErrStoreNotClosed = errors.New("log store was not closed cleanly or is in use by another process")
+)
+
 // Badger implements LogStore interface
 type Badger struct {
 	path        string
@@ -15,10 +30,20 @@ type Badger struct {
 	reqCounter  *badger.Sequence
 	sagaCounter *badger.Sequence
 	logCounter  *badger.Sequence
+	keyring     *Keyring
+	readOnly    bool
+	// snapshot is set when path is a copy that is removed on Close
+	snapshot bool
 }
 
 // NewBadgerDB opens an in-memory BadgerDB
 func NewBadgerDB(path string, inMemory bool) *Badger {
+	return NewEncryptedBadgerDB(path, inMemory, nil)
+}
+
+// NewEncryptedBadgerDB opens a BadgerDB that encrypts logs with keyring. A nil
+// keyring disables encryption
+func NewEncryptedBadgerDB(path string, inMemory bool, keyring *Keyring) *Badger {
 	if inMemory {
 		path = ""
 	}
@@ -50,36 +75,141 @@ func NewBadgerDB(path string, inMemory bool) *Badger {
 		reqCounter:  reqCounter,
 		sagaCounter: sagaCounter,
 		logCounter:  logCounter,
+		keyring:     keyring,
 	}
 
-	b.AppendLog("0", InitLog, []byte{0})
+	if _, err := b.AppendLog("0", InitLog, []byte{0}); err != nil {
+		panic(err)
+	}
 
 	return b
 }
 
+// OpenBadgerReadOnly opens an on-disk BadgerDB for reading logs without modifying
+// it. Only stores that were closed cleanly and are not open elsewhere can be opened
+// read-only, others return ErrStoreNotClosed
+func OpenBadgerReadOnly(path string, keyring *Keyring) (*Badger, error) {
+	opts := badger.DefaultOptions(path)
+	opts.ReadOnly = true
+	opts.EventLogging = false
+	opts.Logger = nil
+
+	db, err := badger.Open(opts)
+	// Badger refuses to replay its value log read-only, and a running coordinator
+	// holds the directory lock. Badger formats the replay error into its message
+	if err != nil && (strings.Contains(err.Error(), badger.ErrReplayNeeded.Error()) || errors.Is(err, syscall.EWOULDBLOCK)) {
+		return nil, fmt.Errorf("%w: %v", ErrStoreNotClosed, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Badger{
+		path:     path,
+		db:       db,
+		keyring:  keyring,
+		readOnly: true,
+	}, nil
+}
+
+// OpenBadgerSnapshot opens a copy of an on-disk BadgerDB for reading logs. Unlike
+// OpenBadgerReadOnly it opens stores after a crash or of a running coordinator.
+// Logs the coordinator has not yet synced may be missing from the copy
+func OpenBadgerSnapshot(path string, keyring *Keyring) (*Badger, error) {
+	dir, err := os.MkdirTemp("", "sagas-snapshot")
+	if err != nil {
+		return nil, err
+	}
+	if err := copyStore(path, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	// The copy is opened read-write so that badger replays its value log, and
+	// truncated since the last write may have been cut off
+	opts := badger.DefaultOptions(dir)
+	opts.Truncate = true
+	opts.EventLogging = false
+	opts.Logger = nil
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &Badger{
+		path:     dir,
+		db:       db,
+		keyring:  keyring,
+		readOnly: true,
+		snapshot: true,
+	}, nil
+}
+
+// copyStore copies the files of a store, except its lock, from src to dst
+func copyStore(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name() == "LOCK" {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 // NewSagaID retrieves a unique saga ID by incrementing
-func (b *Badger) NewSagaID() (sagaID string) {
+func (b *Badger) NewSagaID() (string, error) {
+	if b.readOnly {
+		return "", ErrReadOnly
+	}
 	num, err := b.sagaCounter.Next()
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return strconv.FormatUint(num, 10)
+	return strconv.FormatUint(num, 10), nil
 }
 
 // NewRequestID retrieves a unique request ID by incrementing
-func (b *Badger) NewRequestID() (requestID string) {
+func (b *Badger) NewRequestID() (string, error) {
+	if b.readOnly {
+		return "", ErrReadOnly
+	}
 	num, err := b.reqCounter.Next()
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return strconv.FormatUint(num, 10)
+	return strconv.FormatUint(num, 10), nil
 }
 
 // LastIndex returns the last written log index
-func (b *Badger) LastIndex() (index uint64) {
-	err := b.db.View(func(txn *badger.Txn) error {
+func (b *Badger) LastIndex() (index uint64, err error) {
+	err = b.db.View(func(txn *badger.Txn) error {
 		prefix := []byte("log:")
-
 		opts := badger.DefaultIteratorOptions
 		opts.PrefetchValues = false
 		opts.Reverse = true
@@ -89,75 +219,161 @@ func (b *Badger) LastIndex() (index uint64) {
 		defer it.Close()
 
 		it.Seek(append(prefix, 0xFF))
-
 		if !it.Valid() {
 			return nil
 		}
-
 		item := it.Item()
-
 		key := item.KeyCopy(nil)
 		index = utils.BytesToUint64(key[len(key)-8:])
 		return nil
 	})
-	if err != nil {
-		panic(err)
-	}
 	return
 }
 
 // AppendLog takes a sagaID, LogType, and a slice of bytes and formats them into a log to persist to disk
-func (b *Badger) AppendLog(sagaID string, logType LogType, data []byte) {
-	err := b.db.Update(func(txn *badger.Txn) error {
+func (b *Badger) AppendLog(sagaID string, logType LogType, data []byte) (uint64, error) {
+	logs := []Log{{
+		SagaID:  sagaID,
+		LogType: logType,
+		Data:    data,
+	}}
+	if err := b.AppendLogs(logs); err != nil {
+		return 0, err
+	}
+	return logs[0].Lsn, nil
+}
+
+// AppendLogs persists multiple logs in a single transaction. Each log is assigned
+// the next index as its Lsn. If the logs do not fit into one transaction, none of
+// them are written and badger.ErrTxnTooBig is returned
+func (b *Badger) AppendLogs(logs []Log) error {
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	txn := b.db.NewTransaction(true)
+	defer txn.Discard()
+
+	for i, log := range logs {
 		index, err := b.logCounter.Next()
 		if err != nil {
 			return err
 		}
-		log := Log{
-			Lsn:     index,
-			SagaID:  sagaID,
-			LogType: logType,
-			Data:    data,
+		log.Lsn = index
+		logs[i].Lsn = index
+		key := logKey(index)
+		buf, err := b.keyring.seal(encodeLog(log), key)
+		if err != nil {
+			return err
 		}
-		buf := encodeLog(log)
-
-		key := append([]byte("log:"), utils.Uint64ToBytes(index)...)
 
-		return txn.Set(key, buf)
-	})
-	if err != nil {
-		panic(err)
+		// Each log is also indexed by its sagaID for ScanSaga
+		if err := txn.Set(key, buf); err != nil {
+			return err
+		}
+		if err := txn.Set(sagaKey(log.SagaID, index), nil); err != nil {
+			return err
+		}
 	}
+
+	return txn.Commit()
 }
 
 // GetLog retrieves a log from the db. If a log does not exist at the index, GetLog returns ErrLogIndexNotFound
 func (b *Badger) GetLog(index uint64) (Log, error) {
 	txn := b.db.NewTransaction(false)
 	defer txn.Discard()
+	return b.getLog(txn, index)
+}
 
-	key := append([]byte("log:"), utils.Uint64ToBytes(index)...)
-	item, err := txn.Get(key)
-	if err != nil {
-		return Log{}, err
-	}
-	valCopy, err := item.ValueCopy(nil)
-	if err != nil {
-		panic(err)
+// Scan returns an iterator over logs with index in [from, to]
+func (b *Badger) Scan(from, to uint64) LogIterator {
+	return newBadgerIterator(b, []byte("log:"), logKey(from), to, false)
+}
+
+// ScanSaga returns an iterator over all logs with sagaID
+func (b *Badger) ScanSaga(sagaID string) LogIterator {
+	prefix := sagaKey(sagaID, 0)
+	prefix = prefix[:len(prefix)-8]
+	return newBadgerIterator(b, prefix, prefix, math.MaxUint64, true)
+}
+
+// Reencrypt rewrites every log that is not encrypted with the keyring's active key.
+// After it returns, keys that were rotated out can be dropped from the keyring
+func (b *Badger) Reencrypt() error {
+	if b.keyring == nil {
+		return ErrNoEncryptionKey
 	}
-	return decodeLog(valCopy), nil
+
+	return b.rewriteLogs(func(w *txnWriter, key, val []byte) error {
+		if !b.keyring.rotated(val) {
+			return nil
+		}
+		buf, err := b.keyring.open(val, key)
+		if err != nil {
+			return err
+		}
+		if buf, err = b.keyring.seal(buf, key); err != nil {
+			return err
+		}
+		return w.set(key, buf)
+	})
+}
+
+// Migrate rewrites every log written in an older record format in the current
+// format and indexes logs written before ScanSaga existed. It must not run while
+// a coordinator is using the store. Returns the number of logs rewritten
+func (b *Badger) Migrate() (int, error) {
+	migrated := 0
+	err := b.rewriteLogs(func(w *txnWriter, key, val []byte) error {
+		buf, err := b.keyring.open(val, key)
+		if err != nil {
+			return err
+		}
+		// Decode without upgrading to learn the stored version
+		var log Log
+		if err := utils.DecodeMsgPack(buf, &log); err != nil {
+			return err
+		}
+		index := utils.BytesToUint64(key[len(key)-8:])
+		if err := w.set(sagaKey(log.SagaID, index), nil); err != nil {
+			return err
+		}
+		if log.Version == LogVersion {
+			return nil
+		}
+
+		if log, err = upgradeLog(log); err != nil {
+			return err
+		}
+		if buf, err = b.keyring.seal(encodeLog(log), key); err != nil {
+			return err
+		}
+		migrated++
+		return w.set(key, buf)
+	})
+	return migrated, err
 }
 
 // Close releases all counters and closes badgerDB
 func (b *Badger) Close() {
-	if err := b.reqCounter.Release(); err != nil {
-		panic(err)
-	}
-	if err := b.sagaCounter.Release(); err != nil {
-		panic(err)
+	// Read-only stores never acquire counters
+	if !b.readOnly {
+		if err := b.reqCounter.Release(); err != nil {
+			panic(err)
+		}
+		if err := b.sagaCounter.Release(); err != nil {
+			panic(err)
+		}
+		if err := b.logCounter.Release(); err != nil {
+			panic(err)
+		}
 	}
 	if err := b.db.Close(); err != nil {
 		panic(err)
 	}
+	if b.snapshot {
+		b.RemoveAll()
+	}
 }
 
 // RemoveAll removes all db data on disk
@@ -168,3 +384,178 @@ func (b *Badger) RemoveAll() {
 		}
 	}
 }
+
+func logKey(index uint64) []byte {
+	return append([]byte("log:"), utils.Uint64ToBytes(index)...)
+}
+
+func sagaKey(sagaID string, index uint64) []byte {
+	return append([]byte("saga:"+sagaID+":"), utils.Uint64ToBytes(index)...)
+}
+
+func (b *Badger) getLog(txn *badger.Txn, index uint64) (Log, error) {
+	key := logKey(index)
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return Log{}, ErrLogIndexNotFound
+	}
+	if err != nil {
+		return Log{}, err
+	}
+	valCopy, err := item.ValueCopy(nil)
+	if err != nil {
+		return Log{}, err
+	}
+	return b.decodeValue(key, valCopy)
+}
+
+// decodeValue decrypts and decodes the value stored at a log key
+func (b *Badger) decodeValue(key, val []byte) (Log, error) {
+	buf, err := b.keyring.open(val, key)
+	if err != nil {
+		return Log{}, err
+	}
+	return decodeLog(buf)
+}
+
+// rewriteLogs calls fn with the raw value of every log. fn may write through w,
+// which commits as often as needed so rewrites are not bound by transaction size
+func (b *Badger) rewriteLogs(fn func(w *txnWriter, key, val []byte) error) error {
+	if b.readOnly {
+		return ErrReadOnly
+	}
+	w := b.newTxnWriter()
+	defer w.discard()
+
+	// Read from a separate transaction since w may commit midway
+	readTxn := b.db.NewTransaction(false)
+	defer readTxn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte("log:")
+	it := readTxn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(w, item.KeyCopy(nil), val); err != nil {
+			return err
+		}
+	}
+
+	return w.commit()
+}
+
+// txnWriter sets keys in a write transaction and moves on to a new one whenever
+// the current transaction is too big
+type txnWriter struct {
+	db  *badger.DB
+	txn *badger.Txn
+}
+
+func (b *Badger) newTxnWriter() *txnWriter {
+	return &txnWriter{db: b.db, txn: b.db.NewTransaction(true)}
+}
+
+func (w *txnWriter) set(key, val []byte) error {
+	err := w.txn.Set(key, val)
+	if err == badger.ErrTxnTooBig {
+		if err := w.txn.Commit(); err != nil {
+			return err
+		}
+		w.txn = w.db.NewTransaction(true)
+		err = w.txn.Set(key, val)
+	}
+	return err
+}
+
+func (w *txnWriter) commit() error {
+	return w.txn.Commit()
+}
+
+func (w *txnWriter) discard() {
+	w.txn.Discard()
+}
+
+// badgerIterator iterates over keys with a prefix that end in a log index. If
+// indexed, keys are saga index entries and each log is looked up by its index
+type badgerIterator struct {
+	b       *Badger
+	txn     *badger.Txn
+	it      *badger.Iterator
+	prefix  []byte
+	seek    []byte
+	to      uint64
+	indexed bool
+	started bool
+	log     Log
+	err     error
+}
+
+func newBadgerIterator(b *Badger, prefix, seek []byte, to uint64, indexed bool) *badgerIterator {
+	txn := b.db.NewTransaction(false)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = !indexed
+	opts.Prefix = prefix
+	return &badgerIterator{
+		b:       b,
+		txn:     txn,
+		it:      txn.NewIterator(opts),
+		prefix:  prefix,
+		seek:    seek,
+		to:      to,
+		indexed: indexed,
+	}
+}
+
+func (i *badgerIterator) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	if i.started {
+		i.it.Next()
+	} else {
+		i.it.Seek(i.seek)
+		i.started = true
+	}
+	if !i.it.ValidForPrefix(i.prefix) {
+		return false
+	}
+
+	item := i.it.Item()
+	key := item.Key()
+	index := utils.BytesToUint64(key[len(key)-8:])
+	if index > i.to {
+		return false
+	}
+
+	if i.indexed {
+		i.log, i.err = i.b.getLog(i.txn, index)
+		return i.err == nil
+	}
+
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		i.err = err
+		return false
+	}
+	i.log, i.err = i.b.decodeValue(item.Key(), val)
+	return i.err == nil
+}
+
+func (i *badgerIterator) Log() Log {
+	return i.log
+}
+
+func (i *badgerIterator) Err() error {
+	return i.err
+}
+
+func (i *badgerIterator) Close() {
+	i.it.Close()
+	i.txn.Discard()
+}
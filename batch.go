@@ -0,0 +1,139 @@
+package sagas
+
+import (
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// DefaultMaxBatch is the default maximum number of logs written in one group commit
+const DefaultMaxBatch = 256
+
+// BatchAppender is implemented by log stores that can persist multiple logs in one write
+type BatchAppender interface {
+	// AppendLogs appends logs to the db in a single write, or returns an error without
+	// appending any of them. Logs that do not fit into one write fail with
+	// badger.ErrTxnTooBig. The store sets each log's Lsn
+	AppendLogs(logs []Log) error
+}
+
+type appendReq struct {
+	log  Log
+	done chan appendResult
+}
+
+type appendResult struct {
+	lsn uint64
+	err error
+}
+
+// BatchLogStore wraps a LogStore and coalesces concurrent appends into group commits.
+// Each AppendLog call returns only once the batch containing its log is persisted
+type BatchLogStore struct {
+	LogStore
+
+	maxBatch int
+	appendCh chan appendReq
+	closeCh  chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewBatchLogStore creates a BatchLogStore in front of store that writes at most
+// maxBatch logs per group commit
+func NewBatchLogStore(store LogStore, maxBatch int) *BatchLogStore {
+	if maxBatch < 1 {
+		maxBatch = DefaultMaxBatch
+	}
+	b := &BatchLogStore{
+		LogStore: store,
+		maxBatch: maxBatch,
+		appendCh: make(chan appendReq, maxBatch),
+		closeCh:  make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// AppendLog queues a log for the next group commit and blocks until it is persisted
+func (b *BatchLogStore) AppendLog(sagaID string, logType LogType, data []byte) (uint64, error) {
+	done := make(chan appendResult, 1)
+	b.appendCh <- appendReq{
+		log: Log{
+			SagaID:  sagaID,
+			LogType: logType,
+			Data:    data,
+		},
+		done: done,
+	}
+	res := <-done
+	return res.lsn, res.err
+}
+
+// Close stops group commits and closes the underlying store. AppendLog must not be called after Close
+func (b *BatchLogStore) Close() {
+	close(b.closeCh)
+	b.wg.Wait()
+	b.LogStore.Close()
+}
+
+// run writes queued appends. Appends that arrive while a batch is being written
+// queue up and are written together in the next batch
+func (b *BatchLogStore) run() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case req := <-b.appendCh:
+			batch := []appendReq{req}
+		drain:
+			for len(batch) < b.maxBatch {
+				select {
+				case req := <-b.appendCh:
+					batch = append(batch, req)
+				default:
+					break drain
+				}
+			}
+			b.flush(batch)
+		case <-b.closeCh:
+			return
+		}
+	}
+}
+
+func (b *BatchLogStore) flush(batch []appendReq) {
+	// If store cannot write batches, append logs one at a time
+	appender, ok := b.LogStore.(BatchAppender)
+	if !ok {
+		for _, req := range batch {
+			lsn, err := b.LogStore.AppendLog(req.log.SagaID, req.log.LogType, req.log.Data)
+			req.done <- appendResult{lsn: lsn, err: err}
+		}
+		return
+	}
+
+	b.write(appender, batch)
+}
+
+// write appends a batch in a single write. A batch too big for one transaction is
+// split in halves, so that only a log too big on its own fails
+func (b *BatchLogStore) write(appender BatchAppender, batch []appendReq) {
+	logs := make([]Log, len(batch))
+	for i, req := range batch {
+		logs[i] = req.log
+	}
+	err := appender.AppendLogs(logs)
+	if err == badger.ErrTxnTooBig && len(batch) > 1 {
+		b.write(appender, batch[:len(batch)/2])
+		b.write(appender, batch[len(batch)/2:])
+		return
+	}
+	for i, req := range batch {
+		if err != nil {
+			req.done <- appendResult{err: err}
+			continue
+		}
+		req.done <- appendResult{lsn: logs[i].Lsn}
+	}
+}
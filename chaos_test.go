@@ -0,0 +1,284 @@
+package sagas
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/triplewy/sagas/hotels"
+	"github.com/triplewy/sagas/utils"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"gotest.tools/assert"
+)
+
+var (
+	chaosSagas = flag.Int("chaos.sagas", 0, "number of booking sagas the chaos test submits. 0 skips the test")
+	chaosSeed  = flag.Int64("chaos.seed", 0, "seed of the chaos test. 0 picks a seed from the time")
+)
+
+const (
+	chaosMinUptime   = 100 * time.Millisecond
+	chaosMaxUptime   = 500 * time.Millisecond
+	chaosMaxInterval = 50 * time.Millisecond
+	chaosTimeout     = time.Minute
+)
+
+// chaosProcess runs one of the repo's commands as a subprocess
+type chaosProcess struct {
+	bin  string
+	args []string
+	log  *os.File
+	cmd  *exec.Cmd
+}
+
+func (p *chaosProcess) start(t *testing.T) {
+	p.cmd = exec.Command(p.bin, p.args...)
+	p.cmd.Stdout = p.log
+	p.cmd.Stderr = p.log
+	assert.NilError(t, p.cmd.Start())
+}
+
+// kill sends SIGKILL, so the process gets no chance to flush or close anything
+func (p *chaosProcess) kill(t *testing.T) {
+	assert.NilError(t, p.cmd.Process.Kill())
+	p.cmd.Wait()
+}
+
+func (p *chaosProcess) stop(t *testing.T) {
+	assert.NilError(t, p.cmd.Process.Signal(os.Interrupt))
+	assert.NilError(t, p.cmd.Wait())
+}
+
+// chaosBuild builds cmd/<name> into dir
+func chaosBuild(t *testing.T, dir, name string) string {
+	bin := filepath.Join(dir, name)
+	out, err := exec.Command("go", "build", "-o", bin, "./cmd/"+name).CombinedOutput()
+	assert.NilError(t, err, string(out))
+	return bin
+}
+
+// chaosPort picks a free port
+func chaosPort() string {
+	_, port, err := net.SplitHostPort(utils.AvailableAddr())
+	if err != nil {
+		panic(err)
+	}
+	return port
+}
+
+// waitHTTP polls url until it responds or the chaos timeout passes
+func waitHTTP(t *testing.T, url string, ok func(*http.Response) bool) {
+	deadline := time.Now().Add(chaosTimeout)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url)
+		if err == nil {
+			done := ok(resp)
+			resp.Body.Close()
+			if done {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v", url)
+}
+
+// bookingSaga books two rooms for a user, one after the other
+func bookingSaga(gateway, userID, first, second string) *SagaMsg {
+	vertex := func(id, roomID string) *Vertex {
+		return &Vertex{
+			Id: id,
+			T: &Func{
+				Url:    gateway + "/book",
+				Method: "POST",
+				Body:   map[string]string{"userID": userID, "roomID": roomID},
+			},
+			C: &Func{
+				Url:    gateway + "/cancel",
+				Method: "POST",
+				Body:   map[string]string{"userID": userID},
+			},
+			TransferFields: []string{"reservationID"},
+		}
+	}
+	return &SagaMsg{
+		Vertices: map[string]*Vertex{"first": vertex("first", first), "second": vertex("second", second)},
+		Edges:    []*Edge{{StartId: "first", EndId: "second"}},
+	}
+}
+
+// Builds cmd/coordinator and cmd/hotels, submits booking sagas while repeatedly
+// killing the coordinator with SIGKILL and checks the hotels' reservations against
+// the outcome of every saga in the coordinator's on-disk log
+func TestCoordinatorChaos(t *testing.T) {
+	if *chaosSagas == 0 {
+		t.Skip("set -chaos.sagas to run")
+	}
+	seed := *chaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %v", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	dir := t.TempDir()
+	logFile, err := os.Create(filepath.Join(dir, "output.log"))
+	assert.NilError(t, err)
+	defer logFile.Close()
+
+	hotelsPort, gatewayPort, coordinatorPort, adminPort := chaosPort(), chaosPort(), chaosPort(), chaosPort()
+	gateway := "http://localhost:" + gatewayPort
+	admin := "http://localhost:" + adminPort
+	logPath := filepath.Join(dir, "logs")
+
+	h := &chaosProcess{
+		bin:  chaosBuild(t, dir, "hotels"),
+		args: []string{"-addr", ":" + hotelsPort, "-http", ":" + gatewayPort},
+		log:  logFile,
+	}
+	h.start(t)
+	defer h.kill(t)
+	waitHTTP(t, gateway+"/reservations", func(*http.Response) bool { return true })
+
+	c := &chaosProcess{
+		bin: chaosBuild(t, dir, "coordinator"),
+		args: []string{
+			"-addr", ":" + coordinatorPort,
+			"-path", logPath,
+			"-metrics", "",
+			"-admin", ":" + adminPort,
+			"-log-level", "warn",
+		},
+		log: logFile,
+	}
+	c.start(t)
+
+	// Submit sagas in the background. Rooms are drawn from a small pool so some
+	// bookings conflict and their sagas compensate
+	client := NewClient("localhost:" + coordinatorPort)
+	rooms := 3 * *chaosSagas
+	submitted := make(chan struct{})
+	go func() {
+		defer close(submitted)
+		for i := 0; i < *chaosSagas; i++ {
+			first, second := r.Intn(rooms), r.Intn(rooms-1)
+			if second >= first {
+				second++
+			}
+			msg := bookingSaga(gateway, fmt.Sprintf("user%v", i), fmt.Sprintf("room%v", first), fmt.Sprintf("room%v", second))
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), chaosTimeout)
+				defer cancel()
+				// Fails if the coordinator is killed first, in which case the saga
+				// may or may not have been logged
+				client.StartSagaRPC(ctx, msg, grpc.WaitForReady(true))
+			}()
+			time.Sleep(time.Duration(r.Int63n(int64(chaosMaxInterval))))
+		}
+	}()
+
+	kills := 0
+	for running := true; running; {
+		uptime := chaosMinUptime + time.Duration(r.Int63n(int64(chaosMaxUptime-chaosMinUptime)))
+		select {
+		case <-submitted:
+			running = false
+		case <-time.After(uptime):
+			c.kill(t)
+			kills++
+			c.start(t)
+		}
+	}
+	t.Logf("killed coordinator %v times", kills)
+
+	// The server starts once every saga is recovered. Then let the last
+	// coordinator finish them and read its logs
+	ctx, cancel := context.WithTimeout(context.Background(), chaosTimeout)
+	defer cancel()
+	cc, err := grpc.Dial("localhost:"+coordinatorPort, grpc.WithInsecure())
+	assert.NilError(t, err)
+	defer cc.Close()
+	health, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	assert.NilError(t, err)
+	assert.Equal(t, health.Status, healthpb.HealthCheckResponse_SERVING)
+	waitHTTP(t, admin+"/api/sagas", func(resp *http.Response) bool {
+		var list []adminSaga
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return false
+		}
+		for _, s := range list {
+			if s.State != stateCommitted && s.State != stateCompensated {
+				return false
+			}
+		}
+		return true
+	})
+	c.stop(t)
+
+	config := DefaultConfig()
+	config.Path = logPath
+	store, err := config.NewReadOnlyLogStore()
+	assert.NilError(t, err)
+	defer store.Close()
+	recovered, err := Recover(store)
+	assert.NilError(t, err)
+
+	resp, err := http.Get(gateway + "/reservations")
+	assert.NilError(t, err)
+	var reservations []hotels.Reservation
+	assert.NilError(t, json.NewDecoder(resp.Body).Decode(&reservations))
+	resp.Body.Close()
+
+	booked := make(map[string]int)
+	canceled := make(map[string]int)
+	for _, res := range reservations {
+		switch res.Status {
+		case hotels.Booked:
+			booked[res.UserID]++
+		case hotels.Canceled:
+			canceled[res.UserID]++
+		}
+	}
+
+	outcomes := make(map[string]bool)
+	committed := 0
+	for id, saga := range recovered {
+		first, ok := saga.getVtx("first")
+		assert.Assert(t, ok, "saga %v", id)
+		user := first.T.Body["userID"]
+
+		finished, aborted := CheckFinishedOrAbort(saga)
+		assert.Assert(t, finished, "saga %v of %v did not finish", id, user)
+		outcomes[user] = !aborted
+		if aborted {
+			assert.Equal(t, booked[user], 0, "compensated saga %v of %v left rooms booked", id, user)
+			// Every compensated vertex canceled a booking of its own
+			compensated := 0
+			saga.Vertices.IterCb(func(key string, value interface{}) {
+				if value.(Vertex).Status == Status_END_C {
+					compensated++
+				}
+			})
+			assert.Equal(t, canceled[user], compensated, "compensated saga %v of %v", id, user)
+		} else {
+			committed++
+			assert.Equal(t, booked[user], 2, "committed saga %v of %v", id, user)
+			assert.Equal(t, canceled[user], 0, "committed saga %v of %v canceled a booking", id, user)
+		}
+	}
+	for _, res := range reservations {
+		_, ok := outcomes[res.UserID]
+		assert.Assert(t, ok, "reservation %v of %v belongs to no saga", res.ID, res.UserID)
+	}
+	t.Logf("%v of %v logged sagas committed, %v reservations", committed, len(recovered), len(reservations))
+}
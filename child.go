@@ -0,0 +1,133 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrChildNotCompensatable is returned when compensating a child saga that is in
+// forward recovery or past its pivot. Neither is ever compensated
+var ErrChildNotCompensatable = errors.New("child saga is in forward recovery or past its pivot so it cannot be compensated")
+
+// runChild starts the child saga of a vertex, or waits on it if it has already
+// started, and returns once the child finishes. An aborted child fails the vertex.
+// Returns ErrCoordinatorClosed if the coordinator closes first
+func (c *Coordinator) runChild(ctx context.Context, sagaID string, vertex Vertex) error {
+	replyCh := make(chan Saga, 1)
+
+	c.mtx.Lock()
+	child, ok := c.sagas[vertex.SagaId]
+	if ok {
+		// Started before a crash and recovered
+		if finished, _ := CheckFinishedOrAbort(child); !finished {
+			c.requests[child.ID] = replyCh
+			c.mtx.Unlock()
+			if child, ok = c.waitChild(replyCh); !ok {
+				return ErrCoordinatorClosed
+			}
+		} else {
+			c.mtx.Unlock()
+		}
+	} else {
+		c.mtx.Unlock()
+
+		// Copy the definition, since the child's vertices are updated in place
+		child = protoToSaga(proto.Clone(vertex.Saga).(*SagaMsg))
+		child.ID = vertex.SagaId
+		child.Parent, child.ParentVertex = sagaID, vertex.Id
+		if err := c.setRequestIDs(child); err != nil {
+			return err
+		}
+		c.createCh <- createMsg{
+			ctx:     ctx,
+			saga:    child,
+			replyCh: replyCh,
+			sent:    c.clock.Now(),
+		}
+		if child, ok = c.waitChild(replyCh); !ok {
+			return ErrCoordinatorClosed
+		}
+	}
+
+	if _, aborted := CheckFinishedOrAbort(child); aborted {
+		return ErrSagaAborted
+	}
+	return nil
+}
+
+// compensateChild compensates the child saga of a vertex and returns once the child
+// is compensated. A committed child is aborted, which compensates its vertices. A
+// child in forward recovery or past its pivot fails with ErrChildNotCompensatable
+func (c *Coordinator) compensateChild(vertex Vertex) error {
+	replyCh := make(chan Saga, 1)
+
+	c.mtx.Lock()
+	child, ok := c.sagas[vertex.SagaId]
+	if !ok {
+		// The child never started, so there is nothing to compensate
+		c.mtx.Unlock()
+		return nil
+	}
+	// Aborting such a child would not compensate it, so nothing would ever reply
+	if child.Recovery == RecoveryMode_FORWARD || pivotStarted(child) {
+		c.mtx.Unlock()
+		return ErrChildNotCompensatable
+	}
+	finished, aborted := CheckFinishedOrAbort(child)
+	if finished && aborted {
+		c.mtx.Unlock()
+		return nil
+	}
+	c.requests[child.ID] = replyCh
+	if !child.aborted.Load() {
+		if finished {
+			c.metrics.sagasInFlight.Inc()
+		}
+		if err := c.abort(child, "parent "+child.Parent); err != nil {
+			delete(c.requests, child.ID)
+			c.mtx.Unlock()
+			return err
+		}
+	}
+	c.mtx.Unlock()
+
+	if _, ok := c.waitChild(replyCh); !ok {
+		return ErrCoordinatorClosed
+	}
+	return nil
+}
+
+// waitChild waits for a child saga to finish. It returns false if the coordinator
+// closes first, so recovery resumes the waiting vertex
+func (c *Coordinator) waitChild(replyCh chan Saga) (Saga, bool) {
+	select {
+	case child := <-replyCh:
+		return child, true
+	case <-c.stop:
+		return Saga{}, false
+	}
+}
+
+// childrenFirst orders recovered sagas so that every child saga comes before its
+// parent, then by id. A parent resuming a vertex then finds the child instead of
+// starting it again
+func childrenFirst(sagas map[string]Saga) []Saga {
+	depth := make(map[string]int, len(sagas))
+	res := make([]Saga, 0, len(sagas))
+	for id, saga := range sagas {
+		for parent, ok := sagas[saga.Parent]; ok; parent, ok = sagas[parent.Parent] {
+			depth[id]++
+		}
+		res = append(res, saga)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if depth[res[i].ID] != depth[res[j].ID] {
+			return depth[res[i].ID] > depth[res[j].ID]
+		}
+		return lessID(res[i].ID, res[j].ID)
+	})
+	return res
+}
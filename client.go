@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/triplewy/sagas/utils"
 	"google.golang.org/grpc"
 )
 
 // NewClient creates a new gRPC client to coordinator
 func NewClient(addr string) CoordinatorClient {
-	cc, err := grpc.Dial(addr, grpc.WithInsecure())
+	cc, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithUnaryInterceptor(utils.ClientInterceptor))
 	if err != nil {
 		panic(err)
 	}
@@ -65,6 +66,77 @@ func LocalSaga(c CoordinatorClient, dag map[string]map[string]struct{}) (Saga, e
 	return protoToSaga(resp), nil
 }
 
+// StartDefinition starts a saga described by a definition with its params filled in
+func StartDefinition(c CoordinatorClient, def *Definition, params map[string]string) (Saga, error) {
+	msg, err := def.SagaMsg(params)
+	if err != nil {
+		return Saga{}, err
+	}
+	resp, err := c.StartSagaRPC(context.Background(), msg)
+	if err != nil {
+		return Saga{}, err
+	}
+	return protoToSaga(resp), nil
+}
+
+// RegisterTemplate registers a new version of a named template and returns the version
+func RegisterTemplate(c CoordinatorClient, name string, def *Definition) (uint64, error) {
+	data, err := def.Marshal(DefinitionJSON)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.RegisterTemplateRPC(context.Background(), &TemplateMsg{
+		Name:       name,
+		Definition: string(data),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return resp.GetVersion(), nil
+}
+
+// ListTemplates lists every version of every template registered on the coordinator
+func ListTemplates(c CoordinatorClient) ([]Template, error) {
+	resp, err := c.ListTemplatesRPC(context.Background(), &ListTemplatesMsg{})
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]Template, 0, len(resp.GetTemplates()))
+	for _, msg := range resp.GetTemplates() {
+		t, err := protoToTemplate(msg)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// StartTemplate starts a saga from a registered template. Version 0 starts the latest version
+func StartTemplate(c CoordinatorClient, name string, version uint64, params map[string]string) (Saga, error) {
+	resp, err := c.StartTemplateRPC(context.Background(), &StartTemplateMsg{
+		Name:    name,
+		Version: version,
+		Params:  params,
+	})
+	if err != nil {
+		return Saga{}, err
+	}
+	return protoToSaga(resp), nil
+}
+
+// GetSagaAt returns a saga as the coordinator saw it at lsn. An lsn of 0 returns the saga's latest state
+func GetSagaAt(c CoordinatorClient, sagaID string, lsn uint64) (Snapshot, error) {
+	resp, err := c.SagaAtRPC(context.Background(), &SagaAtMsg{
+		SagaId: sagaID,
+		Lsn:    lsn,
+	})
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return protoToSnapshot(resp), nil
+}
+
 // BookRoom starts a new saga that makes a single transaction to book a hotel room
 func BookRoom(c CoordinatorClient, userID, roomID string) error {
 	vertices := map[string]*Vertex{
@@ -3,6 +3,9 @@ package main
 import (
 	"errors"
 	"flag"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/abiosoft/ishell"
 
@@ -11,6 +14,8 @@ import (
 
 var (
 	ErrInvalidNumArgs = errors.New("Number of args provided is not valid")
+	ErrUnknownFormat  = errors.New("Format must be ascii or dot")
+	ErrInvalidParam   = errors.New("Params must be given as param=value")
 )
 var addr string
 
@@ -43,5 +48,214 @@ func main() {
 		},
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "submit",
+		Help: "submit <file> [param=value...]: start a saga from a yaml or json definition",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) < 1 {
+				c.Err(ErrInvalidNumArgs)
+				return
+			}
+			def, err := sagas.ReadDefinition(c.Args[0])
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			params, err := parseParams(c.Args[1:])
+			if err != nil {
+				c.Err(err)
+				return
+			}
+			saga, err := sagas.StartDefinition(client, def, params)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			c.Print(saga.ASCII())
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "register",
+		Help: "register <name> <file>: register a definition file as a new version of a template",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) != 2 {
+				c.Err(ErrInvalidNumArgs)
+				return
+			}
+			def, err := sagas.ReadDefinition(c.Args[1])
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			version, err := sagas.RegisterTemplate(client, c.Args[0], def)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			c.Printf("Registered %v version %v\n", c.Args[0], version)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "templates",
+		Help: "templates: list registered templates and their versions",
+		Func: func(c *ishell.Context) {
+			templates, err := sagas.ListTemplates(client)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			for _, t := range templates {
+				c.Printf("%v\tv%v\tparams=%v\n", t.Name, t.Version, t.Definition.Params)
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "start",
+		Help: "start <name>[@version] [param=value...]: start a saga from a template, by default its latest version",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) < 1 {
+				c.Err(ErrInvalidNumArgs)
+				return
+			}
+			name, version := c.Args[0], uint64(0)
+			if i := strings.LastIndex(name, "@"); i >= 0 {
+				var err error
+				if version, err = strconv.ParseUint(name[i+1:], 10, 64); err != nil {
+					c.Err(err)
+					return
+				}
+				name = name[:i]
+			}
+			params, err := parseParams(c.Args[1:])
+			if err != nil {
+				c.Err(err)
+				return
+			}
+			saga, err := sagas.StartTemplate(client, name, version, params)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			c.Print(saga.ASCII())
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "at",
+		Help: "at <sagaID> [lsn]: show a saga as of lsn, or its latest state",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) != 1 && len(c.Args) != 2 {
+				c.Err(ErrInvalidNumArgs)
+				return
+			}
+			var lsn uint64
+			if len(c.Args) == 2 {
+				var err error
+				if lsn, err = strconv.ParseUint(c.Args[1], 10, 64); err != nil {
+					c.Err(err)
+					return
+				}
+			}
+			snapshot, err := sagas.GetSagaAt(client, c.Args[0], lsn)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			printSnapshot(c, snapshot)
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "step",
+		Help: "step <sagaID>: replay a saga one log at a time. Enter steps, q quits",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) != 1 {
+				c.Err(ErrInvalidNumArgs)
+				return
+			}
+			lsn := uint64(1)
+			for {
+				snapshot, err := sagas.GetSagaAt(client, c.Args[0], lsn)
+				if err != nil {
+					c.Println(err)
+					return
+				}
+				printSnapshot(c, snapshot)
+				if snapshot.NextLsn == 0 {
+					c.Println("End of log")
+					return
+				}
+				if c.ReadLine() == "q" {
+					return
+				}
+				lsn = snapshot.NextLsn
+			}
+		},
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "render",
+		Help: "render <sagaID> [ascii|dot]: draw a saga's DAG with vertex statuses",
+		Func: func(c *ishell.Context) {
+			if len(c.Args) != 1 && len(c.Args) != 2 {
+				c.Err(ErrInvalidNumArgs)
+				return
+			}
+			format := "ascii"
+			if len(c.Args) == 2 {
+				format = c.Args[1]
+			}
+			snapshot, err := sagas.GetSagaAt(client, c.Args[0], 0)
+			if err != nil {
+				c.Println(err)
+				return
+			}
+			switch format {
+			case "ascii":
+				c.Print(snapshot.Saga.ASCII())
+			case "dot":
+				c.Print(snapshot.Saga.DOT())
+			default:
+				c.Err(ErrUnknownFormat)
+			}
+		},
+	})
+
 	shell.Run()
 }
+
+// parseParams parses param=value arguments
+func parseParams(args []string) (map[string]string, error) {
+	params := make(map[string]string, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidParam
+		}
+		params[kv[0]] = kv[1]
+	}
+	return params, nil
+}
+
+func printSnapshot(c *ishell.Context, snapshot sagas.Snapshot) {
+	c.Printf("Saga %v at lsn %v (finished: %v, aborted: %v)\n", snapshot.Saga.ID, snapshot.Lsn, snapshot.Finished, snapshot.Aborted)
+
+	ids := make([]string, 0, len(snapshot.Saga.DAG))
+	for id := range snapshot.Saga.DAG {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		item, _ := snapshot.Saga.Vertices.Get(id)
+		c.Printf("\t%v\t%v\n", id, item.(sagas.Vertex).Status)
+	}
+
+	scheduled := make([]string, len(snapshot.Scheduled))
+	for i, vtx := range snapshot.Scheduled {
+		scheduled[i] = vtx.Id
+	}
+	c.Printf("Scheduled next: %v\n", scheduled)
+}
@@ -3,31 +3,84 @@ package main
 import (
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 
 	"github.com/triplewy/sagas"
 )
 
-var addr string
+var (
+	addr        string
+	path        string
+	metricsAddr string
+	adminAddr   string
+	logFormat   string
+	logLevel    string
+	migrate     bool
+)
 
 func init() {
 	flag.StringVar(&addr, "addr", ":50050", "server address")
+	flag.StringVar(&path, "path", "", "log store directory, empty to keep logs in memory")
+	flag.StringVar(&metricsAddr, "metrics", ":2112", "metrics address, empty to disable")
+	flag.StringVar(&adminAddr, "admin", "localhost:8080", "admin dashboard address, empty to disable")
+	flag.StringVar(&logFormat, "log-format", sagas.LogFormatText, "log format: text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level: debug, info, warn or error")
+	flag.BoolVar(&migrate, "migrate", false, "rewrite logs into the current format and exit")
 }
 
 func main() {
 	flag.Parse()
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, os.Interrupt)
+
 	config := sagas.DefaultConfig()
+	config.MetricsAddr = metricsAddr
+	config.AdminAddr = adminAddr
+	if path != "" {
+		config.Path = path
+		config.InMemory = false
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		log.Fatal(err)
+	}
+	config.Logger = sagas.NewLogger(os.Stderr, logFormat, level)
 
-	c := sagas.NewCoordinator(config, sagas.NewBadgerDB(config.Path, config.InMemory))
-	defer c.Cleanup()
+	if err := config.ReadEncryptionKeys(); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := config.NewLogStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if migrate {
+		n, err := store.Migrate()
+		store.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Migrated %v logs to version %v\n", n, sagas.LogVersion)
+		return
+	}
+
+	logs := sagas.NewBatchLogStore(store, sagas.DefaultMaxBatch)
+	c := sagas.NewCoordinator(config, logs)
+	if config.InMemory {
+		defer c.Cleanup()
+	} else {
+		defer c.Close()
+	}
 
 	s := sagas.NewServer(addr, c)
 	defer s.GracefulStop()
 
 	log.Printf("Server listening on %v\n", addr)
 
-	terminate := make(chan os.Signal, 1)
-	signal.Notify(terminate, os.Interrupt)
 	<-terminate
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/triplewy/sagas"
+)
+
+var (
+	crashes int
+	failC   bool
+)
+
+func init() {
+	flag.IntVar(&crashes, "crashes", 1, "coordinator crashes in a single execution")
+	flag.BoolVar(&failC, "failc", false, "let compensations fail")
+}
+
+// Explores every execution of the saga in a definition file and prints the
+// shortest trace to each broken invariant
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: explore [-crashes n] [-failc] <definition>")
+	}
+
+	def, err := sagas.ReadDefinition(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	vertices := make(map[string]sagas.Vertex, len(def.Vertices))
+	dag := make(map[string]map[string][]string, len(def.Vertices))
+	for id, vtx := range def.Vertices {
+		vertices[id] = sagas.Vertex{
+			Id:              id,
+			UncertainPolicy: sagas.UncertainPolicy(sagas.UncertainPolicy_value[vtx.UncertainPolicy]),
+			C:               &sagas.Func{Url: vtx.C.URL, Method: vtx.C.Method},
+			Kind:            sagas.VertexKind(sagas.VertexKind_value[vtx.Kind]),
+		}
+		if vtx.Saga != nil {
+			vertices[id] = sagas.Vertex{Id: id, Saga: &sagas.SagaMsg{}}
+		}
+		dag[id] = make(map[string][]string)
+	}
+	predicates := make(map[string]map[string]string)
+	for _, edge := range def.Edges {
+		dag[edge.From][edge.To] = edge.TransferFields
+		if edge.Predicate != "" {
+			if predicates[edge.From] == nil {
+				predicates[edge.From] = make(map[string]string)
+			}
+			predicates[edge.From][edge.To] = edge.Predicate
+		}
+	}
+
+	saga := sagas.NewSaga(vertices, dag)
+	saga.Predicates = predicates
+	saga.Recovery = sagas.RecoveryMode(sagas.RecoveryMode_value[def.Recovery])
+	saga.Compensation = sagas.CompensationOrder(sagas.CompensationOrder_value[def.Compensation])
+	res, err := sagas.Explore(saga, sagas.ExploreOptions{MaxCrashes: crashes, FailC: failC})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("explored %v states\n", res.States)
+	for _, v := range res.Violations {
+		fmt.Printf("\n%v\n  state %v\n", v.Err, v.State)
+		for i, event := range v.Trace {
+			fmt.Printf("  %3d %v\n", i+1, event)
+		}
+	}
+	if len(res.Violations) > 0 {
+		os.Exit(1)
+	}
+}
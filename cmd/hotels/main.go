@@ -9,18 +9,27 @@ import (
 	"github.com/triplewy/sagas/hotels"
 )
 
-var addr string
+var (
+	addr     string
+	httpAddr string
+)
 
 func init() {
 	flag.StringVar(&addr, "addr", ":50051", "address for hotels server")
+	flag.StringVar(&httpAddr, "http", "", "address for JSON over HTTP gateway, empty to disable")
 }
 
 func main() {
 	flag.Parse()
 
-	hotels.NewServer(addr)
+	_, h := hotels.NewServer(addr)
 	log.Printf("Server listening on %v\n", addr)
 
+	if httpAddr != "" {
+		hotels.NewGateway(httpAddr, h)
+		log.Printf("Gateway listening on %v\n", httpAddr)
+	}
+
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, os.Interrupt)
 	<-terminate
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/triplewy/sagas"
+)
+
+// options selects the logs to dump and how to print them
+type options struct {
+	path    string
+	sagaID  string
+	logType string
+	from    uint64
+	to      uint64
+	format  string
+	summary bool
+}
+
+// vertexEntry is a vertex with a readable status
+type vertexEntry struct {
+	ID             string      `json:"id"`
+	Status         string      `json:"status"`
+	T              *sagas.Func `json:"t,omitempty"`
+	C              *sagas.Func `json:"c,omitempty"`
+	TransferFields []string    `json:"transferFields,omitempty"`
+}
+
+type sagaEntry struct {
+	ID       string                         `json:"id"`
+	Vertices []vertexEntry                  `json:"vertices"`
+	DAG      map[string]map[string][]string `json:"dag"`
+}
+
+type logEntry struct {
+	Lsn      uint64         `json:"lsn"`
+	SagaID   string         `json:"sagaID"`
+	Type     string         `json:"type"`
+	Saga     *sagaEntry     `json:"saga,omitempty"`
+	Vertex   *vertexEntry   `json:"vertex,omitempty"`
+	Template *templateEntry `json:"template,omitempty"`
+}
+
+type templateEntry struct {
+	Name       string            `json:"name"`
+	Version    uint64            `json:"version"`
+	Definition *sagas.Definition `json:"definition"`
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run parses args and dumps the selected logs of the store to w
+func run(args []string, w io.Writer) error {
+	var opts options
+	fs := flag.NewFlagSet("logdump", flag.ContinueOnError)
+	fs.StringVar(&opts.path, "path", sagas.DefaultConfig().Path, "log store directory")
+	fs.StringVar(&opts.sagaID, "saga", "", "only show logs of this saga")
+	fs.StringVar(&opts.logType, "type", "", "only show logs of this type: init, graph, vertex, abort or template")
+	fs.Uint64Var(&opts.from, "from", 0, "first LSN to show")
+	fs.Uint64Var(&opts.to, "to", math.MaxUint64, "last LSN to show")
+	fs.StringVar(&opts.format, "format", "text", "output format: text or json")
+	fs.BoolVar(&opts.summary, "summary", false, "show each saga's final vertex statuses instead of logs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown format %q", opts.format)
+	}
+
+	config := sagas.DefaultConfig()
+	config.Path = opts.path
+	if err := config.ReadEncryptionKeys(); err != nil {
+		return err
+	}
+
+	store, err := config.NewReadOnlyLogStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if opts.summary {
+		return dumpSummary(store, opts, w)
+	}
+	return dumpLogs(store, opts, w)
+}
+
+func dumpLogs(store sagas.LogStore, opts options, w io.Writer) error {
+	var filter sagas.LogType
+	if opts.logType != "" {
+		t, err := sagas.ParseLogType(opts.logType)
+		if err != nil {
+			return fmt.Errorf("%v: %q", err, opts.logType)
+		}
+		filter = t
+	}
+
+	var it sagas.LogIterator
+	if opts.sagaID != "" {
+		it = store.ScanSaga(opts.sagaID)
+	} else {
+		it = store.Scan(opts.from, opts.to)
+	}
+	defer it.Close()
+
+	enc := json.NewEncoder(w)
+	for it.Next() {
+		l := it.Log()
+		if l.Lsn < opts.from || l.Lsn > opts.to || (filter != 0 && l.LogType != filter) {
+			continue
+		}
+		entry, err := newLogEntry(l)
+		if err != nil {
+			return fmt.Errorf("log %v: %v", l.Lsn, err)
+		}
+		if opts.format == "json" {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		printLogEntry(w, entry)
+	}
+	return it.Err()
+}
+
+func dumpSummary(store sagas.LogStore, opts options, w io.Writer) error {
+	recovered, err := sagas.Recover(store)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(recovered))
+	for id := range recovered {
+		if opts.sagaID == "" || id == opts.sagaID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	enc := json.NewEncoder(w)
+	for _, id := range ids {
+		entry := newSagaEntry(recovered[id])
+		if opts.format == "json" {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(w, "saga %v\n", entry.ID)
+		for _, v := range entry.Vertices {
+			fmt.Fprintf(w, "\t%v\t%v\n", v.ID, v.Status)
+		}
+	}
+	return nil
+}
+
+func newLogEntry(l sagas.Log) (logEntry, error) {
+	entry := logEntry{
+		Lsn:    l.Lsn,
+		SagaID: l.SagaID,
+		Type:   strings.ToLower(l.LogType.GoString()),
+	}
+	switch l.LogType {
+	case sagas.GraphLog:
+		saga, err := l.Saga()
+		if err != nil {
+			return entry, err
+		}
+		s := newSagaEntry(saga)
+		entry.Saga = &s
+	case sagas.VertexLog:
+		vertex, err := l.Vertex()
+		if err != nil {
+			return entry, err
+		}
+		v := newVertexEntry(vertex)
+		entry.Vertex = &v
+	case sagas.TemplateLog:
+		t, err := l.Template()
+		if err != nil {
+			return entry, err
+		}
+		entry.Template = &templateEntry{Name: t.Name, Version: t.Version, Definition: t.Definition}
+	}
+	return entry, nil
+}
+
+func newSagaEntry(saga sagas.Saga) sagaEntry {
+	entry := sagaEntry{
+		ID:  saga.ID,
+		DAG: saga.DAG,
+	}
+	for _, item := range saga.Vertices.Items() {
+		entry.Vertices = append(entry.Vertices, newVertexEntry(item.(sagas.Vertex)))
+	}
+	sort.Slice(entry.Vertices, func(i, j int) bool {
+		return entry.Vertices[i].ID < entry.Vertices[j].ID
+	})
+	return entry
+}
+
+func newVertexEntry(vertex sagas.Vertex) vertexEntry {
+	return vertexEntry{
+		ID:             vertex.Id,
+		Status:         vertex.Status.String(),
+		T:              vertex.T,
+		C:              vertex.C,
+		TransferFields: vertex.TransferFields,
+	}
+}
+
+func printLogEntry(w io.Writer, entry logEntry) {
+	fmt.Fprintf(w, "%v\t%v\tsaga=%v", entry.Lsn, entry.Type, entry.SagaID)
+	switch {
+	case entry.Saga != nil:
+		for _, v := range entry.Saga.Vertices {
+			fmt.Fprintf(w, "\n\t%v\t%v\tchildren=%v", v.ID, v.Status, sortedKeys(entry.Saga.DAG[v.ID]))
+		}
+	case entry.Vertex != nil:
+		v := entry.Vertex
+		fmt.Fprintf(w, "\tvertex=%v\tstatus=%v", v.ID, v.Status)
+		if v.T != nil {
+			fmt.Fprintf(w, "\tt.resp=%v", v.T.Resp)
+		}
+		if v.C != nil && v.C.Resp != nil {
+			fmt.Fprintf(w, "\tc.resp=%v", v.C.Resp)
+		}
+	case entry.Template != nil:
+		fmt.Fprintf(w, "\ttemplate=%v\tversion=%v", entry.Template.Name, entry.Template.Version)
+	}
+	fmt.Fprintln(w)
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/triplewy/sagas"
+	"gotest.tools/assert"
+)
+
+// fixtureCoordinator runs a committed saga "0" and an aborted saga "1" and
+// registers a template on a coordinator with its log store in dir
+func fixtureCoordinator(t *testing.T, dir string) *sagas.Coordinator {
+	config := sagas.DefaultConfig()
+	config.Path = dir
+	config.InMemory = false
+	config.Logger = sagas.NewLogger(io.Discard, sagas.LogFormatText, slog.LevelError)
+	c := sagas.NewCoordinator(config, sagas.NewBadgerDB(dir, false))
+
+	for _, success := range []string{"1", "0"} {
+		_, err := c.StartSagaRPC(context.Background(), &sagas.SagaMsg{
+			Vertices: map[string]*sagas.Vertex{
+				"a": {
+					Id: "a",
+					T:  &sagas.Func{Method: "LOCAL", Body: map[string]string{"success": success}},
+					C:  &sagas.Func{Method: "LOCAL"},
+				},
+			},
+		})
+		assert.NilError(t, err)
+	}
+	def, err := sagas.ReadDefinition("../../testdata/definitions/book.yaml")
+	assert.NilError(t, err)
+	_, err = c.RegisterTemplate("book", def)
+	assert.NilError(t, err)
+	return c
+}
+
+// fixtureStore returns the directory of the closed log store of fixtureCoordinator
+func fixtureStore(t *testing.T) string {
+	dir := t.TempDir()
+	fixtureCoordinator(t, dir).Close()
+	return dir
+}
+
+func TestLogdump(t *testing.T) {
+	dir := fixtureStore(t)
+
+	// logdump must also read the store of a running coordinator and what it leaves
+	// on disk when it crashes
+	live := t.TempDir()
+	c := fixtureCoordinator(t, live)
+	defer c.Close()
+	crashed := t.TempDir()
+	copyDir(t, live, crashed)
+
+	corrupt := t.TempDir()
+	store := sagas.NewBadgerDB(corrupt, false)
+	_, err := store.AppendLog("0", sagas.VertexLog, []byte("corrupt"))
+	assert.NilError(t, err)
+	store.Close()
+
+	tests := []struct {
+		name   string
+		args   []string
+		output []string
+		err    string
+	}{
+		{
+			name: "all logs",
+			args: []string{"-path", dir},
+			output: []string{
+				"0\tinit\tsaga=0",
+				"1\tgraph\tsaga=0",
+				"\ta\tNOT_REACHED\tchildren=[]",
+				"2\tvertex\tsaga=0\tvertex=a\tstatus=START_T\tt.resp=map[]\tc.resp=map[]",
+				"3\tvertex\tsaga=0\tvertex=a\tstatus=END_T\tt.resp=map[success:1]\tc.resp=map[]",
+				"4\tgraph\tsaga=1",
+				"\ta\tNOT_REACHED\tchildren=[]",
+				"5\tvertex\tsaga=1\tvertex=a\tstatus=START_T\tt.resp=map[]\tc.resp=map[]",
+				"6\tvertex\tsaga=1\tvertex=a\tstatus=ABORT\tt.resp=map[error:aborted local request]\tc.resp=map[]",
+				"7\ttemplate\tsaga=\ttemplate=book\tversion=1",
+			},
+		},
+		{
+			name:   "templates",
+			args:   []string{"-path", dir, "-type", "template"},
+			output: []string{"7\ttemplate\tsaga=\ttemplate=book\tversion=1"},
+		},
+		{
+			name: "saga",
+			args: []string{"-path", dir, "-saga", "1"},
+			output: []string{
+				"4\tgraph\tsaga=1",
+				"\ta\tNOT_REACHED\tchildren=[]",
+				"5\tvertex\tsaga=1\tvertex=a\tstatus=START_T\tt.resp=map[]\tc.resp=map[]",
+				"6\tvertex\tsaga=1\tvertex=a\tstatus=ABORT\tt.resp=map[error:aborted local request]\tc.resp=map[]",
+			},
+		},
+		{
+			name: "unknown saga",
+			args: []string{"-path", dir, "-saga", "2"},
+		},
+		{
+			name: "type and range",
+			args: []string{"-path", dir, "-type", "vertex", "-from", "3", "-to", "5"},
+			output: []string{
+				"3\tvertex\tsaga=0\tvertex=a\tstatus=END_T\tt.resp=map[success:1]\tc.resp=map[]",
+				"5\tvertex\tsaga=1\tvertex=a\tstatus=START_T\tt.resp=map[]\tc.resp=map[]",
+			},
+		},
+		{
+			name: "saga and range",
+			args: []string{"-path", dir, "-saga", "0", "-from", "3", "-format", "json"},
+			output: []string{
+				`{"lsn":3,"sagaID":"0","type":"vertex","vertex":{"id":"a","status":"END_T","t":{"method":"LOCAL","request_id":"0","body":{"success":"1"},"resp":{"success":"1"}},"c":{"method":"LOCAL","request_id":"1"}}}`,
+			},
+		},
+		{
+			name: "summary",
+			args: []string{"-path", dir, "-summary"},
+			output: []string{
+				"saga 0",
+				"\ta\tEND_T",
+				"saga 1",
+				"\ta\tABORT",
+			},
+		},
+		{
+			name: "summary of saga",
+			args: []string{"-path", dir, "-summary", "-saga", "1", "-format", "json"},
+			output: []string{
+				`{"id":"1","vertices":[{"id":"a","status":"ABORT","t":{"method":"LOCAL","request_id":"2","body":{"success":"0"},"resp":{"error":"aborted local request"}},"c":{"method":"LOCAL","request_id":"3"}}],"dag":{"a":{}}}`,
+			},
+		},
+		{
+			name: "running coordinator",
+			args: []string{"-path", live, "-summary"},
+			output: []string{
+				"saga 0",
+				"\ta\tEND_T",
+				"saga 1",
+				"\ta\tABORT",
+			},
+		},
+		{
+			name:   "crashed coordinator",
+			args:   []string{"-path", crashed, "-type", "template"},
+			output: []string{"7\ttemplate\tsaga=\ttemplate=book\tversion=1"},
+		},
+		{
+			name: "unknown format",
+			args: []string{"-path", dir, "-format", "xml"},
+			err:  `unknown format "xml"`,
+		},
+		{
+			name: "unknown type",
+			args: []string{"-path", dir, "-type", "commit"},
+			err:  `"commit"`,
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-path", dir, "-lsn", "1"},
+			err:  "flag provided but not defined: -lsn",
+		},
+		{
+			name: "missing store",
+			args: []string{"-path", filepath.Join(dir, "missing")},
+			err:  "missing",
+		},
+		{
+			name:   "decode error",
+			args:   []string{"-path", corrupt},
+			output: []string{"0\tinit\tsaga=0"},
+			err:    "log 1: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := run(tt.args, &out)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+			} else {
+				assert.NilError(t, err)
+			}
+			var output string
+			if len(tt.output) > 0 {
+				output = strings.Join(tt.output, "\n") + "\n"
+			}
+			assert.Equal(t, out.String(), output)
+		})
+	}
+}
+
+// copyDir copies the files of src to dst
+func copyDir(t *testing.T, src, dst string) {
+	entries, err := os.ReadDir(src)
+	assert.NilError(t, err)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		assert.NilError(t, err)
+		assert.NilError(t, os.WriteFile(filepath.Join(dst, entry.Name()), data, 0644))
+	}
+}
@@ -0,0 +1,51 @@
+package sagas
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMissingCompensation is used when a vertex that changes state has no C
+var ErrMissingCompensation = errors.New("vertex that is not read-only has no compensation")
+
+// methodNoop marks a C that is never called
+const methodNoop = "NOOP"
+
+// noop returns whether f is omitted or marked NOOP, so there is nothing to call
+func (f *Func) noop() bool {
+	if f == nil {
+		return true
+	}
+	return f.Url == "" && f.Method == "" || strings.EqualFold(f.Method, methodNoop)
+}
+
+// noCompensation returns whether a vertex is compensated without calling anything
+func noCompensation(vertex Vertex) bool {
+	return vertex.Saga == nil && vertex.C.noop()
+}
+
+// checkCompensations checks that every vertex of a saga message and its child sagas
+// that may be compensated has a C, unless it is read-only. Pivots and retriable
+// vertices are never compensated, and child saga vertices compensate their child
+func checkCompensations(msg *SagaMsg) error {
+	for id, vtx := range msg.GetVertices() {
+		if child := vtx.GetSaga(); child != nil {
+			if err := checkCompensations(child); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkCompensation(id, vtx.GetKind(), vtx.GetReadOnly(), vtx.GetC().noop()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkCompensation(id string, kind VertexKind, readOnly, noop bool) error {
+	if noop && !readOnly && kind == VertexKind_COMPENSATABLE {
+		return fmt.Errorf("%w: vertex %v", ErrMissingCompensation, id)
+	}
+	return nil
+}
@@ -1,8 +1,15 @@
 package sagas
 
 import (
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Config for saga coordinator
@@ -12,6 +19,35 @@ type Config struct {
 	CoordinatorAddr string
 	AutoRecover     bool
 	InMemory        bool
+	// MetricsAddr serves prometheus metrics at /metrics if set
+	MetricsAddr string
+	// AdminAddr serves the admin dashboard if set. The dashboard has no authentication,
+	// so it should only listen on localhost or a trusted network
+	AdminAddr string
+	// Logger receives the coordinator's logs. DefaultConfig logs text at info level to
+	// stderr. If nil, logs are discarded
+	Logger *slog.Logger
+	// SpanExporter receives the coordinator's trace spans. If nil, spans go to the
+	// global OpenTelemetry tracer provider
+	SpanExporter sdktrace.SpanExporter
+	// Clock tells the coordinator the time. If nil, the system clock is used
+	Clock Clock
+	// Transport issues vertex requests. If nil, HTTPReq is used
+	Transport Transport
+	// Scheduler runs vertices. If nil, each vertex runs on its own goroutine
+	Scheduler Scheduler
+	// RetryInterval is the wait before retrying a failed C or a failed T that is retried
+	// forward. It doubles with each retry up to MaxRetryInterval. If zero, a second.
+	// A failed C is retried until it succeeds, since its saga cannot finish without
+	// it. Each retry counts in sagas_vertex_retries_total
+	RetryInterval time.Duration
+	// MaxRetryInterval caps the wait between retries. If zero, a minute
+	MaxRetryInterval time.Duration
+
+	// EncryptionKey encrypts logs at rest if set. Must be 16, 24 or 32 bytes
+	EncryptionKey []byte
+	// OldEncryptionKeys decrypt logs written before EncryptionKey was rotated in
+	OldEncryptionKeys [][]byte
 }
 
 // DefaultConfig provides default config for saga coordinator
@@ -22,5 +58,60 @@ func DefaultConfig() *Config {
 		CoordinatorAddr: ":50050",
 		AutoRecover:     true,
 		InMemory:        true,
+		Logger:          NewLogger(os.Stderr, LogFormatText, slog.LevelInfo),
+	}
+}
+
+// NewLogStore opens the log store described by the config
+func (c *Config) NewLogStore() (*Badger, error) {
+	keyring, err := c.keyring()
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedBadgerDB(c.Path, c.InMemory, keyring), nil
+}
+
+// NewReadOnlyLogStore opens the on-disk log store at Path without modifying it.
+// Stores that were not closed cleanly or are in use are opened from a snapshot
+func (c *Config) NewReadOnlyLogStore() (*Badger, error) {
+	keyring, err := c.keyring()
+	if err != nil {
+		return nil, err
+	}
+	store, err := OpenBadgerReadOnly(c.Path, keyring)
+	if errors.Is(err, ErrStoreNotClosed) {
+		return OpenBadgerSnapshot(c.Path, keyring)
+	}
+	return store, err
+}
+
+// ReadEncryptionKeys reads hex encoded keys from SAGAS_ENCRYPTION_KEY and the comma
+// separated SAGAS_OLD_ENCRYPTION_KEYS. Keys are read from the environment rather
+// than flags to keep them out of process listings
+func (c *Config) ReadEncryptionKeys() error {
+	if key := os.Getenv("SAGAS_ENCRYPTION_KEY"); key != "" {
+		buf, err := hex.DecodeString(key)
+		if err != nil {
+			return err
+		}
+		c.EncryptionKey = buf
+	}
+	if keys := os.Getenv("SAGAS_OLD_ENCRYPTION_KEYS"); keys != "" {
+		for _, key := range strings.Split(keys, ",") {
+			buf, err := hex.DecodeString(key)
+			if err != nil {
+				return err
+			}
+			c.OldEncryptionKeys = append(c.OldEncryptionKeys, buf)
+		}
+	}
+	return nil
+}
+
+// keyring returns nil if encryption is disabled
+func (c *Config) keyring() (*Keyring, error) {
+	if c.EncryptionKey == nil {
+		return nil, nil
 	}
+	return NewKeyring(c.EncryptionKey, c.OldEncryptionKeys...)
 }
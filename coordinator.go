@@ -1,8 +1,16 @@
 package sagas
 
 import (
+	"context"
 	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
 	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Errors from incorrect coordinator logic
@@ -13,16 +21,23 @@ var (
 	ErrInvalidFuncInputType  = errors.New("incorrect type for input field in saga func")
 	ErrSagaIDAlreadyExists   = errors.New("create saga's sagaID already exists")
 	ErrSagaIDNotFound        = errors.New("update sagaID does not exist in coordinator's map")
+	ErrSagaFinished          = errors.New("saga has already finished")
+	ErrSagaAlreadyAborted    = errors.New("saga has already been aborted")
+	ErrCoordinatorClosed     = errors.New("coordinator closed")
 )
 
 type updateMsg struct {
 	sagaID string
 	vertex Vertex
+	sent   time.Time
 }
 
 type createMsg struct {
+	// ctx carries the trace context of the request that started the saga
+	ctx     context.Context
 	saga    Saga
 	replyCh chan Saga
+	sent    time.Time
 }
 
 // Coordinator handles saga requests by calling RPCs and persisting logs to disk
@@ -37,11 +52,47 @@ type Coordinator struct {
 	createCh chan createMsg
 	updateCh chan updateMsg
 
+	logger    *slog.Logger
+	clock     Clock
+	transport Transport
+	scheduler Scheduler
+	// Waits before the first retry of a failed T and between later retries
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	// Closed when an unfinished saga is aborted, to end its retry waits
+	aborts map[string]chan struct{}
+	// Closed by Close, to end every retry wait and the loop of Run
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	metrics       *metrics
+	metricsServer *http.Server
+	adminServer   *http.Server
+
+	tracer         trace.Tracer
+	tracerProvider *sdktrace.TracerProvider
+	// Context of each unfinished saga's span
+	traces map[string]context.Context
+
+	// onInvalid, if set, is called instead of panicking when an update leaves a
+	// saga invalid. The saga is not processed further
+	onInvalid func(saga Saga, err error)
+
+	// Versions of each registered template, oldest first
+	templates   map[string][]Template
+	templateMtx sync.RWMutex
+
 	mtx sync.Mutex
 }
 
 // NewCoordinator creates a new coordinator based on a config
 func NewCoordinator(config *Config, logStore LogStore) *Coordinator {
+	return newCoordinator(config, logStore, nil)
+}
+
+// newCoordinator creates a coordinator that reports invalid sagas to onInvalid
+// instead of panicking, so that tests can collect them
+func newCoordinator(config *Config, logStore LogStore, onInvalid func(saga Saga, err error)) *Coordinator {
 	c := &Coordinator{
 		Config:   config,
 		logs:     logStore,
@@ -50,27 +101,80 @@ func NewCoordinator(config *Config, logStore LogStore) *Coordinator {
 
 		createCh: make(chan createMsg),
 		updateCh: make(chan updateMsg),
+
+		logger: config.Logger,
+		aborts: make(map[string]chan struct{}),
+		stop:   make(chan struct{}),
+
+		metrics:   newMetrics(),
+		traces:    make(map[string]context.Context),
+		onInvalid: onInvalid,
+		templates: make(map[string][]Template),
+	}
+	c.tracer, c.tracerProvider = newTracer(config.SpanExporter)
+	if c.logger == nil {
+		c.logger = discardLogger()
+	}
+	if c.clock = config.Clock; c.clock == nil {
+		c.clock = systemClock{}
+	}
+	if c.transport = config.Transport; c.transport == nil {
+		c.transport = HTTPReq
+	}
+	if c.scheduler = config.Scheduler; c.scheduler == nil {
+		c.scheduler = goScheduler{}
+	}
+	if c.retryInterval = config.RetryInterval; c.retryInterval == 0 {
+		c.retryInterval = time.Second
+	}
+	if c.maxRetryInterval = config.MaxRetryInterval; c.maxRetryInterval == 0 {
+		c.maxRetryInterval = time.Minute
+	}
+
+	if config.MetricsAddr != "" {
+		c.metricsServer = serveMetrics(config.MetricsAddr, c.metrics)
+	}
+	if config.AdminAddr != "" {
+		c.adminServer = serveAdmin(config.AdminAddr, c)
 	}
 
 	go c.Run()
 
+	// Templates are registrations rather than work in flight, so they are always recovered
+	templates, err := RecoverTemplates(c.logs)
+	if err != nil {
+		c.logger.Error("template recovery failed", logKeyError, err)
+		panic(err)
+	}
+	c.templates = templates
+
 	if config.AutoRecover {
-		sagas := Recover(c.logs)
-		for _, saga := range sagas {
-			c.createCh <- createMsg{saga: saga}
+		sagas, err := Recover(c.logs)
+		if err != nil {
+			c.logger.Error("recovery failed", logKeyError, err)
+			panic(err)
+		}
+		c.logger.Info("recovered sagas", "count", len(sagas))
+		for _, saga := range childrenFirst(sagas) {
+			c.createCh <- createMsg{saga: saga, sent: c.clock.Now()}
 		}
 	}
 
 	return c
 }
 
-// Run reads from update and create channels to serialize some operations
+// Run reads from update and create channels to serialize some operations, until
+// the coordinator closes
 func (c *Coordinator) Run() {
 	for {
 		select {
+		case <-c.stop:
+			return
 		case msg := <-c.updateCh:
+			c.metrics.queueWait.WithLabelValues("update").Observe(c.clock.Now().Sub(msg.sent).Seconds())
 			c.update(msg)
 		case msg := <-c.createCh:
+			c.metrics.queueWait.WithLabelValues("create").Observe(c.clock.Now().Sub(msg.sent).Seconds())
 			c.create(msg)
 		}
 	}
@@ -93,20 +197,46 @@ func (c *Coordinator) create(msg createMsg) {
 	// Check if saga is in a valid state
 	err := CheckValidSaga(saga)
 	if err != nil {
+		c.logger.Error("invalid saga", logKeySaga, saga.ID, logKeyError, err)
 		panic(err)
 	}
 
+	c.startSagaSpan(msg.ctx, saga, msg.replyCh == nil)
+	ctx := c.sagaContext(saga.ID)
+
+	log := c.logger.With(logKeySaga, saga.ID)
+
 	// Append new saga to log
-	c.logs.AppendLog(saga.ID, GraphLog, encodeSaga(saga))
+	lsn, err := c.appendLog(ctx, saga.ID, GraphLog, encodeSaga(saga))
+	if err != nil {
+		log.Error("append graph log failed", logKeyError, err)
+		panic(err)
+	}
+	log = log.With(logKeyLsn, lsn)
+	if msg.replyCh != nil {
+		log.Info("saga started", "vertices", saga.Vertices.Count())
+	} else {
+		log.Info("saga recovered")
+	}
+	if !saga.isAborted() {
+		c.skip(ctx, saga)
+	}
 
-	// Insert new saga and request and run new saga
+	// Insert new saga and request and run new saga. Recovered sagas have no request
 	c.sagas[saga.ID] = saga
-	c.requests[saga.ID] = msg.replyCh
+	if msg.replyCh != nil {
+		c.requests[saga.ID] = msg.replyCh
+		c.metrics.sagasStarted.Inc()
+	}
 
 	// Still need to check finished or aborted since recovery can create
 	// in-progress or finished sagas
 	finished, aborted := CheckFinishedOrAbort(saga)
+	if !finished {
+		c.metrics.sagasInFlight.Inc()
+	}
 	if finished {
+		c.endSagaSpan(saga.ID, aborted)
 		if replyCh, ok := c.requests[saga.ID]; ok {
 			replyCh <- saga
 			delete(c.requests, saga.ID)
@@ -114,32 +244,7 @@ func (c *Coordinator) create(msg createMsg) {
 		return
 	}
 
-	// Find vertices to process
-	process := SagaBFS(saga)
-
-	// Update in memory saga for each vertex to process
-	for _, vtx := range process {
-		if aborted {
-			// If aborted, status must be START_C
-			vtx.Status = Status_START_C
-		} else {
-			// If not aborted, status must be START_T
-			vtx.Status = Status_START_T
-		}
-		saga.Vertices.Set(vtx.Id, vtx)
-	}
-
-	// Update saga
-	c.sagas[saga.ID] = saga
-
-	// Run process vertices in parallel
-	for _, vtx := range process {
-		if aborted {
-			go c.ProcessC(saga.ID, vtx)
-		} else {
-			go c.ProcessT(saga.ID, vtx)
-		}
-	}
+	c.run(saga, createSchedule(saga), aborted)
 }
 
 func (c *Coordinator) update(msg updateMsg) {
@@ -159,29 +264,53 @@ func (c *Coordinator) update(msg updateMsg) {
 	saga.Vertices.Set(vertex.Id, vertex)
 	c.sagas[sagaID] = saga
 
+	log := c.logger.With(logKeySaga, sagaID, logKeyVertex, vertex.Id)
+
 	// Check if saga is in a valid state
 	err := CheckValidSaga(saga)
 	if err != nil {
+		log.Error("invalid saga", logKeyError, err)
+		if c.onInvalid != nil {
+			c.onInvalid(saga, err)
+			return
+		}
 		panic(err)
 	}
 
+	if !saga.isAborted() {
+		c.skip(c.sagaContext(sagaID), saga)
+	}
+
 	// This operation is sequential so each new update should
 	// have the latest state of the saga
 	finished, aborted := CheckFinishedOrAbort(saga)
 
 	// If saga is finished, reply to request and break
 	if finished {
+		c.endSagaSpan(saga.ID, aborted)
+		c.metrics.sagasInFlight.Dec()
+		if aborted {
+			c.metrics.sagasCompensated.Inc()
+			log.Info("saga compensated")
+		} else {
+			c.metrics.sagasCommitted.Inc()
+			log.Info("saga committed")
+		}
 		// Notify request that saga has finished
 		if replyCh, ok := c.requests[saga.ID]; ok {
 			replyCh <- saga
 			delete(c.requests, saga.ID)
 		}
+		delete(c.aborts, saga.ID)
 		return
 	}
 
 	// If saga is aborted but we have not marked it as aborted, set aborted to true
 	if aborted && !saga.aborted.Load() {
 		saga.aborted.Store(true)
+		c.wakeRetries(saga.ID)
+		c.metrics.sagasAborted.Inc()
+		log.Warn("saga aborted", "status", vertex.Status.String())
 	}
 
 	// Transfer fields to children
@@ -205,36 +334,186 @@ func (c *Coordinator) update(msg updateMsg) {
 		}
 	}()
 
-	// Find vertices to process
-	process := SagaBFS(saga)
+	c.run(saga, updateSchedule(saga, vertex.Id), aborted)
+}
+
+// Abort aborts an unfinished saga. Vertices whose T has committed are compensated and
+// no new T is started. T calls in flight finish first and are compensated if they commit.
+// A saga in forward recovery stops retrying instead and is not compensated
+func (c *Coordinator) Abort(sagaID string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	saga, ok := c.sagas[sagaID]
+	if !ok {
+		return ErrSagaIDNotFound
+	}
+	finished, aborted := CheckFinishedOrAbort(saga)
+	if finished {
+		return ErrSagaFinished
+	}
+	if aborted {
+		return ErrSagaAlreadyAborted
+	}
+	if pivotStarted(saga) {
+		return ErrSagaPastPivot
+	}
 
+	return c.abort(saga, "operator")
+}
+
+// abort logs that saga is aborted and compensates its committed vertices. Must hold c.mtx
+func (c *Coordinator) abort(saga Saga, by string) error {
+	ctx := c.sagaContext(saga.ID)
+	lsn, err := c.appendLog(ctx, saga.ID, AbortLog, nil)
+	if err != nil {
+		return err
+	}
+	saga.aborted.Store(true)
+	c.wakeRetries(saga.ID)
+	c.metrics.sagasAborted.Inc()
+	c.logger.Warn("saga aborted by "+by, logKeySaga, saga.ID, logKeyLsn, lsn)
+
+	// Compensate committed vertices now. START_T vertices are in flight and
+	// will send their own update
+	c.run(saga, updateSchedule(saga, ""), true)
+	return nil
+}
+
+// skip logs vertices that no taken edge reaches as SKIPPED, in id order. Must hold c.mtx
+func (c *Coordinator) skip(ctx context.Context, saga Saga) {
+	skipped := skipVertices(saga)
+	sort.Slice(skipped, func(i, j int) bool { return skipped[i].Id < skipped[j].Id })
+	for _, vtx := range skipped {
+		lsn, err := c.appendLog(ctx, saga.ID, VertexLog, encodeVertex(vtx))
+		if err != nil {
+			c.logger.Error("append vertex log failed", logKeySaga, saga.ID, logKeyVertex, vtx.Id, logKeyError, err)
+			panic(err)
+		}
+		c.logger.Debug("vertex skipped", logKeySaga, saga.ID, logKeyVertex, vtx.Id, logKeyLsn, lsn)
+	}
+}
+
+// run sets the status of each vertex to process in the in-memory saga and
+// then processes the vertices in parallel
+func (c *Coordinator) run(saga Saga, process []Vertex, aborted bool) {
+	// Sagas in forward recovery only re-issue T to learn the outcome of vertices in flight
+	if saga.Recovery == RecoveryMode_FORWARD {
+		aborted = false
+	}
 	// Update in memory saga for each vertex to process
-	for _, vtx := range process {
-		if aborted {
-			// If aborted, status must be START_C
-			vtx.Status = Status_START_C
-		} else {
-			// If not aborted, status must be START_T
-			vtx.Status = Status_START_T
+	for i, vtx := range process {
+		// A vertex already started was left behind by a crash
+		switch vtx.Status {
+		case Status_START_T:
+			c.metrics.vertexRetries.WithLabelValues(vtx.T.GetUrl(), "T").Inc()
+		case Status_START_C:
+			c.metrics.vertexRetries.WithLabelValues(vtx.C.GetUrl(), "C").Inc()
 		}
+		vtx.Status = processStatus(vtx, aborted)
 		saga.Vertices.Set(vtx.Id, vtx)
+		process[i] = vtx
 	}
 
 	// Update saga
 	c.sagas[saga.ID] = saga
 
-	// Run process vertices in parallel
+	// Run process vertices in parallel, started in id order so that simulations
+	// repeat. Each gets its own copy of T and C, since it writes their maps while
+	// the saga is read under c.mtx
+	sort.Slice(process, func(i, j int) bool { return process[i].Id < process[j].Id })
+	ctx := c.sagaContext(saga.ID)
 	for _, vtx := range process {
-		if aborted {
-			go c.ProcessC(saga.ID, vtx)
+		vtx := vtx.copy()
+		if vtx.Status == Status_START_C {
+			c.scheduler.Go(func() { c.ProcessC(ctx, saga.ID, vtx) })
 		} else {
-			go c.ProcessT(saga.ID, vtx)
+			c.scheduler.Go(func() { c.ProcessT(ctx, saga.ID, vtx) })
+		}
+	}
+}
+
+// createSchedule finds the vertices to process when a saga is created or recovered.
+// Since no vertex of a new saga is in flight, START_T and START_C vertices were
+// left behind by a crash and are run again
+func createSchedule(saga Saga) []Vertex {
+	process := SagaBFS(saga)
+	return append(process, stranded(saga, process)...)
+}
+
+// updateSchedule finds the vertices to process after an update of the updated vertex,
+// or "" after an abort. Other START_T and START_C vertices are already in flight and
+// will send their own update, but the updated vertex is not
+func updateSchedule(saga Saga, updated string) []Vertex {
+	var process []Vertex
+	for _, vtx := range SagaBFS(saga) {
+		if (vtx.Status == Status_START_T || vtx.Status == Status_START_C) && vtx.Id != updated {
+			continue
+		}
+		process = append(process, vtx)
+	}
+	return process
+}
+
+// stranded returns START_T and START_C vertices of an aborted saga that SagaBFS does not
+// reach, such as those below a parent yet to be compensated in TOPOLOGICAL order. Updates
+// skip them as in flight, so after a crash nothing would resume them
+func stranded(saga Saga, reachedVertices []Vertex) []Vertex {
+	if !saga.isAborted() {
+		return nil
+	}
+	reached := make(map[string]struct{}, len(reachedVertices))
+	for _, vtx := range reachedVertices {
+		reached[vtx.Id] = struct{}{}
+	}
+	var res []Vertex
+	saga.Vertices.IterCb(func(key string, value interface{}) {
+		vtx := value.(Vertex)
+		if _, ok := reached[key]; ok {
+			return
 		}
+		if vtx.Status == Status_START_T || vtx.Status == Status_START_C {
+			res = append(res, vtx)
+		}
+	})
+	return res
+}
+
+// processStatus returns the status a vertex found by SagaBFS is processed with
+func processStatus(vtx Vertex, aborted bool) Status {
+	// If not aborted, status must be START_T
+	if !aborted {
+		return Status_START_T
+	}
+	// A vertex left in START_T by a crash may or may not have committed its T.
+	// Unless its policy allows compensating blindly or it has nothing to compensate,
+	// re-issue T with the same request ID to learn the outcome first
+	if vtx.Status == Status_START_T && vtx.UncertainPolicy == UncertainPolicy_REISSUE_T && !noCompensation(vtx) {
+		return Status_START_T
 	}
+	// Otherwise status must be START_C
+	return Status_START_C
 }
 
 // Cleanup removes saga coordinator persistent state
 func (c *Coordinator) Cleanup() {
-	c.logs.Close()
+	c.Close()
 	c.logs.RemoveAll()
 }
+
+// Close shuts down the coordinator's servers and log store, keeping its logs.
+// Vertices waiting to retry or on a child saga stop without logging and resume
+// on recovery
+func (c *Coordinator) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	if c.metricsServer != nil {
+		c.metricsServer.Shutdown(context.Background())
+	}
+	if c.adminServer != nil {
+		c.adminServer.Shutdown(context.Background())
+	}
+	if c.tracerProvider != nil {
+		c.tracerProvider.Shutdown(context.Background())
+	}
+	c.logs.Close()
+}
@@ -1,13 +1,33 @@
 package sagas
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/triplewy/sagas/hotels"
 	"github.com/triplewy/sagas/utils"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 	"gotest.tools/assert"
 )
 
@@ -101,10 +121,864 @@ func TestCoordinatorLocal(t *testing.T) {
 			}
 		})
 	}
+}
+
+// TestCoordinatorGraphs runs every scenario in graphs.txt. A vertex expected to
+// abort fails its T only once every vertex expected to run has started, so the
+// outcome does not depend on timing
+func TestCoordinatorGraphs(t *testing.T) {
+	graphs, err := utils.ParseGraphFile("graphs.txt")
+	assert.NilError(t, err)
+
+	// Scenarios of graphs.txt the coordinator cannot produce, by position in the
+	// file, and the state it ends in instead. Both end with a child that ran
+	// although one of its parents aborted, which CheckValidSaga rejects. A vertex
+	// only starts once all of its parents committed, so the child is never reached
+	deviations := map[int]map[string]Status{
+		// 2 abort
+		6: {"0-0": Status_END_C, "0-2": Status_ABORT, "1-1": Status_NOT_REACHED},
+		// root abort
+		8: {"0-0": Status_END_C, "0-2": Status_ABORT, "1-1": Status_NOT_REACHED},
+	}
+
+	// State of the scenario being run
+	var (
+		mtx        sync.Mutex
+		running    int
+		started    map[string]bool
+		allStarted chan struct{}
+	)
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	config.Transport = func(ctx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error) {
+		// Only T has a url
+		if url != "" {
+			mtx.Lock()
+			if !started[url] {
+				started[url] = true
+				if len(started) == running {
+					close(allStarted)
+				}
+			}
+			wait, ch := body["success"] == "0", allStarted
+			mtx.Unlock()
+			if wait {
+				select {
+				case <-ch:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		}
+		return HTTPReq(ctx, url, method, requestID, body)
+	}
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	for i, graph := range graphs {
+		name, children, statuses, err := utils.ParseGraphText(graph)
+		assert.NilError(t, err)
+
+		t.Run(name, func(t *testing.T) {
+			expected := make(map[string]Vertex, len(statuses))
+			dag := make(map[string]map[string][]string, len(children))
+			for id, status := range statuses {
+				expected[id] = Vertex{Id: id, Status: Status(status)}
+				dag[id] = make(map[string][]string, len(children[id]))
+				for _, child := range children[id] {
+					dag[id][child] = nil
+				}
+			}
+			deviation, ok := deviations[i]
+			assert.Equal(t, CheckValidSaga(NewSaga(expected, dag)) != nil, ok, "scenario %v", i)
+			for id, status := range deviation {
+				expected[id] = Vertex{Id: id, Status: status}
+			}
+			assert.NilError(t, CheckValidSaga(NewSaga(expected, dag)))
+
+			vertices := make(map[string]Vertex, len(expected))
+			n := 0
+			for id, vtx := range expected {
+				success := "1"
+				if vtx.Status == Status_ABORT {
+					success = "0"
+				}
+				if vtx.Status != Status_NOT_REACHED {
+					n++
+				}
+				vertices[id] = localVertex(id, success, Status_NOT_REACHED)
+				vertices[id].T.Url = id
+			}
+
+			mtx.Lock()
+			running = n
+			started, allStarted = make(map[string]bool), make(chan struct{})
+			mtx.Unlock()
+
+			saga := NewSaga(vertices, dag)
+			saga.ID = strconv.Itoa(i)
+			replyCh := make(chan Saga, 1)
+			c.createCh <- createMsg{saga: saga, replyCh: replyCh, sent: time.Now()}
+			saga = <-replyCh
+
+			for id, vtx := range expected {
+				got, ok := saga.getVtx(id)
+				assert.Assert(t, ok)
+				assert.Equal(t, got.Status, vtx.Status, "vertex %v", id)
+			}
+		})
+	}
+}
+
+func TestCoordinatorBottomPeak(t *testing.T) {
+	// A child with two parents is not started while one parent is still in flight
+	var mtx sync.Mutex
+	started := make(map[string]bool)
+	release := make(chan struct{})
+
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	config.Transport = func(ctx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error) {
+		mtx.Lock()
+		started[url] = true
+		mtx.Unlock()
+		if url == "2" {
+			<-release
+		}
+		return HTTPReq(ctx, url, method, requestID, body)
+	}
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	vertices := make(map[string]Vertex, 3)
+	for _, id := range []string{"1", "2", "3"} {
+		vtx := localVertex(id, "1", Status_NOT_REACHED)
+		vtx.T.Url = id
+		vertices[id] = vtx
+	}
+	saga := NewSaga(vertices, map[string]map[string][]string{"1": {"3": nil}, "2": {"3": nil}, "3": {}})
+	saga.ID = "peak"
+
+	replyCh := make(chan Saga, 1)
+	c.createCh <- createMsg{saga: saga, replyCh: replyCh, sent: time.Now()}
+
+	// Wait for 1 to commit while 2 is held
+	for {
+		c.mtx.Lock()
+		s, ok := c.sagas[saga.ID]
+		c.mtx.Unlock()
+		if ok {
+			if vtx, _ := s.getVtx("1"); vtx.Status == Status_END_T {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mtx.Lock()
+	assert.Assert(t, !started["3"])
+	mtx.Unlock()
+
+	close(release)
+	saga = <-replyCh
+	for _, id := range []string{"1", "2", "3"} {
+		vtx, _ := saga.getVtx(id)
+		assert.Equal(t, vtx.Status, Status_END_T, "vertex %v", id)
+	}
+}
+
+func TestCoordinatorCanceledRequest(t *testing.T) {
+	// A caller that gives up on StartSagaRPC does not cancel the saga's requests
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	config.Transport = func(reqCtx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error) {
+		if url == "1" {
+			cancel()
+		}
+		return HTTPReq(reqCtx, url, method, requestID, body)
+	}
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	vertices := make(map[string]*Vertex, 2)
+	for _, id := range []string{"1", "2"} {
+		vtx := localVertex(id, "1", Status_NOT_REACHED)
+		vtx.T.Url = id
+		vtx.T.Body["delay"] = "10ms"
+		vertices[id] = &vtx
+	}
+	replyCh := make(chan *SagaMsg, 1)
+	go func() {
+		reply, err := c.StartSagaRPC(ctx, &SagaMsg{Vertices: vertices, Edges: []*Edge{{StartId: "1", EndId: "2"}}})
+		assert.Check(t, err)
+		replyCh <- reply
+	}()
+
+	select {
+	case reply := <-replyCh:
+		for _, id := range []string{"1", "2"} {
+			assert.Equal(t, reply.Vertices[id].Status, Status_END_T, "vertex %v", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("saga did not finish after its request was canceled")
+	}
+}
+
+func TestCoordinatorUncertain(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  Status
+		policy  UncertainPolicy
+		success string
+		expect  Status
+	}{
+		{"forward reissue", Status_END_T, UncertainPolicy_REISSUE_T, "1", Status_END_T},
+		{"abort reissue success", Status_ABORT, UncertainPolicy_REISSUE_T, "1", Status_END_C},
+		{"abort reissue fail", Status_ABORT, UncertainPolicy_REISSUE_T, "0", Status_ABORT},
+		{"abort blind", Status_ABORT, UncertainPolicy_BLIND_C, "0", Status_END_C},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			logs := NewBadgerDB(config.Path, config.InMemory)
+
+			// Persist saga as if coordinator crashed while "2" was in START_T
+			uncertain := localVertex("2", tt.success, Status_START_T)
+			uncertain.UncertainPolicy = tt.policy
+			saga := NewSaga(map[string]Vertex{
+				"1": localVertex("1", "1", tt.status),
+				"2": uncertain,
+			}, map[string]map[string][]string{"1": {}, "2": {}})
+			sagaID, err := logs.NewSagaID()
+			assert.NilError(t, err)
+			saga.ID = sagaID
+			mustAppendLog(t, logs, saga.ID, GraphLog, encodeSaga(saga))
+
+			c := NewCoordinator(config, logs)
+			defer c.Cleanup()
+
+			saga = waitFinished(t, c, saga.ID)
+			vtx, ok := saga.getVtx("2")
+			assert.Assert(t, ok)
+			assert.Equal(t, vtx.Status, tt.expect)
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	config := DefaultConfig()
+	logs := NewBadgerDB(config.Path, config.InMemory)
+	defer logs.Close()
+
+	dag := map[string]map[string][]string{"1": {"2": nil}, "2": {}}
+	saga := NewSaga(map[string]Vertex{
+		"1": localVertex("1", "1", Status_NOT_REACHED),
+		"2": localVertex("2", "1", Status_NOT_REACHED),
+	}, dag)
+	saga.ID = "1"
+
+	// Graph is logged twice as if saga was recovered once before
+	mustAppendLog(t, logs, saga.ID, GraphLog, encodeSaga(saga))
+	mustAppendLog(t, logs, saga.ID, VertexLog, encodeVertex(localVertex("1", "1", Status_END_T)))
+	saga.Vertices.Set("1", localVertex("1", "1", Status_END_T))
+	mustAppendLog(t, logs, saga.ID, GraphLog, encodeSaga(saga))
+	mustAppendLog(t, logs, saga.ID, VertexLog, encodeVertex(localVertex("2", "1", Status_START_T)))
+
+	sagas, err := Recover(logs)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sagas), 1)
+
+	recovered := sagas[saga.ID]
+	vtx1, _ := recovered.getVtx("1")
+	vtx2, _ := recovered.getVtx("2")
+	assert.Equal(t, vtx1.Status, Status_END_T)
+	assert.Equal(t, vtx2.Status, Status_START_T)
+
+	t.Run("unknown saga", func(t *testing.T) {
+		mustAppendLog(t, logs, "2", VertexLog, encodeVertex(localVertex("1", "1", Status_START_T)))
+		_, err := Recover(logs)
+		assert.Equal(t, err, ErrUnknownLogSaga)
+	})
+}
+
+func TestCoordinatorMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.MetricsAddr = ":50060"
 
-	strs, err := utils.ParseGraphText("graphs.txt")
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	cServer := NewServer(config.CoordinatorAddr, c)
+	defer cServer.GracefulStop()
+
+	client := NewClient(config.CoordinatorAddr)
+
+	_, err := LocalSaga(client, map[string]map[string]struct{}{"11": {}})
 	assert.NilError(t, err)
-	fmt.Println(len(strs), strs)
+	_, err = LocalSaga(client, map[string]map[string]struct{}{"10": {}, "21": {}})
+	assert.NilError(t, err)
+
+	assert.Equal(t, testutil.ToFloat64(c.metrics.sagasStarted), float64(2))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.sagasCommitted), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.sagasAborted), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.sagasCompensated), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.sagasInFlight), float64(0))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.verticesInFlight), float64(0))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.vertexErrors.WithLabelValues("T", errClassAborted)), float64(1))
+
+	resp, err := http.Get("http://localhost:50060/metrics")
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	for _, name := range []string{
+		"sagas_started_total 2",
+		"sagas_vertex_call_duration_seconds_count{func=\"T\",url=\"\"} 3",
+		"sagas_vertex_call_duration_seconds_count{func=\"C\",url=\"\"} 1",
+		"sagas_queue_wait_seconds_count{queue=\"create\"} 2",
+		"sagas_append_log_duration_seconds_count",
+	} {
+		assert.Assert(t, strings.Contains(string(body), name), name)
+	}
+}
+
+func TestCoordinatorTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	config := DefaultConfig()
+	config.SpanExporter = exporter
+
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	cServer := NewServer(config.CoordinatorAddr, c)
+	defer cServer.GracefulStop()
+
+	client := NewClient(config.CoordinatorAddr)
+
+	// Participant records the traceparent it receives
+	traceparents := make(chan string, 2)
+	participant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		w.Write([]byte("{}"))
+	}))
+	defer participant.Close()
+
+	// Start saga as part of a trace from a gateway
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), parent)
+	_, err := client.StartSagaRPC(ctx, &SagaMsg{
+		Vertices: map[string]*Vertex{
+			"1": {Id: "1", T: &Func{Url: participant.URL, Method: "POST"}, C: &Func{Url: participant.URL, Method: "POST"}},
+		},
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, c.tracerProvider.ForceFlush(context.Background()))
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, span.SpanContext.TraceID(), parent.TraceID())
+		spans[span.Name] = span
+	}
+	saga, ok := spans["saga"]
+	assert.Assert(t, ok)
+	assert.Equal(t, saga.Parent.SpanID(), parent.SpanID())
+	processT, ok := spans["ProcessT"]
+	assert.Assert(t, ok)
+	assert.Equal(t, processT.Parent.SpanID(), saga.SpanContext.SpanID())
+
+	events := []string{}
+	for _, event := range processT.Events {
+		events = append(events, event.Name)
+	}
+	assert.DeepEqual(t, events, []string{"append log", "status", "append log"})
+
+	// Participant's request belongs to ProcessT's span
+	traceparent := <-traceparents
+	assert.Equal(t, traceparent, fmt.Sprintf("00-%v-%v-01", parent.TraceID(), processT.SpanContext.SpanID()))
+}
+
+func TestHTTPStatus(t *testing.T) {
+	// Participants reply with an error status instead of an error body
+	var mtx sync.Mutex
+	cCalls := 0
+	participant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/book":
+			w.Write([]byte(`{"reservationID": "7"}`))
+		case "/full":
+			http.Error(w, "no rooms left", http.StatusConflict)
+		case "/cancel":
+			mtx.Lock()
+			cCalls++
+			calls := cCalls
+			mtx.Unlock()
+			if calls == 1 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer participant.Close()
+
+	for _, method := range []string{"GET", "POST"} {
+		_, err := HTTPReq(context.Background(), participant.URL+"/full", method, "1", nil)
+		assert.Assert(t, errors.Is(err, ErrHTTPStatus), method)
+		resp, err := HTTPReq(context.Background(), participant.URL+"/book", method, "1", nil)
+		assert.NilError(t, err)
+		assert.Equal(t, resp["reservationID"], "7")
+	}
+
+	// A failed T aborts the saga and a failed C is retried
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	config.RetryInterval = time.Millisecond
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	resp, err := c.StartSagaRPC(context.Background(), &SagaMsg{
+		Vertices: map[string]*Vertex{
+			"1": {Id: "1", T: &Func{Url: participant.URL + "/book", Method: "POST"}, C: &Func{Url: participant.URL + "/cancel", Method: "POST"}},
+			"2": {Id: "2", T: &Func{Url: participant.URL + "/full", Method: "POST"}, C: &Func{Url: participant.URL + "/cancel", Method: "POST"}},
+		},
+		Edges: []*Edge{{StartId: "1", EndId: "2"}},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, resp.Vertices["1"].Status, Status_END_C)
+	assert.Equal(t, resp.Vertices["1"].CAttempts, uint32(2))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.vertexRetries.WithLabelValues(participant.URL+"/cancel", "C")), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.vertexErrors.WithLabelValues("T", errClassStatus)), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.vertexErrors.WithLabelValues("C", errClassStatus)), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.metrics.vertexErrors.WithLabelValues("T", errClassOther)), float64(0))
+	assert.Equal(t, resp.Vertices["2"].Status, Status_ABORT)
+	assert.Assert(t, strings.Contains(resp.Vertices["2"].T.Resp["error"], "409"))
+}
+
+func TestServerHealth(t *testing.T) {
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	addr := utils.AvailableAddr()
+	s := NewServer(addr, c)
+	defer s.GracefulStop()
+
+	cc, err := grpc.Dial(addr, grpc.WithInsecure())
+	assert.NilError(t, err)
+	defer cc.Close()
+	resp, err := healthpb.NewHealthClient(cc).Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	assert.NilError(t, err)
+	assert.Equal(t, resp.Status, healthpb.HealthCheckResponse_SERVING)
+}
+
+func TestCoordinatorLogging(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.Logger = NewLogger(&buf, LogFormatJSON, slog.LevelDebug)
+
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	saga := NewSaga(map[string]Vertex{
+		"1": localVertex("1", "1", Status_NOT_REACHED),
+		"2": localVertex("2", "0", Status_NOT_REACHED),
+	}, map[string]map[string][]string{"1": {"2": nil}, "2": {}})
+	saga.ID = "1"
+	replyCh := make(chan Saga, 1)
+	c.createCh <- createMsg{saga: saga, replyCh: replyCh, sent: time.Now()}
+	<-replyCh
+
+	// Every line about a vertex call says which call it is about
+	msgs := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		assert.NilError(t, json.Unmarshal([]byte(line), &entry))
+		msg := entry["msg"].(string)
+		msgs = append(msgs, msg)
+		if _, ok := entry[logKeyPhase]; ok {
+			for _, key := range []string{logKeySaga, logKeyVertex, logKeyAttempt, logKeyLsn} {
+				_, ok := entry[key]
+				assert.Assert(t, ok, "%v has no %v", msg, key)
+			}
+		}
+	}
+	assert.DeepEqual(t, msgs, []string{
+		"recovered sagas",
+		"saga started",
+		"issuing T", "T finished",
+		"issuing T", "T failed", "T finished",
+		"saga aborted",
+		"issuing C", "C finished",
+		"saga compensated",
+	})
+}
+
+func TestCoordinatorAdminRunning(t *testing.T) {
+	// Run with -race: the dashboard reads vertices while they are processed
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	c := NewCoordinator(config, NewBadgerDB(config.Path, config.InMemory))
+	defer c.Cleanup()
+
+	srv := httptest.NewServer(c.adminHandler())
+	defer srv.Close()
+
+	ids := []string{"1", "2", "3", "4", "5"}
+	vertices := make(map[string]Vertex, len(ids))
+	dag := make(map[string]map[string][]string, len(ids))
+	for i, id := range ids {
+		vtx := localVertex(id, "1", Status_NOT_REACHED)
+		vtx.T.Body["delay"] = "2ms"
+		vtx.TransferFields = []string{"success"}
+		vertices[id] = vtx
+		dag[id] = make(map[string][]string)
+		if i > 0 {
+			dag[ids[i-1]][id] = []string{"success"}
+		}
+	}
+	saga := NewSaga(vertices, dag)
+	saga.ID = "running"
+
+	replyCh := make(chan Saga, 1)
+	c.createCh <- createMsg{saga: saga, replyCh: replyCh, sent: time.Now()}
+
+	for polls := 0; ; polls++ {
+		select {
+		case saga = <-replyCh:
+			assert.Assert(t, polls > 0)
+			for _, id := range ids {
+				vtx, _ := saga.getVtx(id)
+				assert.Equal(t, vtx.Status, Status_END_T, "vertex %v", id)
+			}
+			return
+		default:
+		}
+		resp, err := http.Get(srv.URL + "/api/sagas/" + saga.ID)
+		assert.NilError(t, err)
+		_, err = io.Copy(io.Discard, resp.Body)
+		assert.NilError(t, err)
+		resp.Body.Close()
+	}
+}
+
+func TestCoordinatorAdmin(t *testing.T) {
+	config := DefaultConfig()
+	logs := NewBadgerDB(config.Path, config.InMemory)
+
+	c := NewCoordinator(config, logs)
+	defer c.Cleanup()
+
+	// Saga stopped after committing its first vertex
+	saga := NewSaga(map[string]Vertex{
+		"1": localVertex("1", "1", Status_END_T),
+		"2": localVertex("2", "1", Status_NOT_REACHED),
+	}, map[string]map[string][]string{"1": {"2": nil}, "2": {}})
+	saga.ID = "1"
+	mustAppendLog(t, logs, saga.ID, GraphLog, encodeSaga(saga))
+	c.mtx.Lock()
+	c.sagas[saga.ID] = saga
+	c.mtx.Unlock()
+
+	srv := httptest.NewServer(c.adminHandler())
+	defer srv.Close()
+
+	get := func(path string, out interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && out != nil {
+			assert.NilError(t, json.NewDecoder(resp.Body).Decode(out))
+		}
+		return resp.StatusCode
+	}
+	abort := func(sagaID string) int {
+		resp, err := http.Post(srv.URL+"/api/sagas/"+sagaID+"/abort", "", nil)
+		assert.NilError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	var list []adminSaga
+	assert.Equal(t, get("/api/sagas?state=running", &list), http.StatusOK)
+	assert.DeepEqual(t, list, []adminSaga{{ID: "1", State: stateRunning}})
+
+	assert.Equal(t, get("/", nil), http.StatusOK)
+	assert.Equal(t, get("/api/sagas/2", nil), http.StatusNotFound)
+	assert.Equal(t, abort("2"), http.StatusNotFound)
+
+	// Pages on other sites cannot abort sagas
+	for header, value := range map[string]string{"Origin": "http://evil.example", "Sec-Fetch-Site": "cross-site"} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/sagas/"+saga.ID+"/abort", nil)
+		assert.NilError(t, err)
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusForbidden, header)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/sagas/"+saga.ID+"/abort", nil)
+	assert.NilError(t, err)
+	req.Header.Set("Origin", srv.URL)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusNoContent)
+	waitFinished(t, c, saga.ID)
+	assert.Equal(t, abort(saga.ID), http.StatusConflict)
+
+	var detail adminSaga
+	assert.Equal(t, get("/api/sagas/1", &detail), http.StatusOK)
+	assert.Equal(t, detail.State, stateCompensated)
+	assert.DeepEqual(t, detail.Edges, []adminEdge{{From: "1", To: "2"}})
+	statuses := []string{}
+	for _, vtx := range detail.Vertices {
+		statuses = append(statuses, vtx.Status)
+	}
+	assert.DeepEqual(t, statuses, []string{"END_C", "NOT_REACHED"})
+	types := []string{}
+	for _, log := range detail.Logs {
+		types = append(types, log.Type+" "+log.Vertex+" "+log.Status)
+	}
+	assert.DeepEqual(t, types, []string{"graph  ", "abort  ", "vertex 1 START_C", "vertex 1 END_C"})
+	assert.Assert(t, !detail.Redacted)
+	assert.DeepEqual(t, detail.Vertices[0].T.Body, map[string]string{"success": "1"})
+
+	// Bodies encrypted at rest are not served
+	c.Config.EncryptionKey = make([]byte, 16)
+	detail = adminSaga{}
+	assert.Equal(t, get("/api/sagas/1", &detail), http.StatusOK)
+	assert.Assert(t, detail.Redacted)
+	assert.Assert(t, detail.Vertices[0].T.Body == nil && detail.Vertices[0].T.Method == "LOCAL")
+
+	// The abort survives recovery
+	sagas, err := Recover(logs)
+	assert.NilError(t, err)
+	_, aborted := CheckFinishedOrAbort(sagas[saga.ID])
+	assert.Assert(t, aborted)
+}
+
+func TestCoordinatorTemplates(t *testing.T) {
+	config := DefaultConfig()
+	logs := NewBadgerDB(config.Path, config.InMemory)
+
+	c := NewCoordinator(config, logs)
+	ctx := context.Background()
+
+	local := FuncDefinition{Method: "LOCAL", Body: map[string]string{"success": "${ok}"}}
+	v1 := &Definition{
+		Params:   []string{"ok"},
+		Vertices: map[string]VertexDefinition{"1": {T: local, C: local}},
+	}
+	v2 := &Definition{
+		Params:   []string{"ok"},
+		Vertices: map[string]VertexDefinition{"1": {T: local, C: local}, "2": {T: local, C: local}},
+		Edges:    []EdgeDefinition{{From: "1", To: "2"}},
+	}
+	for i, def := range []*Definition{v1, v2} {
+		data, err := def.Marshal(DefinitionJSON)
+		assert.NilError(t, err)
+		resp, err := c.RegisterTemplateRPC(ctx, &TemplateMsg{Name: "local", Definition: string(data)})
+		assert.NilError(t, err)
+		assert.Equal(t, resp.GetVersion(), uint64(i+1))
+	}
+
+	list, err := c.ListTemplatesRPC(ctx, &ListTemplatesMsg{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(list.GetTemplates()), 2)
+
+	tests := []struct {
+		name     string
+		version  uint64
+		params   map[string]string
+		pinned   uint64
+		vertices int
+		err      error
+	}{
+		{"latest", 0, map[string]string{"ok": "1"}, 2, 2, nil},
+		{"pinned", 1, map[string]string{"ok": "1"}, 1, 1, nil},
+		{"missing param", 0, nil, 0, 0, ErrMissingParam},
+		{"unknown version", 3, map[string]string{"ok": "1"}, 0, 0, ErrTemplateVersionNotFound},
+	}
+
+	var pinnedID string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := c.StartTemplateRPC(ctx, &StartTemplateMsg{Name: "local", Version: tt.version, Params: tt.params})
+			if tt.err != nil {
+				assert.Assert(t, errors.Is(err, tt.err), err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, resp.GetTemplate(), "local")
+			assert.Equal(t, resp.GetTemplateVersion(), tt.pinned)
+			assert.Equal(t, len(resp.GetVertices()), tt.vertices)
+			for _, vtx := range resp.GetVertices() {
+				assert.Equal(t, vtx.Status, Status_END_T)
+			}
+			if tt.version == 1 {
+				pinnedID = resp.GetId()
+			}
+		})
+	}
+
+	_, err = c.StartTemplateRPC(ctx, &StartTemplateMsg{Name: "hotel"})
+	assert.Equal(t, err, ErrTemplateNotFound)
+
+	// A restarted coordinator recovers templates and sagas keep their version
+	restarted := NewCoordinator(config, logs)
+	defer restarted.Cleanup()
+	assert.DeepEqual(t, restarted.ListTemplates(), c.ListTemplates())
+	saga := waitFinished(t, restarted, pinnedID)
+	assert.Equal(t, saga.TemplateVersion, uint64(1))
+
+	v3, err := restarted.RegisterTemplate("local", v1)
+	assert.NilError(t, err)
+	assert.Equal(t, v3.Version, uint64(3))
+}
+
+func TestSagaAt(t *testing.T) {
+	config := DefaultConfig()
+	logs := NewBadgerDB(config.Path, config.InMemory)
+
+	dag := map[string]map[string][]string{"1": {"2": nil}, "2": {}}
+	saga := NewSaga(map[string]Vertex{
+		"1": localVertex("1", "1", Status_NOT_REACHED),
+		"2": localVertex("2", "1", Status_NOT_REACHED),
+	}, dag)
+	saga.ID = "1"
+	other := NewSaga(map[string]Vertex{"1": localVertex("1", "1", Status_NOT_REACHED)}, map[string]map[string][]string{"1": {}})
+	other.ID = "2"
+
+	first := mustAppendLog(t, logs, saga.ID, GraphLog, encodeSaga(saga))
+	mustAppendLog(t, logs, saga.ID, VertexLog, encodeVertex(localVertex("1", "1", Status_START_T)))
+	// Logs of other sagas are skipped
+	mustAppendLog(t, logs, other.ID, GraphLog, encodeSaga(other))
+	mustAppendLog(t, logs, saga.ID, VertexLog, encodeVertex(localVertex("1", "1", Status_END_T)))
+	mustAppendLog(t, logs, saga.ID, VertexLog, encodeVertex(localVertex("2", "1", Status_START_T)))
+	mustAppendLog(t, logs, saga.ID, VertexLog, encodeVertex(localVertex("2", "1", Status_END_T)))
+
+	tests := []struct {
+		lsn       uint64
+		next      uint64
+		statuses  []Status
+		scheduled []string
+		finished  bool
+	}{
+		{first, first + 1, []Status{Status_NOT_REACHED, Status_NOT_REACHED}, []string{"1"}, false},
+		{first + 1, first + 3, []Status{Status_START_T, Status_NOT_REACHED}, []string{}, false},
+		{first + 3, first + 4, []Status{Status_END_T, Status_NOT_REACHED}, []string{"2"}, false},
+		{first + 4, first + 5, []Status{Status_END_T, Status_START_T}, []string{}, false},
+		{first + 5, 0, []Status{Status_END_T, Status_END_T}, []string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatUint(tt.lsn, 10), func(t *testing.T) {
+			snapshot, err := SagaAt(logs, saga.ID, tt.lsn)
+			assert.NilError(t, err)
+			assert.Equal(t, snapshot.Lsn, tt.lsn)
+			assert.Equal(t, snapshot.NextLsn, tt.next)
+			assert.Equal(t, snapshot.Finished, tt.finished)
+			for i, status := range tt.statuses {
+				vtx, ok := snapshot.Saga.getVtx(strconv.Itoa(i + 1))
+				assert.Assert(t, ok)
+				assert.Equal(t, vtx.Status, status)
+			}
+			scheduled := []string{}
+			for _, vtx := range snapshot.Scheduled {
+				scheduled = append(scheduled, vtx.Id)
+			}
+			assert.DeepEqual(t, scheduled, tt.scheduled)
+		})
+	}
+
+	t.Run("latest", func(t *testing.T) {
+		snapshot, err := SagaAt(logs, saga.ID, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, snapshot.Lsn, first+5)
+		assert.Assert(t, snapshot.Finished)
+	})
+
+	t.Run("unknown saga", func(t *testing.T) {
+		_, err := SagaAt(logs, "3", math.MaxUint64)
+		assert.Equal(t, err, ErrSagaNotLogged)
+	})
+
+	t.Run("corrupt log", func(t *testing.T) {
+		corrupt := NewBadgerDB(config.Path, config.InMemory)
+		defer corrupt.RemoveAll()
+		mustAppendLog(t, corrupt, saga.ID, GraphLog, []byte("not a saga"))
+		_, err := SagaAt(corrupt, saga.ID, math.MaxUint64)
+		assert.Assert(t, err != nil)
+	})
+
+	t.Run("rpc", func(t *testing.T) {
+		c := NewCoordinator(config, logs)
+		defer c.Cleanup()
+		waitFinished(t, c, other.ID)
+
+		resp, err := c.SagaAtRPC(context.Background(), &SagaAtMsg{SagaId: saga.ID})
+		assert.NilError(t, err)
+		snapshot := protoToSnapshot(resp)
+		assert.Equal(t, snapshot.Saga.ID, saga.ID)
+		assert.Assert(t, snapshot.Finished)
+		assert.Equal(t, len(snapshot.Scheduled), 0)
+
+		// The RPC gives the same answer as SagaAt at the boundaries of the log
+		for _, lsn := range []uint64{0, first, first + 1, first + 5} {
+			resp, err := c.SagaAtRPC(context.Background(), &SagaAtMsg{SagaId: saga.ID, Lsn: lsn})
+			assert.NilError(t, err)
+			expected, err := SagaAt(logs, saga.ID, lsn)
+			assert.NilError(t, err)
+			assert.Equal(t, resp.GetLsn(), expected.Lsn, "lsn %v", lsn)
+			assert.Equal(t, resp.GetNextLsn(), expected.NextLsn, "lsn %v", lsn)
+		}
+
+		_, err = c.SagaAtRPC(context.Background(), &SagaAtMsg{SagaId: "3"})
+		assert.Equal(t, status.Code(err), codes.NotFound)
+	})
+}
+
+func localVertex(id, success string, status Status) Vertex {
+	return Vertex{
+		Id: id,
+		T: &Func{
+			Method: "LOCAL",
+			Body:   map[string]string{"success": success},
+			Resp:   map[string]string{},
+		},
+		C: &Func{
+			Method: "LOCAL",
+			Body:   map[string]string{"success": "1"},
+			Resp:   map[string]string{},
+		},
+		Status: status,
+	}
+}
+
+// waitFinished polls coordinator until saga has finished
+func waitFinished(t *testing.T, c *Coordinator, sagaID string) Saga {
+	for i := 0; i < 500; i++ {
+		c.mtx.Lock()
+		saga, ok := c.sagas[sagaID]
+		c.mtx.Unlock()
+		if ok {
+			if finished, _ := CheckFinishedOrAbort(saga); finished {
+				return saga
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(ErrSagaUnfinished)
+	return Saga{}
 }
 
 // Need envoy to be running for this test to work
@@ -227,7 +1101,9 @@ func TestCoordinatorFailure(t *testing.T) {
 
 			time.Sleep(2 * time.Second)
 
-			lastLog, err := c.logs.GetLog(c.logs.LastIndex())
+			lastIndex, err := c.logs.LastIndex()
+			assert.NilError(t, err)
+			lastLog, err := c.logs.GetLog(lastIndex)
 			assert.NilError(t, err)
 
 			fmt.Printf("%#v\n", lastLog)
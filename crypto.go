@@ -0,0 +1,109 @@
+package sagas
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Errors involving encrypted logs
+var (
+	ErrNoEncryptionKey      = errors.New("log is encrypted but log store has no encryption key")
+	ErrUnknownEncryptionKey = errors.New("log is encrypted with a key that is not in the keyring")
+	ErrInvalidCiphertext    = errors.New("encrypted log is too short")
+)
+
+// encryptedMagic prefixes every encrypted value. It is never a valid first byte of
+// msgpack, so logs written before encryption was enabled are still recognized
+const encryptedMagic = 0xc1
+
+// Keyring holds the AES keys used to encrypt logs at rest with AES-GCM. New logs are
+// encrypted with the active key. Older keys are kept so that logs written before a
+// key rotation stay readable
+type Keyring struct {
+	active uint32
+	aeads  map[uint32]cipher.AEAD
+}
+
+// NewKeyring creates a keyring that encrypts with active and can still decrypt with
+// any of old. Keys must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+func NewKeyring(active []byte, old ...[]byte) (*Keyring, error) {
+	k := &Keyring{
+		aeads: make(map[uint32]cipher.AEAD, len(old)+1),
+	}
+	for _, key := range append([][]byte{active}, old...) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[keyID(key)] = aead
+	}
+	k.active = keyID(active)
+	return k, nil
+}
+
+// keyID identifies a key in ciphertexts without revealing it
+func keyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// seal encrypts plaintext with the active key. ad is authenticated but not encrypted
+// so that a value cannot be moved to another key. A nil keyring returns plaintext
+func (k *Keyring) seal(plaintext, ad []byte) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	aead := k.aeads[k.active]
+
+	// Layout is magic | key id | nonce | ciphertext
+	header := make([]byte, 5+aead.NonceSize())
+	header[0] = encryptedMagic
+	binary.BigEndian.PutUint32(header[1:5], k.active)
+	if _, err := io.ReadFull(rand.Reader, header[5:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[5:], plaintext, ad), nil
+}
+
+// open decrypts a value written by seal. Values that were never encrypted are returned as is
+func (k *Keyring) open(data, ad []byte) ([]byte, error) {
+	if !encrypted(data) {
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	if len(data) < 5 {
+		return nil, ErrInvalidCiphertext
+	}
+	aead, ok := k.aeads[binary.BigEndian.Uint32(data[1:5])]
+	if !ok {
+		return nil, ErrUnknownEncryptionKey
+	}
+	if len(data) < 5+aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce := data[5 : 5+aead.NonceSize()]
+	return aead.Open(nil, nonce, data[5+aead.NonceSize():], ad)
+}
+
+// rotated returns true if data must be sealed again to be encrypted with the active key
+func (k *Keyring) rotated(data []byte) bool {
+	if k == nil {
+		return false
+	}
+	return !encrypted(data) || len(data) < 5 || binary.BigEndian.Uint32(data[1:5]) != k.active
+}
+
+func encrypted(data []byte) bool {
+	return len(data) > 0 && data[0] == encryptedMagic
+}
@@ -0,0 +1,417 @@
+package sagas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Formats of saga definition files
+const (
+	DefinitionYAML = "yaml"
+	DefinitionJSON = "json"
+)
+
+// Errors in saga definitions
+var (
+	ErrUnknownDefinitionFormat = errors.New("definition format must be yaml or json")
+	ErrInvalidDefinition       = errors.New("invalid saga definition")
+	ErrMissingParam            = errors.New("missing value for definition param")
+	ErrUnknownParam            = errors.New("definition has no such param")
+)
+
+// placeholder matches ${param} in urls and bodies of a definition
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Definition describes a saga declaratively. Urls and body values may contain
+// ${param} placeholders that are filled in when the saga is started
+type Definition struct {
+	Name     string                      `json:"name,omitempty" yaml:"name,omitempty"`
+	Params   []string                    `json:"params,omitempty" yaml:"params,omitempty"`
+	Vertices map[string]VertexDefinition `json:"vertices" yaml:"vertices"`
+	Edges    []EdgeDefinition            `json:"edges,omitempty" yaml:"edges,omitempty"`
+	// Name of a RecoveryMode. Defaults to BACKWARD
+	Recovery string `json:"recovery,omitempty" yaml:"recovery,omitempty"`
+	// Name of a CompensationOrder. Defaults to REVERSE_TOPOLOGICAL
+	Compensation string `json:"compensation,omitempty" yaml:"compensation,omitempty"`
+}
+
+// VertexDefinition describes a vertex of a saga definition
+type VertexDefinition struct {
+	T FuncDefinition `json:"t" yaml:"t"`
+	C FuncDefinition `json:"c" yaml:"c"`
+	// Transfer fields from T's resp to C's body
+	TransferFields []string `json:"transferFields,omitempty" yaml:"transferFields,omitempty"`
+	// Name of an UncertainPolicy. Defaults to REISSUE_T
+	UncertainPolicy string `json:"uncertainPolicy,omitempty" yaml:"uncertainPolicy,omitempty"`
+	// Child saga run in place of T and C. It uses the params of the saga it is nested in
+	Saga *Definition `json:"saga,omitempty" yaml:"saga,omitempty"`
+	// Name of a VertexKind. Defaults to COMPENSATABLE
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// A read-only vertex may omit C or give it method NOOP
+	ReadOnly bool `json:"readOnly,omitempty" yaml:"readOnly,omitempty"`
+}
+
+// FuncDefinition describes a request to a participant
+type FuncDefinition struct {
+	URL    string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Method string            `json:"method" yaml:"method"`
+	Body   map[string]string `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// EdgeDefinition describes an edge of a saga definition
+type EdgeDefinition struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+	// Transfer fields from From's T resp to To's T body
+	TransferFields []string `json:"transferFields,omitempty" yaml:"transferFields,omitempty"`
+	// Condition on From's T resp for the edge to be taken
+	Predicate string `json:"predicate,omitempty" yaml:"predicate,omitempty"`
+}
+
+// ReadDefinition reads a definition file. Its format is chosen by extension
+func ReadDefinition(path string) (*Definition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return ParseDefinition(data, DefinitionYAML)
+	case ".json":
+		return ParseDefinition(data, DefinitionJSON)
+	default:
+		return nil, ErrUnknownDefinitionFormat
+	}
+}
+
+// ParseDefinition parses and validates a definition in format
+func ParseDefinition(data []byte, format string) (*Definition, error) {
+	def := new(Definition)
+	var err error
+	switch format {
+	case DefinitionYAML:
+		err = yaml.Unmarshal(data, def)
+	case DefinitionJSON:
+		err = json.Unmarshal(data, def)
+	default:
+		return nil, ErrUnknownDefinitionFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := def.Validate(); err != nil {
+		return nil, err
+	}
+	return def, nil
+}
+
+// Marshal encodes a definition in format
+func (d *Definition) Marshal(format string) ([]byte, error) {
+	switch format {
+	case DefinitionYAML:
+		return yaml.Marshal(d)
+	case DefinitionJSON:
+		return json.MarshalIndent(d, "", "  ")
+	default:
+		return nil, ErrUnknownDefinitionFormat
+	}
+}
+
+// Validate checks that a definition describes a valid saga: every func has a
+// method, edges join declared vertices without forming a cycle and every
+// placeholder names a declared param
+func (d *Definition) Validate() error {
+	params := make(map[string]struct{}, len(d.Params))
+	for _, p := range d.Params {
+		if !placeholder.MatchString("${" + p + "}") {
+			return fmt.Errorf("%w: param %q is not a valid name", ErrInvalidDefinition, p)
+		}
+		params[p] = struct{}{}
+	}
+	return d.validate(params)
+}
+
+func (d *Definition) validate(params map[string]struct{}) error {
+	if len(d.Vertices) == 0 {
+		return fmt.Errorf("%w: no vertices", ErrInvalidDefinition)
+	}
+	if _, ok := RecoveryMode_value[d.Recovery]; d.Recovery != "" && !ok {
+		return fmt.Errorf("%w: unknown recovery mode %q", ErrInvalidDefinition, d.Recovery)
+	}
+	if _, ok := CompensationOrder_value[d.Compensation]; d.Compensation != "" && !ok {
+		return fmt.Errorf("%w: unknown compensation order %q", ErrInvalidDefinition, d.Compensation)
+	}
+
+	checkFunc := func(id, name string, f FuncDefinition) error {
+		if f.Method == "" {
+			return fmt.Errorf("%w: vertex %v %v has no method", ErrInvalidDefinition, id, name)
+		}
+		if f.URL == "" && f.Method != "LOCAL" {
+			return fmt.Errorf("%w: vertex %v %v has no url", ErrInvalidDefinition, id, name)
+		}
+		for _, s := range append(bodyValues(f.Body), f.URL) {
+			for _, match := range placeholder.FindAllStringSubmatch(s, -1) {
+				if _, ok := params[match[1]]; !ok {
+					return fmt.Errorf("%w: vertex %v %v uses undeclared param %q", ErrInvalidDefinition, id, name, match[1])
+				}
+			}
+		}
+		return nil
+	}
+
+	for id, vtx := range d.Vertices {
+		if vtx.Saga != nil {
+			if !vtx.T.empty() || !vtx.C.empty() {
+				return fmt.Errorf("%w: vertex %v has both a saga and funcs", ErrInvalidDefinition, id)
+			}
+			if len(vtx.Saga.Params) > 0 {
+				return fmt.Errorf("%w: saga of vertex %v declares params", ErrInvalidDefinition, id)
+			}
+			if err := vtx.Saga.validate(params); err != nil {
+				return err
+			}
+		} else {
+			if err := checkFunc(id, "T", vtx.T); err != nil {
+				return err
+			}
+			if !vtx.C.noop() {
+				if err := checkFunc(id, "C", vtx.C); err != nil {
+					return err
+				}
+			}
+		}
+		if _, ok := UncertainPolicy_value[vtx.UncertainPolicy]; vtx.UncertainPolicy != "" && !ok {
+			return fmt.Errorf("%w: vertex %v has unknown uncertain policy %q", ErrInvalidDefinition, id, vtx.UncertainPolicy)
+		}
+		if _, ok := VertexKind_value[vtx.Kind]; vtx.Kind != "" && !ok {
+			return fmt.Errorf("%w: vertex %v has unknown kind %q", ErrInvalidDefinition, id, vtx.Kind)
+		}
+		if vtx.Saga == nil {
+			if err := checkCompensation(id, VertexKind(VertexKind_value[vtx.Kind]), vtx.ReadOnly, vtx.C.noop()); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+			}
+		}
+	}
+
+	dag := make(map[string]map[string][]string, len(d.Vertices))
+	for id := range d.Vertices {
+		dag[id] = make(map[string][]string)
+	}
+	for _, edge := range d.Edges {
+		if _, ok := dag[edge.From]; !ok {
+			return fmt.Errorf("%w: edge from unknown vertex %v", ErrInvalidDefinition, edge.From)
+		}
+		if _, ok := dag[edge.To]; !ok {
+			return fmt.Errorf("%w: edge to unknown vertex %v", ErrInvalidDefinition, edge.To)
+		}
+		if _, ok := dag[edge.From][edge.To]; ok {
+			return fmt.Errorf("%w: duplicate edge %v -> %v", ErrInvalidDefinition, edge.From, edge.To)
+		}
+		if _, err := compilePredicate(edge.Predicate); err != nil {
+			return fmt.Errorf("%w: edge %v -> %v: %v", ErrInvalidDefinition, edge.From, edge.To, err)
+		}
+		dag[edge.From][edge.To] = edge.TransferFields
+	}
+	if hasCycle(dag) {
+		return fmt.Errorf("%w: edges form a cycle", ErrInvalidDefinition)
+	}
+	// Child sagas were checked above, so only whether they have a pivot matters here
+	kinds := make(map[string]VertexKind, len(d.Vertices))
+	for id, vtx := range d.Vertices {
+		kinds[id] = VertexKind(VertexKind_value[vtx.Kind])
+		policy := UncertainPolicy(UncertainPolicy_value[vtx.UncertainPolicy])
+		if err := checkVertexKind(id, kinds[id], policy, vtx.Saga != nil, vtx.Saga.hasPivot()); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+		}
+	}
+	if err := checkPivot(kinds, dag); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDefinition, err)
+	}
+	return nil
+}
+
+func (d *Definition) hasPivot() bool {
+	if d == nil {
+		return false
+	}
+	for _, vtx := range d.Vertices {
+		if vtx.Kind == VertexKind_PIVOT.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// SagaMsg fills in the definition's params and converts it into a saga message
+func (d *Definition) SagaMsg(params map[string]string) (*SagaMsg, error) {
+	declared := make(map[string]struct{}, len(d.Params))
+	for _, p := range d.Params {
+		if _, ok := params[p]; !ok {
+			return nil, fmt.Errorf("%w: %v", ErrMissingParam, p)
+		}
+		declared[p] = struct{}{}
+	}
+	for p := range params {
+		if _, ok := declared[p]; !ok {
+			return nil, fmt.Errorf("%w: %v", ErrUnknownParam, p)
+		}
+	}
+	expand := func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(match string) string {
+			return params[placeholder.FindStringSubmatch(match)[1]]
+		})
+	}
+	return d.sagaMsg(expand), nil
+}
+
+func (d *Definition) sagaMsg(expand func(string) string) *SagaMsg {
+	toFunc := func(f FuncDefinition) *Func {
+		body := make(map[string]string, len(f.Body))
+		for k, v := range f.Body {
+			body[k] = expand(v)
+		}
+		return &Func{
+			Url:    expand(f.URL),
+			Method: f.Method,
+			Body:   body,
+			Resp:   make(map[string]string),
+		}
+	}
+
+	msg := &SagaMsg{
+		Vertices:          make(map[string]*Vertex, len(d.Vertices)),
+		RecoveryMode:      RecoveryMode(RecoveryMode_value[d.Recovery]),
+		CompensationOrder: CompensationOrder(CompensationOrder_value[d.Compensation]),
+	}
+	for id, vtx := range d.Vertices {
+		v := &Vertex{
+			Id:              id,
+			T:               toFunc(vtx.T),
+			C:               toFunc(vtx.C),
+			TransferFields:  vtx.TransferFields,
+			UncertainPolicy: UncertainPolicy(UncertainPolicy_value[vtx.UncertainPolicy]),
+			Kind:            VertexKind(VertexKind_value[vtx.Kind]),
+			ReadOnly:        vtx.ReadOnly,
+		}
+		if vtx.Saga != nil {
+			v.Saga = vtx.Saga.sagaMsg(expand)
+		}
+		msg.Vertices[id] = v
+	}
+	for _, edge := range d.Edges {
+		msg.Edges = append(msg.Edges, &Edge{
+			StartId:        edge.From,
+			EndId:          edge.To,
+			TransferFields: edge.TransferFields,
+			Predicate:      edge.Predicate,
+		})
+	}
+	return msg
+}
+
+// NewDefinition describes a saga message as a definition without params
+func NewDefinition(msg *SagaMsg) *Definition {
+	toDef := func(f *Func) FuncDefinition {
+		def := FuncDefinition{URL: f.GetUrl(), Method: f.GetMethod()}
+		if len(f.GetBody()) > 0 {
+			def.Body = f.GetBody()
+		}
+		return def
+	}
+
+	d := &Definition{Vertices: make(map[string]VertexDefinition, len(msg.GetVertices()))}
+	if msg.GetRecoveryMode() != RecoveryMode_BACKWARD {
+		d.Recovery = msg.GetRecoveryMode().String()
+	}
+	if msg.GetCompensationOrder() != CompensationOrder_REVERSE_TOPOLOGICAL {
+		d.Compensation = msg.GetCompensationOrder().String()
+	}
+	for id, vtx := range msg.GetVertices() {
+		def := VertexDefinition{
+			T:              toDef(vtx.GetT()),
+			C:              toDef(vtx.GetC()),
+			TransferFields: vtx.GetTransferFields(),
+			ReadOnly:       vtx.GetReadOnly(),
+		}
+		if vtx.GetUncertainPolicy() != UncertainPolicy_REISSUE_T {
+			def.UncertainPolicy = vtx.GetUncertainPolicy().String()
+		}
+		if vtx.GetKind() != VertexKind_COMPENSATABLE {
+			def.Kind = vtx.GetKind().String()
+		}
+		if vtx.GetSaga() != nil {
+			def.T, def.C = FuncDefinition{}, FuncDefinition{}
+			def.Saga = NewDefinition(vtx.GetSaga())
+		}
+		d.Vertices[id] = def
+	}
+	for _, edge := range msg.GetEdges() {
+		d.Edges = append(d.Edges, EdgeDefinition{
+			From:           edge.GetStartId(),
+			To:             edge.GetEndId(),
+			TransferFields: edge.GetTransferFields(),
+			Predicate:      edge.GetPredicate(),
+		})
+	}
+	sort.Slice(d.Edges, func(i, j int) bool {
+		if d.Edges[i].From != d.Edges[j].From {
+			return d.Edges[i].From < d.Edges[j].From
+		}
+		return d.Edges[i].To < d.Edges[j].To
+	})
+	return d
+}
+
+func (f FuncDefinition) empty() bool {
+	return f.URL == "" && f.Method == "" && len(f.Body) == 0
+}
+
+// noop returns whether a C is omitted or marked NOOP
+func (f FuncDefinition) noop() bool {
+	return f.empty() || strings.EqualFold(f.Method, methodNoop)
+}
+
+func bodyValues(body map[string]string) []string {
+	values := make([]string, 0, len(body))
+	for _, v := range body {
+		values = append(values, v)
+	}
+	return values
+}
+
+// hasCycle reports whether dag has a cycle by repeatedly removing vertices without parents
+func hasCycle(dag map[string]map[string][]string) bool {
+	parents := make(map[string]int, len(dag))
+	for _, children := range dag {
+		for child := range children {
+			parents[child]++
+		}
+	}
+	var queue []string
+	for id := range dag {
+		if parents[id] == 0 {
+			queue = append(queue, id)
+		}
+	}
+	removed := 0
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		removed++
+		for child := range dag[id] {
+			parents[child]--
+			if parents[child] == 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+	return removed != len(dag)
+}
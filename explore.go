@@ -0,0 +1,376 @@
+package sagas
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Invariants checked by the state-space explorer
+var (
+	ErrExploreDeadlock      = errors.New("unfinished saga has no vertex in flight")
+	ErrExploreRescheduled   = errors.New("vertex scheduled while already in flight")
+	ErrExploreUnapplied     = errors.New("committed saga has a T that did not apply")
+	ErrExploreUncompensated = errors.New("compensated saga has a T that was not compensated")
+	ErrExploreCompensation  = errors.New("vertex compensated before a descendant whose T applied")
+)
+
+// ErrExploreUnsupported is returned for a saga that uses a feature the explorer does not model
+var ErrExploreUnsupported = errors.New("explorer does not model predicates, vertex kinds, child sagas or forward recovery")
+
+// ExploreOptions bounds the executions explored
+type ExploreOptions struct {
+	// MaxCrashes is the number of coordinator crashes in a single execution
+	MaxCrashes int
+	// FailC lets a C fail, which leaves its vertex in START_C
+	FailC bool
+}
+
+// Violation is a reachable state that breaks an invariant
+type Violation struct {
+	Err error
+	// Trace lists the events that lead to the state from the saga's start
+	Trace []string
+	// State shows the status of each vertex in memory and in the log
+	State string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("%v in %v after %v", v.Err, v.State, strings.Join(v.Trace, ", "))
+}
+
+// ExploreResult sums up an exploration
+type ExploreResult struct {
+	// States is the number of distinct states reached
+	States int
+	// Violations holds the shortest trace found for each broken invariant
+	Violations []Violation
+}
+
+// Phases of a vertex's T or C in flight
+type explorePhase uint8
+
+const (
+	phaseIdle explorePhase = iota
+	// Status is set in memory
+	phaseScheduled
+	// START_T or START_C is logged
+	phaseLogged
+	// Participant has replied
+	phaseReplied
+)
+
+// Outcomes of a vertex at its participant
+type exploreOutcome uint8
+
+const (
+	outcomeNone exploreOutcome = iota
+	outcomeApplied
+	outcomeFailed
+	outcomeCompensated
+)
+
+// exploreState is a state of a saga's coordinator and participants. Slices are
+// indexed by the position of a vertex id in exploreModel.ids
+type exploreState struct {
+	status []Status
+	logged []Status
+	phase  []explorePhase
+	// Status a replied vertex finishes with
+	result  []Status
+	outcome []exploreOutcome
+	crashes int
+}
+
+func (s exploreState) key() string {
+	key := make([]byte, 0, 5*len(s.status)+1)
+	for i := range s.status {
+		key = append(key, byte(s.status[i]), byte(s.logged[i]), byte(s.phase[i]), byte(s.result[i]), byte(s.outcome[i]))
+	}
+	return string(append(key, byte(s.crashes)))
+}
+
+func (s exploreState) clone() exploreState {
+	return exploreState{
+		status:  append([]Status(nil), s.status...),
+		logged:  append([]Status(nil), s.logged...),
+		phase:   append([]explorePhase(nil), s.phase...),
+		result:  append([]Status(nil), s.result...),
+		outcome: append([]exploreOutcome(nil), s.outcome...),
+		crashes: s.crashes,
+	}
+}
+
+type exploreModel struct {
+	// sg is reused for every state, since building a saga dominates exploration
+	sg       Saga
+	ids      []string
+	index    map[string]int
+	dag      map[string]map[string][]string
+	policies []UncertainPolicy
+	// C of each vertex, which decides whether it has anything to compensate
+	cs   []*Func
+	opts ExploreOptions
+}
+
+// exploreStep is a successor of a state. A step that breaks an invariant has err set
+type exploreStep struct {
+	event string
+	state exploreState
+	err   error
+}
+
+// Explore runs every interleaving of vertex replies, T and C outcomes and coordinator
+// crashes of a saga through the coordinator's scheduling functions. It reports
+// reachable states that are invalid, deadlock or leave participants inconsistent.
+// The state space grows quickly, so it is meant for sagas of a handful of vertices.
+// Sagas whose scheduling depends on T replies or retries are rejected rather than
+// explored with the wrong rules
+func Explore(saga Saga, opts ExploreOptions) (ExploreResult, error) {
+	if saga.Recovery != RecoveryMode_BACKWARD {
+		return ExploreResult{}, ErrExploreUnsupported
+	}
+	for _, children := range saga.Predicates {
+		if len(children) > 0 {
+			return ExploreResult{}, ErrExploreUnsupported
+		}
+	}
+	m := &exploreModel{
+		index: make(map[string]int),
+		dag:   saga.DAG,
+		opts:  opts,
+	}
+	for id := range saga.DAG {
+		m.ids = append(m.ids, id)
+	}
+	sort.Strings(m.ids)
+	for i, id := range m.ids {
+		m.index[id] = i
+		vtx, ok := saga.getVtx(id)
+		if !ok {
+			panic(ErrIDNotFound)
+		}
+		if vtx.Kind != VertexKind_COMPENSATABLE || vtx.Saga != nil {
+			return ExploreResult{}, ErrExploreUnsupported
+		}
+		m.policies = append(m.policies, vtx.UncertainPolicy)
+		m.cs = append(m.cs, vtx.C)
+	}
+	m.sg = NewSaga(nil, m.dag)
+	m.sg.Compensation = saga.Compensation
+
+	n := len(m.ids)
+	start := exploreState{
+		status:  make([]Status, n),
+		logged:  make([]Status, n),
+		phase:   make([]explorePhase, n),
+		result:  make([]Status, n),
+		outcome: make([]exploreOutcome, n),
+	}
+	start, err := m.schedule(start, createSchedule(m.saga(start.status)))
+
+	type visit struct {
+		parent string
+		event  string
+	}
+	visited := map[string]visit{start.key(): {}}
+	trace := func(key string) []string {
+		var events []string
+		for v := visited[key]; v.event != ""; v = visited[v.parent] {
+			events = append([]string{v.event}, events...)
+		}
+		return events
+	}
+
+	res := ExploreResult{}
+	found := make(map[error]bool)
+	report := func(key string, state exploreState, err error) {
+		if found[err] {
+			return
+		}
+		found[err] = true
+		res.Violations = append(res.Violations, Violation{Err: err, Trace: trace(key), State: m.describe(state)})
+	}
+	if err != nil {
+		report(start.key(), start, err)
+		return res, nil
+	}
+
+	// Breadth first so each violation is reported with a shortest trace
+	queue := []exploreState{start}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		key := state.key()
+
+		steps, err := m.steps(state)
+		if err != nil {
+			report(key, state, err)
+		}
+		for _, step := range steps {
+			next := step.state.key()
+			if _, ok := visited[next]; ok {
+				continue
+			}
+			visited[next] = visit{parent: key, event: step.event}
+			if step.err != nil {
+				report(next, step.state, step.err)
+				continue
+			}
+			queue = append(queue, step.state)
+		}
+	}
+	res.States = len(visited)
+	return res, nil
+}
+
+// saga sets the vertices of the model's saga to status
+func (m *exploreModel) saga(status []Status) Saga {
+	for i, id := range m.ids {
+		m.sg.Vertices.Set(id, Vertex{Id: id, Status: status[i], UncertainPolicy: m.policies[i], C: m.cs[i]})
+	}
+	return m.sg
+}
+
+// steps returns the successors of a state, or the invariant a final state breaks
+func (m *exploreModel) steps(s exploreState) ([]exploreStep, error) {
+	if finished, aborted := CheckFinishedOrAbort(m.saga(s.status)); finished {
+		return nil, m.checkFinished(s, aborted)
+	}
+
+	var steps []exploreStep
+	for i, id := range m.ids {
+		switch s.phase[i] {
+		case phaseScheduled:
+			next := s.clone()
+			next.logged[i] = next.status[i]
+			next.phase[i] = phaseLogged
+			steps = append(steps, exploreStep{event: "log " + id + " " + next.status[i].String(), state: next})
+		case phaseLogged:
+			for _, r := range m.replies(s, i) {
+				next := s.clone()
+				next.outcome[i], next.result[i] = r.outcome, r.result
+				next.phase[i] = phaseReplied
+				steps = append(steps, exploreStep{event: "reply " + id + " " + r.result.String(), state: next})
+			}
+		case phaseReplied:
+			next := s.clone()
+			next.status[i], next.logged[i] = s.result[i], s.result[i]
+			next.phase[i] = phaseIdle
+			next, err := m.update(next, id)
+			steps = append(steps, exploreStep{event: "update " + id + " " + s.result[i].String(), state: next, err: err})
+		}
+	}
+	if len(steps) == 0 {
+		return nil, ErrExploreDeadlock
+	}
+
+	if s.crashes < m.opts.MaxCrashes {
+		next := s.clone()
+		next.crashes++
+		copy(next.status, next.logged)
+		for i := range next.phase {
+			next.phase[i] = phaseIdle
+		}
+		next, err := m.recover(next)
+		steps = append(steps, exploreStep{event: "crash", state: next, err: err})
+	}
+	return steps, nil
+}
+
+type exploreReply struct {
+	outcome exploreOutcome
+	result  Status
+}
+
+// replies are the possible replies of a vertex's participant. Participants dedupe
+// re-issued requests, so a T only has a choice of outcome the first time
+func (m *exploreModel) replies(s exploreState, i int) []exploreReply {
+	if s.status[i] == Status_START_T {
+		switch s.outcome[i] {
+		case outcomeNone:
+			return []exploreReply{{outcomeApplied, Status_END_T}, {outcomeFailed, Status_ABORT}}
+		case outcomeApplied:
+			return []exploreReply{{outcomeApplied, Status_END_T}}
+		default:
+			return []exploreReply{{s.outcome[i], Status_ABORT}}
+		}
+	}
+	// C undoes an applied T and keeps a T that has not arrived from applying later
+	replies := []exploreReply{{outcomeCompensated, Status_END_C}}
+	if s.outcome[i] == outcomeFailed {
+		replies[0].outcome = outcomeFailed
+	}
+	if m.opts.FailC {
+		replies = append(replies, exploreReply{s.outcome[i], Status_START_C})
+	}
+	return replies
+}
+
+// update mirrors Coordinator.update once a vertex's final status is logged
+func (m *exploreModel) update(s exploreState, updated string) (exploreState, error) {
+	saga := m.saga(s.status)
+	if err := CheckValidSaga(saga); err != nil {
+		return s, err
+	}
+	if finished, _ := CheckFinishedOrAbort(saga); finished {
+		return s, nil
+	}
+	return m.schedule(s, updateSchedule(saga, updated))
+}
+
+// recover mirrors Coordinator.create for a saga recovered from the log
+func (m *exploreModel) recover(s exploreState) (exploreState, error) {
+	saga := m.saga(s.status)
+	if err := CheckValidSaga(saga); err != nil {
+		return s, err
+	}
+	if finished, _ := CheckFinishedOrAbort(saga); finished {
+		return s, nil
+	}
+	return m.schedule(s, createSchedule(saga))
+}
+
+// schedule mirrors Coordinator.run
+func (m *exploreModel) schedule(s exploreState, process []Vertex) (exploreState, error) {
+	_, aborted := CheckFinishedOrAbort(m.saga(s.status))
+	for _, vtx := range process {
+		i := m.index[vtx.Id]
+		if s.phase[i] != phaseIdle {
+			return s, ErrExploreRescheduled
+		}
+		s.status[i] = processStatus(vtx, aborted)
+		s.phase[i] = phaseScheduled
+		if s.status[i] == Status_START_C && m.sg.Compensation == CompensationOrder_REVERSE_TOPOLOGICAL {
+			for id := range reachable(vtx.Id, m.dag) {
+				if j := m.index[id]; s.outcome[j] == outcomeApplied && !m.cs[j].noop() {
+					return s, ErrExploreCompensation
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+// checkFinished checks that participants agree with how a saga finished
+func (m *exploreModel) checkFinished(s exploreState, aborted bool) error {
+	for i := range m.ids {
+		if !aborted && s.outcome[i] != outcomeApplied {
+			return ErrExploreUnapplied
+		}
+		// A T without compensation changed nothing, so it may stay applied
+		if aborted && s.outcome[i] == outcomeApplied && !m.cs[i].noop() {
+			return ErrExploreUncompensated
+		}
+	}
+	return nil
+}
+
+func (m *exploreModel) describe(s exploreState) string {
+	var vertices []string
+	for i, id := range m.ids {
+		vertices = append(vertices, fmt.Sprintf("%v:%v/%v", id, s.status[i], s.logged[i]))
+	}
+	return "[" + strings.Join(vertices, " ") + "]"
+}
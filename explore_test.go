@@ -0,0 +1,141 @@
+package sagas
+
+import (
+	"strconv"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// exploreSaga builds a saga of n vertices with edges and every vertex using policy
+func exploreSaga(n int, edges [][2]int, policy UncertainPolicy) Saga {
+	vertices := make(map[string]Vertex, n)
+	dag := make(map[string]map[string][]string, n)
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		vertices[id] = Vertex{Id: id, UncertainPolicy: policy, C: &Func{Url: "c", Method: "POST"}}
+		dag[id] = make(map[string][]string)
+	}
+	for _, e := range edges {
+		dag[strconv.Itoa(e[0])][strconv.Itoa(e[1])] = nil
+	}
+	return NewSaga(vertices, dag)
+}
+
+// allDAGs returns the edges of every DAG of n vertices whose edges go from lower to higher vertices
+func allDAGs(n int) [][][2]int {
+	var pairs [][2]int
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			pairs = append(pairs, [2]int{i, j})
+		}
+	}
+	var dags [][][2]int
+	for mask := 0; mask < 1<<len(pairs); mask++ {
+		var edges [][2]int
+		for k, pair := range pairs {
+			if mask&(1<<k) != 0 {
+				edges = append(edges, pair)
+			}
+		}
+		dags = append(dags, edges)
+	}
+	return dags
+}
+
+func TestExplore(t *testing.T) {
+	policies := []UncertainPolicy{UncertainPolicy_REISSUE_T, UncertainPolicy_BLIND_C}
+
+	for n := 1; n <= 3; n++ {
+		for _, edges := range allDAGs(n) {
+			for _, policy := range policies {
+				res, err := Explore(exploreSaga(n, edges, policy), ExploreOptions{MaxCrashes: 1})
+				assert.NilError(t, err)
+				for _, v := range res.Violations {
+					t.Errorf("%v vertices, edges %v, %v: %v", n, edges, policy, v)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		n       int
+		edges   [][2]int
+		crashes int
+	}{
+		{"chain", 4, [][2]int{{0, 1}, {1, 2}, {2, 3}}, 2},
+		{"diamond", 4, [][2]int{{0, 1}, {0, 2}, {1, 3}, {2, 3}}, 2},
+		{"fan", 4, [][2]int{{0, 1}, {0, 2}, {0, 3}}, 2},
+		{"long chain", 5, [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 4}}, 1},
+		{"bottom peak", 5, [][2]int{{0, 2}, {1, 2}, {2, 3}, {2, 4}}, 1},
+	}
+	for _, tt := range tests {
+		for _, policy := range policies {
+			res, err := Explore(exploreSaga(tt.n, tt.edges, policy), ExploreOptions{MaxCrashes: tt.crashes})
+			assert.NilError(t, err)
+			assert.Assert(t, res.States > 0)
+			for _, v := range res.Violations {
+				t.Errorf("%v, %v: %v", tt.name, policy, v)
+			}
+		}
+	}
+}
+
+func TestExploreFailedC(t *testing.T) {
+	// A failed C is scheduled again, so its saga still finishes
+	res, err := Explore(exploreSaga(2, [][2]int{{0, 1}}, UncertainPolicy_REISSUE_T), ExploreOptions{FailC: true, MaxCrashes: 1})
+	assert.NilError(t, err)
+	assert.Assert(t, res.States > 0)
+	for _, v := range res.Violations {
+		t.Error(v)
+	}
+}
+
+func TestExploreCompensationOrder(t *testing.T) {
+	// Only sagas compensated in reverse topological order wait on their descendants,
+	// but the topological order still compensates every applied T
+	for _, order := range []CompensationOrder{CompensationOrder_REVERSE_TOPOLOGICAL, CompensationOrder_TOPOLOGICAL} {
+		saga := exploreSaga(4, [][2]int{{0, 1}, {0, 2}, {1, 3}, {2, 3}}, UncertainPolicy_REISSUE_T)
+		saga.Compensation = order
+		res, err := Explore(saga, ExploreOptions{MaxCrashes: 1})
+		assert.NilError(t, err)
+		assert.Assert(t, res.States > 0)
+		for _, v := range res.Violations {
+			t.Errorf("%v: %v", order, v)
+		}
+	}
+}
+
+func TestExploreNoCompensation(t *testing.T) {
+	// A vertex without C is compensated at once, even when its T outcome is unknown
+	saga := exploreSaga(3, [][2]int{{0, 1}, {1, 2}}, UncertainPolicy_REISSUE_T)
+	saga.Vertices.Set("1", Vertex{Id: "1", ReadOnly: true})
+	res, err := Explore(saga, ExploreOptions{MaxCrashes: 1})
+	assert.NilError(t, err)
+	assert.Assert(t, res.States > 0)
+	for _, v := range res.Violations {
+		t.Error(v)
+	}
+}
+
+func TestExploreUnsupported(t *testing.T) {
+	// Sagas the explorer cannot model faithfully are rejected
+	tests := []struct {
+		name string
+		edit func(saga *Saga)
+	}{
+		{"forward", func(saga *Saga) { saga.Recovery = RecoveryMode_FORWARD }},
+		{"predicate", func(saga *Saga) { saga.Predicates = map[string]map[string]string{"0": {"1": `a == "1"`}} }},
+		{"kind", func(saga *Saga) {
+			saga.Vertices.Set("1", Vertex{Id: "1", Kind: VertexKind_PIVOT, C: &Func{Url: "c", Method: "POST"}})
+		}},
+		{"child", func(saga *Saga) { saga.Vertices.Set("1", Vertex{Id: "1", Saga: &SagaMsg{}}) }},
+	}
+	for _, tt := range tests {
+		saga := exploreSaga(2, [][2]int{{0, 1}}, UncertainPolicy_REISSUE_T)
+		tt.edit(&saga)
+		_, err := Explore(saga, ExploreOptions{})
+		assert.Equal(t, err, ErrExploreUnsupported, tt.name)
+	}
+}
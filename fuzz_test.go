@@ -0,0 +1,326 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+var (
+	fuzzSeed  = flag.Int64("fuzz.seed", 0, "seed of the saga fuzzer. 0 picks a seed from the time")
+	fuzzCases = flag.Int("fuzz.cases", 200, "number of random sagas the saga fuzzer runs")
+)
+
+// Properties checked by the saga fuzzer
+var (
+	errFuzzUnfinished  = errors.New("saga did not terminate")
+	errFuzzCompensated = errors.New("committed saga compensated a vertex")
+)
+
+const (
+	fuzzMaxVertices = 12
+	fuzzMaxDelay    = 5 * time.Millisecond
+	// fuzzTimeout is in the virtual time of the fuzzer's synctest bubble
+	fuzzTimeout = 10 * time.Second
+)
+
+// fuzzCase is a saga of LOCAL vertices. Edges only go from lower to higher
+// vertex indices so every case is a DAG
+type fuzzCase struct {
+	edges  [][2]int
+	fail   []bool
+	delays []time.Duration
+}
+
+// newFuzzCase generates a DAG whose size, density and failure rate are random
+func newFuzzCase(r *rand.Rand) fuzzCase {
+	n := r.Intn(fuzzMaxVertices) + 1
+	density := r.Float64()
+	failRate := r.Float64() * 0.3
+
+	fc := fuzzCase{
+		fail:   make([]bool, n),
+		delays: make([]time.Duration, n),
+	}
+	for i := 0; i < n; i++ {
+		fc.fail[i] = r.Float64() < failRate
+		fc.delays[i] = time.Duration(r.Int63n(int64(fuzzMaxDelay)))
+		for j := i + 1; j < n; j++ {
+			if r.Float64() < density/2 {
+				fc.edges = append(fc.edges, [2]int{i, j})
+			}
+		}
+	}
+	return fc
+}
+
+func (fc fuzzCase) saga(id string) Saga {
+	vertices := make(map[string]Vertex, len(fc.fail))
+	dag := make(map[string]map[string][]string, len(fc.fail))
+	for i, fail := range fc.fail {
+		vid := strconv.Itoa(i)
+		success := "1"
+		if fail {
+			success = "0"
+		}
+		vtx := localVertex(vid, success, Status_NOT_REACHED)
+		vtx.T.Body["delay"] = fc.delays[i].String()
+		vtx.C.Body["delay"] = fc.delays[i].String()
+		vertices[vid] = vtx
+		dag[vid] = make(map[string][]string)
+	}
+	for _, e := range fc.edges {
+		dag[strconv.Itoa(e[0])][strconv.Itoa(e[1])] = nil
+	}
+	saga := NewSaga(vertices, dag)
+	saga.ID = id
+	return saga
+}
+
+func (fc fuzzCase) String() string {
+	var fail []string
+	for i, f := range fc.fail {
+		if f {
+			fail = append(fail, strconv.Itoa(i))
+		}
+	}
+	return fmt.Sprintf("vertices=%v edges=%v fail=%v delays=%v", len(fc.fail), fc.edges, fail, fc.delays)
+}
+
+// shrinkCandidates are the cases one step smaller than fc
+func (fc fuzzCase) shrinkCandidates() []fuzzCase {
+	var out []fuzzCase
+
+	// Remove a vertex and its edges
+	for v := range fc.fail {
+		if len(fc.fail) == 1 {
+			break
+		}
+		c := fuzzCase{}
+		for i := range fc.fail {
+			if i != v {
+				c.fail = append(c.fail, fc.fail[i])
+				c.delays = append(c.delays, fc.delays[i])
+			}
+		}
+		for _, e := range fc.edges {
+			if e[0] == v || e[1] == v {
+				continue
+			}
+			for k := range e {
+				if e[k] > v {
+					e[k]--
+				}
+			}
+			c.edges = append(c.edges, e)
+		}
+		out = append(out, c)
+	}
+
+	// Remove an edge
+	for i := range fc.edges {
+		c := fc.clone()
+		c.edges = append(c.edges[:i], c.edges[i+1:]...)
+		out = append(out, c)
+	}
+
+	// Make a vertex succeed or take no time
+	for i := range fc.fail {
+		if fc.fail[i] {
+			c := fc.clone()
+			c.fail[i] = false
+			out = append(out, c)
+		}
+		if fc.delays[i] != 0 {
+			c := fc.clone()
+			c.delays[i] = 0
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (fc fuzzCase) clone() fuzzCase {
+	return fuzzCase{
+		edges:  append([][2]int(nil), fc.edges...),
+		fail:   append([]bool(nil), fc.fail...),
+		delays: append([]time.Duration(nil), fc.delays...),
+	}
+}
+
+// shrink repeatedly replaces fc with a smaller case that still fails until none does
+func shrink(fc fuzzCase, fails func(fuzzCase) error) (fuzzCase, error) {
+	err := fails(fc)
+	for progress := true; progress; {
+		progress = false
+		for _, c := range fc.shrinkCandidates() {
+			if cerr := fails(c); cerr != nil {
+				fc, err, progress = c, cerr, true
+				break
+			}
+		}
+	}
+	return fc, err
+}
+
+// runFuzzCases runs every case on a new coordinator and returns the property
+// each case broke, or nil. Cases run in a synctest bubble on a simulated
+// scheduler, so the same cases always run the same way
+func runFuzzCases(t *testing.T, cases []fuzzCase) []error {
+	errs := make([]error, len(cases))
+	synctest.Test(t, func(t *testing.T) {
+		invalid := make([]chan struct{}, len(cases))
+		for i := range invalid {
+			invalid[i] = make(chan struct{})
+		}
+		var mtx sync.Mutex
+		onInvalid := func(saga Saga, err error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			i, _ := strconv.Atoi(saga.ID)
+			if errs[i] == nil {
+				errs[i] = err
+				close(invalid[i])
+			}
+		}
+
+		sched := &simScheduler{}
+		config := DefaultConfig()
+		config.Logger = discardLogger()
+		config.Clock = &simClock{}
+		config.Transport = fuzzTransport(sched)
+		config.Scheduler = sched
+		c := newCoordinator(config, NewBadgerDB(config.Path, config.InMemory), onInvalid)
+		defer c.Cleanup()
+
+		var wg sync.WaitGroup
+		for i, fc := range cases {
+			wg.Add(1)
+			go func(i int, fc fuzzCase) {
+				defer wg.Done()
+				err := runFuzzCase(c, strconv.Itoa(i), fc, invalid[i])
+				mtx.Lock()
+				defer mtx.Unlock()
+				if errs[i] == nil {
+					errs[i] = err
+				}
+			}(i, fc)
+			// Cases are created one at a time so they always start in the same order
+			synctest.Wait()
+		}
+		sched.run()
+		// Sagas still waiting now never finish, so their cases time out
+		wg.Wait()
+	})
+	return errs
+}
+
+// fuzzTransport runs LOCAL requests, waiting out their delay on the scheduler
+func fuzzTransport(sched *simScheduler) Transport {
+	return func(ctx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error) {
+		delay, err := time.ParseDuration(body["delay"])
+		if err != nil {
+			return nil, ErrInvalidLocalRequest
+		}
+		sched.sleep(delay)
+		return HTTPReq(ctx, url, method, requestID, map[string]string{"success": body["success"]})
+	}
+}
+
+// runFuzzCase runs a case and checks that it terminates and, if it
+// committed, that nothing was compensated. Invalid is closed if the saga breaks an invariant
+func runFuzzCase(c *Coordinator, id string, fc fuzzCase, invalid <-chan struct{}) error {
+	replyCh := make(chan Saga, 1)
+	c.createCh <- createMsg{saga: fc.saga(id), replyCh: replyCh, sent: c.clock.Now()}
+
+	var saga Saga
+	select {
+	case saga = <-replyCh:
+	case <-invalid:
+		return nil
+	case <-time.After(fuzzTimeout):
+		return errFuzzUnfinished
+	}
+
+	if _, aborted := CheckFinishedOrAbort(saga); aborted {
+		return nil
+	}
+	it := c.logs.ScanSaga(id)
+	defer it.Close()
+	for it.Next() {
+		if it.Log().LogType != VertexLog {
+			continue
+		}
+		vtx, err := it.Log().Vertex()
+		if err != nil {
+			return err
+		}
+		if vtx.Status == Status_START_C || vtx.Status == Status_END_C {
+			return errFuzzCompensated
+		}
+	}
+	return it.Err()
+}
+
+func TestSagaFuzz(t *testing.T) {
+	seed := *fuzzSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %v", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	cases := make([]fuzzCase, *fuzzCases)
+	for i := range cases {
+		cases[i] = newFuzzCase(r)
+	}
+
+	for i, err := range runFuzzCases(t, cases) {
+		if err == nil {
+			continue
+		}
+		shrunk, serr := shrink(cases[i], func(fc fuzzCase) error {
+			return runFuzzCases(t, []fuzzCase{fc})[0]
+		})
+		if serr == nil {
+			// The case only fails alongside the other cases of the seed
+			t.Fatalf("case %v: %v\nreproduce with -fuzz.seed=%v\ndoes not fail on its own: %v", i, err, seed, cases[i])
+		}
+		t.Fatalf("case %v: %v\nreproduce with -fuzz.seed=%v\nshrunk to %v: %v", i, err, seed, shrunk, serr)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	// Fails whenever two failing vertices are joined by an edge
+	fails := func(fc fuzzCase) error {
+		for _, e := range fc.edges {
+			if fc.fail[e[0]] && fc.fail[e[1]] {
+				return errFuzzCompensated
+			}
+		}
+		return nil
+	}
+
+	r := rand.New(rand.NewSource(1))
+	shrunkCases := 0
+	for i := 0; i < 100; i++ {
+		fc := newFuzzCase(r)
+		if fails(fc) == nil {
+			continue
+		}
+		shrunk, err := shrink(fc, fails)
+		assert.Equal(t, err, errFuzzCompensated)
+		assert.Equal(t, shrunk.String(), "vertices=2 edges=[[0 1]] fail=[0 1] delays=[0s 0s]", fc.String())
+		shrunkCases++
+	}
+	assert.Assert(t, shrunkCases > 0)
+}
@@ -26,11 +26,12 @@ func NewClient(addr string) HotelsClient {
 	return NewHotelsClient(cc)
 }
 
+// clientInterceptor bounds each call by ClientTimeout and sends its trace context
 func clientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 	ctx, cancel := context.WithTimeout(ctx, ClientTimeout*time.Second)
 	defer cancel()
 
-	return invoker(ctx, method, req, reply, cc, opts...)
+	return utils.ClientInterceptor(ctx, method, req, reply, cc, invoker, opts...)
 }
 
 func contextWithRequestID(userID string) context.Context {
@@ -0,0 +1,79 @@
+package hotels
+
+import (
+	context "context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type grpcHandler func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error)
+
+// NewGateway serves BookRPC and CancelRPC as JSON over HTTP at /book and /cancel, like
+// the envoy transcoder does, so the coordinator can reach hotels without envoy.
+// Reservations are listed at /reservations
+func NewGateway(addr string, h *Hotels) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/book", gatewayHandler(h, _Hotels_BookRPC_Handler))
+	mux.Handle("/cancel", gatewayHandler(h, _Hotels_CancelRPC_Handler))
+	mux.HandleFunc("/reservations", func(w http.ResponseWriter, r *http.Request) {
+		reservations := make([]Reservation, 0, h.Reservations.Count())
+		for tuple := range h.Reservations.IterBuffered() {
+			reservations = append(reservations, tuple.Val.(Reservation))
+		}
+		sort.Slice(reservations, func(i, j int) bool { return reservations[i].ID < reservations[j].ID })
+		json.NewEncoder(w).Encode(reservations)
+	})
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(lis)
+
+	return server
+}
+
+// gatewayHandler calls a gRPC handler through the server interceptor, passing the
+// request-id and traceparent headers as metadata
+func gatewayHandler(h *Hotels, handler grpcHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		md := metadata.MD{}
+		for _, key := range []string{"request-id", "traceparent"} {
+			if values := r.Header.Values(key); len(values) > 0 {
+				md.Set(key, values...)
+			}
+		}
+		ctx := metadata.NewIncomingContext(r.Context(), md)
+
+		dec := func(req interface{}) error {
+			return json.NewDecoder(r.Body).Decode(req)
+		}
+		reply, err := handler(h, ctx, dec, serverInterceptor)
+		if err != nil {
+			st := status.Convert(err)
+			code := http.StatusInternalServerError
+			switch st.Code() {
+			case codes.InvalidArgument:
+				code = http.StatusBadRequest
+			case codes.Unavailable:
+				code = http.StatusServiceUnavailable
+			}
+			http.Error(w, st.Message(), code)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	})
+}
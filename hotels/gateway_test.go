@@ -0,0 +1,74 @@
+package hotels
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/triplewy/sagas/utils"
+)
+
+func gatewayPost(t *testing.T, url, requestID string, body map[string]string) (int, map[string]string) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("request-id", requestID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var reply map[string]string
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return resp.StatusCode, reply
+}
+
+func TestGateway(t *testing.T) {
+	_, h := NewServer(utils.AvailableAddr())
+	addr := utils.AvailableAddr()
+	server := NewGateway(addr, h)
+	defer server.Close()
+	url := "http://" + addr
+
+	code, reply := gatewayPost(t, url+"/book", "0", map[string]string{"userID": "user0", "roomID": "room0"})
+	if code != http.StatusOK || reply["reservationID"] != "1" {
+		t.Fatalf("Expected: %v %v, Got: %v %v\n", http.StatusOK, "1", code, reply)
+	}
+
+	// Re-issued requests are deduped by request ID
+	code, reply = gatewayPost(t, url+"/book", "0", map[string]string{"userID": "user0", "roomID": "room0"})
+	if code != http.StatusOK || reply["reservationID"] != "1" {
+		t.Fatalf("Expected: %v %v, Got: %v %v\n", http.StatusOK, "1", code, reply)
+	}
+
+	code, _ = gatewayPost(t, url+"/book", "1", map[string]string{"userID": "user1", "roomID": "room0"})
+	if code != http.StatusInternalServerError {
+		t.Fatalf("Expected: %v, Got: %v\n", http.StatusInternalServerError, code)
+	}
+
+	code, _ = gatewayPost(t, url+"/cancel", "2", map[string]string{"userID": "user0", "reservationID": "1"})
+	if code != http.StatusOK {
+		t.Fatalf("Expected: %v, Got: %v\n", http.StatusOK, code)
+	}
+
+	resp, err := http.Get(url + "/reservations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var reservations []Reservation
+	if err := json.NewDecoder(resp.Body).Decode(&reservations); err != nil {
+		t.Fatal(err)
+	}
+	expected := Reservation{ID: "1", UserID: "user0", RoomID: "room0", Status: Canceled}
+	if len(reservations) != 1 || reservations[0] != expected {
+		t.Fatalf("Expected: %v, Got: %v\n", []Reservation{expected}, reservations)
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"net"
 	"time"
 
+	"github.com/triplewy/sagas/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
@@ -16,6 +20,8 @@ import (
 	"google.golang.org/grpc"
 )
 
+const tracerName = "github.com/triplewy/sagas/hotels"
+
 // Errors encountered by serving gRPC requests
 var (
 	ErrInvalidServer     = errors.New("unary interceptor encountered invalid server interface")
@@ -46,6 +52,14 @@ func NewServer(addr string) (*grpc.Server, *Hotels) {
 func serverInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	log.Println(info.FullMethod)
 
+	// Continue the trace of the coordinator's request. Envoy forwards the
+	// traceparent header as metadata
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = propagation.TraceContext{}.Extract(ctx, utils.MetadataCarrier(md))
+	}
+	ctx, span := otel.Tracer(tracerName).Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	h, ok := info.Server.(*Hotels)
 	if !ok {
 		return nil, serverError(ErrInvalidServer)
@@ -2,10 +2,15 @@ package sagas
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // Errors for HTTP Requests
@@ -13,10 +18,14 @@ var (
 	ErrInvalidLocalRequest = errors.New("invalid local request")
 	ErrAbortedLocalRequest = errors.New("aborted local request")
 	ErrInvalidHTTPMethod   = errors.New("invalid HTTP method")
+	ErrHTTPStatus          = errors.New("participant replied with an error status")
 )
 
-// HTTPReq issues an HTTP request based on the provided input
-func HTTPReq(url, method, requestID string, body map[string]string) (map[string]string, error) {
+// HTTPReq issues an HTTP request based on the provided input. The trace context in
+// ctx is sent in a traceparent header. A reply whose status is not 2xx fails with
+// ErrHTTPStatus, so a participant that rejects T aborts the saga and one that
+// rejects C has it retried
+func HTTPReq(ctx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error) {
 	client := http.Client{}
 
 	switch strings.ToUpper(method) {
@@ -25,21 +34,38 @@ func HTTPReq(url, method, requestID string, body map[string]string) (map[string]
 		if !ok {
 			return nil, ErrInvalidLocalRequest
 		}
+		// An optional delay such as "100ms" orders local requests in tests
+		if delay, ok := body["delay"]; ok {
+			d, err := time.ParseDuration(delay)
+			if err != nil {
+				return nil, ErrInvalidLocalRequest
+			}
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
 		if val == "0" {
 			return nil, ErrAbortedLocalRequest
 		}
 		return map[string]string{"success": "1"}, nil
 	case "GET":
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
 		req.Header.Set("request-id", requestID)
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 		resp, err := client.Do(req)
 		if err != nil {
 			return nil, err
 		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("%w: %v", ErrHTTPStatus, resp.Status)
+		}
 
 		var result map[string]string
 
@@ -52,17 +78,22 @@ func HTTPReq(url, method, requestID string, body map[string]string) (map[string]
 			return nil, err
 		}
 
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 		if err != nil {
 			return nil, err
 		}
 		req.Header.Set("content-type", "application/json")
 		req.Header.Set("request-id", requestID)
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 		resp, err := client.Do(req)
 		if err != nil {
 			return nil, err
 		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("%w: %v", ErrHTTPStatus, resp.Status)
+		}
 
 		var result map[string]string
 
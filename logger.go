@@ -1,14 +1,52 @@
 package sagas
 
 import (
-	"log"
-	"os"
+	"io"
+	"log/slog"
 )
 
-// Error is high-risk logger
-var Error *log.Logger
+// Formats of log output
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Keys of attributes that identify what a log line is about
+const (
+	logKeySaga    = "saga"
+	logKeyVertex  = "vertex"
+	logKeyPhase   = "phase"
+	logKeyAttempt = "attempt"
+	logKeyLsn     = "lsn"
+	logKeyError   = "error"
+)
+
+// NewLogger creates a logger that writes lines at or above level to w in format,
+// either LogFormatText or LogFormatJSON
+func NewLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// discardLogger drops every line
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
 
-// Initialize the loggers
-func init() {
-	Error = log.New(os.Stderr, "ERROR: ", log.Ltime|log.Lshortfile)
+// vertexLogger returns a logger for one call of a vertex's T or C. The attempt is
+// the vertex's count of calls of phase, which survives recovery
+func (c *Coordinator) vertexLogger(sagaID string, vertex Vertex, phase string) *slog.Logger {
+	attempt := vertex.Attempts
+	if phase == "C" {
+		attempt = vertex.CAttempts
+	}
+	return c.logger.With(
+		logKeySaga, sagaID,
+		logKeyVertex, vertex.Id,
+		logKeyPhase, phase,
+		logKeyAttempt, attempt,
+	)
 }
@@ -3,12 +3,16 @@ package sagas
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/triplewy/sagas/utils"
 )
 
-// ErrLogIndexNotFound is used when a log does not exist at a specified index
-var ErrLogIndexNotFound = errors.New("log index not found in log store")
+// Errors involving logs
+var (
+	ErrLogIndexNotFound = errors.New("log index not found in log store")
+	ErrLogTypeMismatch  = errors.New("log data does not hold the requested type")
+)
 
 // LogType is used for encoding and decoding structs into byte slices
 type LogType int
@@ -18,6 +22,10 @@ const (
 	InitLog LogType = iota + 1
 	GraphLog
 	VertexLog
+	// AbortLog records that an operator aborted a saga. It has no data
+	AbortLog
+	// TemplateLog records a registered template version. It belongs to no saga
+	TemplateLog
 )
 
 // GoString implements fmt GoString interface
@@ -29,13 +37,28 @@ func (t LogType) GoString() string {
 		return "Graph"
 	case VertexLog:
 		return "Vertex"
+	case AbortLog:
+		return "Abort"
+	case TemplateLog:
+		return "Template"
 	default:
 		return "Unknown"
 	}
 }
 
+// ParseLogType parses the name GoString returns for a LogType, ignoring case
+func ParseLogType(s string) (LogType, error) {
+	for _, t := range []LogType{InitLog, GraphLog, VertexLog, AbortLog, TemplateLog} {
+		if strings.EqualFold(s, t.GoString()) {
+			return t, nil
+		}
+	}
+	return 0, ErrUnknownLogType
+}
+
 // Log is stored on persistent disk to keep track of sagas
 type Log struct {
+	Version int
 	Lsn     uint64
 	SagaID  string
 	LogType LogType
@@ -46,7 +69,7 @@ type Log struct {
 func (log Log) GoString() string {
 	data := func() string {
 		switch log.LogType {
-		case InitLog:
+		case InitLog, AbortLog:
 			return "{}"
 		case GraphLog:
 			saga := decodeSaga(log.Data)
@@ -54,14 +77,46 @@ func (log Log) GoString() string {
 		case VertexLog:
 			vertex := decodeVertex(log.Data)
 			return vertex.String()
+		case TemplateLog:
+			t, err := unmarshalTemplate(log.Data)
+			if err != nil {
+				return err.Error()
+			}
+			return fmt.Sprintf("Template{Name: %v, Version: %v}", t.Name, t.Version)
 		default:
 			return "unknown data"
 		}
 	}()
-	return fmt.Sprintf("Log{\n\tLsn: %v,\n\tSagaID: %v,\n\tLogType: %#v,\n\tData: %v\n}", log.Lsn, log.SagaID, log.LogType, data)
+	return fmt.Sprintf("Log{\n\tVersion: %v,\n\tLsn: %v,\n\tSagaID: %v,\n\tLogType: %#v,\n\tData: %v\n}", log.Version, log.Lsn, log.SagaID, log.LogType, data)
+}
+
+// Saga decodes the saga held by a GraphLog
+func (log Log) Saga() (Saga, error) {
+	if log.LogType != GraphLog {
+		return Saga{}, ErrLogTypeMismatch
+	}
+	return unmarshalSaga(log.Data)
 }
 
+// Vertex decodes the vertex held by a VertexLog
+func (log Log) Vertex() (Vertex, error) {
+	if log.LogType != VertexLog {
+		return Vertex{}, ErrLogTypeMismatch
+	}
+	return unmarshalVertex(log.Data)
+}
+
+// Template decodes the template held by a TemplateLog
+func (log Log) Template() (Template, error) {
+	if log.LogType != TemplateLog {
+		return Template{}, ErrLogTypeMismatch
+	}
+	return unmarshalTemplate(log.Data)
+}
+
+// encodeLog encodes a log in the current version. Its Data must already be in the current version
 func encodeLog(log Log) []byte {
+	log.Version = LogVersion
 	buf, err := utils.EncodeMsgPack(log)
 	if err != nil {
 		panic(err)
@@ -69,11 +124,11 @@ func encodeLog(log Log) []byte {
 	return buf.Bytes()
 }
 
-func decodeLog(buf []byte) Log {
+// decodeLog decodes a log of any supported version and upgrades it to the current version
+func decodeLog(buf []byte) (Log, error) {
 	var out Log
-	err := utils.DecodeMsgPack(buf, &out)
-	if err != nil {
-		panic(err)
+	if err := utils.DecodeMsgPack(buf, &out); err != nil {
+		return Log{}, err
 	}
-	return out
+	return upgradeLog(out)
 }
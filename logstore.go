@@ -3,23 +3,33 @@ package sagas
 // LogStore is an interface for coordinator to store logs
 type LogStore interface {
 	// NewSagaID returns unique id for each saga
-	NewSagaID() string
-
+	NewSagaID() (string, error)
 	// NewRequestID returns unique id for each request
-	NewRequestID() string
-
+	NewRequestID() (string, error)
 	// LastIndex is used for recovery purposes
-	LastIndex() uint64
-
-	// AppendLog appends a log to the db
-	AppendLog(sagaID string, logType LogType, data []byte)
-
+	LastIndex() (uint64, error)
+	// AppendLog appends a log to the db and returns its index
+	AppendLog(sagaID string, logType LogType, data []byte) (uint64, error)
 	// GetLog returns a log at the specified index. Will return error if log doesn't exist
 	GetLog(index uint64) (Log, error)
-
+	// Scan returns an iterator over logs with index in [from, to] in index order
+	Scan(from, to uint64) LogIterator
+	// ScanSaga returns an iterator over all logs of a saga in index order
+	ScanSaga(sagaID string) LogIterator
 	// Close shuts down the db
 	Close()
-
 	// RemoveAll deletes all data from the db
 	RemoveAll()
 }
+
+// LogIterator iterates over logs in a LogStore. It must be closed after use
+type LogIterator interface {
+	// Next advances the iterator. Returns false when there are no more logs or on error
+	Next() bool
+	// Log returns the current log
+	Log() Log
+	// Err returns the error that stopped iteration, if any
+	Err() error
+	// Close releases the iterator
+	Close()
+}
@@ -1,21 +1,39 @@
 package sagas
 
 import (
+	"bytes"
 	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"testing"
 
+	"github.com/dgraph-io/badger"
 	"gotest.tools/assert"
 
 	cmap "github.com/orcaman/concurrent-map"
 
 	"go.uber.org/atomic"
+
+	"github.com/triplewy/sagas/utils"
 )
 
+var update = flag.Bool("update", false, "update golden files of the current log version")
+
 func TestLogStore(t *testing.T) {
 	config := DefaultConfig()
 
 	badger := LogStore(NewBadgerDB(config.Path, true))
+	batch := LogStore(NewBatchLogStore(NewBadgerDB(config.Path, true), DefaultMaxBatch))
+	keyring, err := NewKeyring(make([]byte, 32))
+	assert.NilError(t, err)
+	encrypted := LogStore(NewEncryptedBadgerDB(config.Path, true, keyring))
 
 	tests := []struct {
 		name  string
@@ -25,6 +43,14 @@ func TestLogStore(t *testing.T) {
 			name:  "badger",
 			store: badger,
 		},
+		{
+			name:  "batch",
+			store: batch,
+		},
+		{
+			name:  "encrypted",
+			store: encrypted,
+		},
 	}
 
 	for _, tt := range tests {
@@ -43,7 +69,11 @@ func TestLogStore(t *testing.T) {
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
-						id := store.NewSagaID()
+						id, err := store.NewSagaID()
+						if err != nil {
+							t.Error(err)
+							return
+						}
 						ok := m.SetIfAbsent(id, struct{}{})
 						if !ok {
 							dup.Store(true)
@@ -65,7 +95,11 @@ func TestLogStore(t *testing.T) {
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
-						id := store.NewRequestID()
+						id, err := store.NewRequestID()
+						if err != nil {
+							t.Error(err)
+							return
+						}
 						ok := m.SetIfAbsent(id, struct{}{})
 						if !ok {
 							dup.Store(true)
@@ -102,7 +136,8 @@ func TestLogStore(t *testing.T) {
 
 				for _, tt := range tests {
 					t.Run(tt.name, func(t *testing.T) {
-						sagaID := store.NewSagaID()
+						sagaID, err := store.NewSagaID()
+						assert.NilError(t, err)
 						data, err := func() ([]byte, error) {
 							switch tt.logType {
 							case GraphLog:
@@ -125,8 +160,10 @@ func TestLogStore(t *testing.T) {
 						}()
 						assert.NilError(t, err)
 
-						store.AppendLog(sagaID, tt.logType, data)
-						index := store.LastIndex()
+						lsn := mustAppendLog(t, store, sagaID, tt.logType, data)
+						index, err := store.LastIndex()
+						assert.NilError(t, err)
+						assert.Equal(t, lsn, index)
 						log, err := store.GetLog(index)
 
 						assert.NilError(t, err)
@@ -153,23 +190,38 @@ func TestLogStore(t *testing.T) {
 				logType := VertexLog
 				vertex := Vertex{Id: "0", Status: Status_NOT_REACHED}
 				data := encodeVertex(vertex)
-				startIndex := store.LastIndex()
+				startIndex, err := store.LastIndex()
+				assert.NilError(t, err)
 
 				var wg sync.WaitGroup
+				lsns := make([]uint64, 100)
 
 				for i := 0; i < 100; i++ {
 					wg.Add(1)
-					go func() {
+					go func(i int) {
 						defer wg.Done()
-						sagaID := store.NewSagaID()
-						store.AppendLog(sagaID, logType, data)
-					}()
+						sagaID, err := store.NewSagaID()
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if lsns[i], err = store.AppendLog(sagaID, logType, data); err != nil {
+							t.Error(err)
+						}
+					}(i)
 				}
 				wg.Wait()
 
-				endIndex := store.LastIndex()
+				endIndex, err := store.LastIndex()
+				assert.NilError(t, err)
 				assert.Equal(t, startIndex+100, endIndex)
 
+				// Every append gets its own lsn
+				sort.Slice(lsns, func(i, j int) bool { return lsns[i] < lsns[j] })
+				for i, lsn := range lsns {
+					assert.Equal(t, lsn, startIndex+uint64(i)+1)
+				}
+
 				for i := startIndex + 1; i <= endIndex; i++ {
 					log, err := store.GetLog(i)
 					assert.NilError(t, err)
@@ -177,7 +229,501 @@ func TestLogStore(t *testing.T) {
 					assert.DeepEqual(t, vertex, logVertex)
 				}
 			})
+
+			t.Run("scan", func(t *testing.T) {
+				sagaIDs := make([]string, 2)
+				for i := range sagaIDs {
+					sagaID, err := store.NewSagaID()
+					assert.NilError(t, err)
+					sagaIDs[i] = sagaID
+				}
+
+				startIndex, err := store.LastIndex()
+				assert.NilError(t, err)
+
+				// Interleave logs of both sagas
+				for i := 0; i < 10; i++ {
+					data := encodeVertex(Vertex{Id: strconv.Itoa(i)})
+					mustAppendLog(t, store, sagaIDs[i%2], VertexLog, data)
+				}
+
+				t.Run("range", func(t *testing.T) {
+					it := store.Scan(startIndex+3, startIndex+6)
+					defer it.Close()
+
+					var lsns []uint64
+					for it.Next() {
+						lsns = append(lsns, it.Log().Lsn)
+					}
+					assert.NilError(t, it.Err())
+					assert.DeepEqual(t, lsns, []uint64{startIndex + 3, startIndex + 4, startIndex + 5, startIndex + 6})
+				})
+
+				t.Run("saga", func(t *testing.T) {
+					it := store.ScanSaga(sagaIDs[1])
+					defer it.Close()
+
+					var ids []string
+					for it.Next() {
+						assert.Equal(t, it.Log().SagaID, sagaIDs[1])
+						ids = append(ids, decodeVertex(it.Log().Data).Id)
+					}
+					assert.NilError(t, it.Err())
+					assert.DeepEqual(t, ids, []string{"1", "3", "5", "7", "9"})
+				})
+			})
 		})
 	}
 
 }
+
+// mustAppendLog appends a log and fails the test on error
+func mustAppendLog(t *testing.T, store LogStore, sagaID string, logType LogType, data []byte) uint64 {
+	t.Helper()
+	lsn, err := store.AppendLog(sagaID, logType, data)
+	assert.NilError(t, err)
+	return lsn
+}
+
+func TestAppendLogsTooBig(t *testing.T) {
+	tests := []struct {
+		name     string
+		inMemory bool
+		logs     int
+		size     int
+	}{
+		// In memory, values are stored in the transaction, so large logs overflow it
+		{name: "in memory", inMemory: true, logs: 32, size: 1 << 20},
+		// On disk, values go to the value log, so only the number of logs counts
+		{name: "on disk", logs: 1 << 16, size: 1 << 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewBadgerDB(t.TempDir(), tt.inMemory)
+			defer store.Close()
+
+			startIndex, err := store.LastIndex()
+			assert.NilError(t, err)
+
+			// A batch larger than a transaction is rejected rather than partly written
+			logs := make([]Log, tt.logs)
+			for i := range logs {
+				logs[i] = Log{SagaID: "1", LogType: VertexLog, Data: make([]byte, tt.size)}
+			}
+			assert.Equal(t, store.AppendLogs(logs), badger.ErrTxnTooBig)
+
+			lastIndex, err := store.LastIndex()
+			assert.NilError(t, err)
+			assert.Equal(t, lastIndex, startIndex)
+			it := store.ScanSaga("1")
+			defer it.Close()
+			assert.Assert(t, !it.Next())
+			assert.NilError(t, it.Err())
+		})
+	}
+}
+
+func TestBatchLogStoreTooBig(t *testing.T) {
+	store := NewBatchLogStore(NewBadgerDB(DefaultConfig().Path, true), DefaultMaxBatch)
+	defer store.Close()
+
+	// Together the logs overflow a transaction, and the last one overflows it alone
+	batch := make([]appendReq, 21)
+	for i := range batch {
+		size := 1 << 20
+		if i == len(batch)-1 {
+			size = 16 << 20
+		}
+		batch[i] = appendReq{
+			log:  Log{SagaID: strconv.Itoa(i), LogType: VertexLog, Data: make([]byte, size)},
+			done: make(chan appendResult, 1),
+		}
+	}
+	store.flush(batch)
+
+	var prev uint64
+	for i, req := range batch[:len(batch)-1] {
+		res := <-req.done
+		assert.NilError(t, res.err, "log %v", i)
+		assert.Assert(t, res.lsn > prev, "log %v", i)
+		prev = res.lsn
+
+		log, err := store.GetLog(res.lsn)
+		assert.NilError(t, err)
+		assert.Equal(t, log.SagaID, strconv.Itoa(i))
+	}
+	res := <-batch[len(batch)-1].done
+	assert.Equal(t, res.err, badger.ErrTxnTooBig)
+}
+
+func TestEncryptedLogStore(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "sagas-encrypted")
+	defer os.RemoveAll(path)
+
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	secret := "payment-ref-4242"
+
+	vertex := Vertex{
+		Id: "1",
+		T: &Func{
+			Body: map[string]string{"payment": secret},
+		},
+	}
+	data := encodeVertex(vertex)
+	saga := NewSaga(map[string]Vertex{"1": vertex}, map[string]map[string][]string{"1": {}})
+	saga.ID = "1"
+
+	// Write logs with old key
+	keyring, err := NewKeyring(oldKey)
+	assert.NilError(t, err)
+	store := NewEncryptedBadgerDB(path, false, keyring)
+	mustAppendLog(t, store, saga.ID, GraphLog, encodeSaga(saga))
+	oldIndex := mustAppendLog(t, store, saga.ID, VertexLog, data)
+
+	t.Run("ciphertext", func(t *testing.T) {
+		err := store.db.View(func(txn *badger.Txn) error {
+			item, err := txn.Get(logKey(oldIndex))
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			assert.Assert(t, !bytes.Contains(val, []byte(secret)))
+			return nil
+		})
+		assert.NilError(t, err)
+	})
+	store.Close()
+
+	t.Run("no key", func(t *testing.T) {
+		store := NewBadgerDB(path, false)
+		defer store.Close()
+		_, err := store.GetLog(oldIndex)
+		assert.Equal(t, err, ErrNoEncryptionKey)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		// Rotate in new key, old logs must stay readable
+		keyring, err := NewKeyring(newKey, oldKey)
+		assert.NilError(t, err)
+		store := NewEncryptedBadgerDB(path, false, keyring)
+		log, err := store.GetLog(oldIndex)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, decodeVertex(log.Data), vertex)
+		mustAppendLog(t, store, saga.ID, VertexLog, data)
+
+		// Recover must decrypt logs written with either key
+		sagas, err := Recover(store)
+		assert.NilError(t, err)
+		recovered, ok := sagas[saga.ID].getVtx("1")
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, recovered, vertex)
+
+		assert.NilError(t, store.Reencrypt())
+		store.Close()
+
+		// After reencrypting, old key can be dropped
+		keyring, err = NewKeyring(newKey)
+		assert.NilError(t, err)
+		store = NewEncryptedBadgerDB(path, false, keyring)
+		defer store.Close()
+
+		it := store.Scan(oldIndex, math.MaxUint64)
+		defer it.Close()
+		count := 0
+		for it.Next() {
+			// Each open also appends an init log
+			if it.Log().LogType != VertexLog {
+				continue
+			}
+			assert.DeepEqual(t, decodeVertex(it.Log().Data), vertex)
+			count++
+		}
+		assert.NilError(t, it.Err())
+		assert.Equal(t, count, 2)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		keyring, err := NewKeyring(oldKey)
+		assert.NilError(t, err)
+		store := NewEncryptedBadgerDB(path, false, keyring)
+		defer store.Close()
+		_, err = store.GetLog(oldIndex)
+		assert.Equal(t, err, ErrUnknownEncryptionKey)
+	})
+}
+
+func TestReadOnlyLogStore(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "sagas-readonly")
+	defer os.RemoveAll(path)
+
+	vertex := Vertex{Id: "1", Status: Status_END_T}
+	store := NewBadgerDB(path, false)
+	index := mustAppendLog(t, store, "1", VertexLog, encodeVertex(vertex))
+	store.Close()
+
+	store, err := OpenBadgerReadOnly(path, nil)
+	assert.NilError(t, err)
+	defer store.Close()
+
+	log, err := store.GetLog(index)
+	assert.NilError(t, err)
+	decoded, err := log.Vertex()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, decoded, vertex)
+	_, err = log.Saga()
+	assert.Equal(t, err, ErrLogTypeMismatch)
+
+	_, err = store.AppendLog("1", VertexLog, encodeVertex(vertex))
+	assert.Equal(t, err, ErrReadOnly)
+	_, err = store.NewSagaID()
+	assert.Equal(t, err, ErrReadOnly)
+	_, err = store.Migrate()
+	assert.Equal(t, err, ErrReadOnly)
+}
+
+func TestSnapshotLogStore(t *testing.T) {
+	vertex := Vertex{Id: "1", Status: Status_END_T}
+	path := t.TempDir()
+	store := NewBadgerDB(path, false)
+	defer store.Close()
+	index := mustAppendLog(t, store, "1", VertexLog, encodeVertex(vertex))
+
+	// A copy of a store that is still open is what a crash leaves on disk
+	crashed := t.TempDir()
+	assert.NilError(t, copyStore(path, crashed))
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "in use", path: path},
+		{name: "not closed", path: crashed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := OpenBadgerReadOnly(tt.path, nil)
+			assert.Assert(t, errors.Is(err, ErrStoreNotClosed), err)
+
+			snapshot, err := OpenBadgerSnapshot(tt.path, nil)
+			assert.NilError(t, err)
+			log, err := snapshot.GetLog(index)
+			assert.NilError(t, err)
+			decoded, err := log.Vertex()
+			assert.NilError(t, err)
+			assert.DeepEqual(t, decoded, vertex)
+			_, err = snapshot.AppendLog("1", VertexLog, encodeVertex(vertex))
+			assert.Equal(t, err, ErrReadOnly)
+
+			snapshot.Close()
+			_, err = os.Stat(snapshot.path)
+			assert.Assert(t, os.IsNotExist(err))
+		})
+	}
+}
+
+// goldenLogs returns the logs encoded in every testdata/logs golden file, keyed by file name
+func goldenLogs() map[string]Log {
+	vertex := Vertex{
+		Id: "book",
+		T: &Func{
+			Url:       "http://localhost:51051/book",
+			Method:    "POST",
+			RequestId: "1",
+			Body:      map[string]string{"roomID": "room0"},
+			Resp:      map[string]string{"reservationID": "7"},
+		},
+		C: &Func{
+			Url:       "http://localhost:51051/cancel",
+			Method:    "POST",
+			RequestId: "2",
+			Body:      map[string]string{"reservationID": "7"},
+			Resp:      map[string]string{},
+		},
+		TransferFields: []string{"reservationID"},
+		Status:         Status_END_T,
+	}
+	saga := NewSaga(map[string]Vertex{"book": vertex}, map[string]map[string][]string{"book": {}})
+	saga.ID = "1"
+	// Sagas logged before version 2 compensate in topological order
+	saga.Compensation = CompensationOrder_TOPOLOGICAL
+
+	return map[string]Log{
+		"init":   {Version: LogVersion, Lsn: 1, SagaID: "0", LogType: InitLog, Data: []byte{0}},
+		"graph":  {Version: LogVersion, Lsn: 2, SagaID: "1", LogType: GraphLog, Data: encodeSaga(saga)},
+		"vertex": {Version: LogVersion, Lsn: 3, SagaID: "1", LogType: VertexLog, Data: encodeVertex(vertex)},
+	}
+}
+
+func TestLogVersions(t *testing.T) {
+	expected := goldenLogs()
+
+	if *update {
+		dir := filepath.Join("testdata", "logs", fmt.Sprintf("v%d", LogVersion))
+		assert.NilError(t, os.MkdirAll(dir, 0755))
+		for name, log := range expected {
+			err := ioutil.WriteFile(filepath.Join(dir, name+".golden"), encodeLog(log), 0644)
+			assert.NilError(t, err)
+		}
+	}
+
+	// Every version ever written must still decode to the same logs
+	for version := logVersion0; version <= LogVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			for name, log := range expected {
+				buf, err := ioutil.ReadFile(filepath.Join("testdata", "logs", fmt.Sprintf("v%d", version), name+".golden"))
+				assert.NilError(t, err)
+
+				decoded, err := decodeLog(buf)
+				assert.NilError(t, err)
+				assert.Equal(t, decoded.Version, LogVersion)
+				assert.Equal(t, decoded.Lsn, log.Lsn)
+				assert.Equal(t, decoded.SagaID, log.SagaID)
+				assert.Equal(t, decoded.LogType, log.LogType)
+
+				switch log.LogType {
+				case GraphLog:
+					assert.Assert(t, decodeSaga(decoded.Data).Equal(decodeSaga(log.Data)))
+				case VertexLog:
+					assert.DeepEqual(t, decodeVertex(decoded.Data), decodeVertex(log.Data))
+				default:
+					assert.DeepEqual(t, decoded.Data, log.Data)
+				}
+
+				// The current version must also encode exactly as its golden files
+				if version == LogVersion {
+					assert.DeepEqual(t, encodeLog(log), buf)
+				}
+			}
+		})
+	}
+
+	t.Run("v1 child", func(t *testing.T) {
+		// Child sagas logged with their parent vertex keep the topological order too
+		child := &SagaMsg{Vertices: map[string]*Vertex{"book": {Id: "book", Status: Status_NOT_REACHED}}}
+		data := encodeVertex(Vertex{Id: "trip", Saga: child, Status: Status_START_T})
+		buf, err := utils.EncodeMsgPack(Log{Version: logVersion1, Lsn: 4, SagaID: "1", LogType: VertexLog, Data: data})
+		assert.NilError(t, err)
+		decoded, err := decodeLog(buf.Bytes())
+		assert.NilError(t, err)
+		vtx, err := decoded.Vertex()
+		assert.NilError(t, err)
+		assert.Equal(t, vtx.Saga.CompensationOrder, CompensationOrder_TOPOLOGICAL)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		buf, err := utils.EncodeMsgPack(Log{Version: LogVersion + 1, Lsn: 1, SagaID: "0", LogType: InitLog})
+		assert.NilError(t, err)
+		_, err = decodeLog(buf.Bytes())
+		assert.Equal(t, err, ErrUnsupportedLogVersion)
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	store := NewBadgerDB("", true)
+	defer store.Close()
+
+	// Write v0 logs as unversioned code did, without a saga index
+	err := store.db.Update(func(txn *badger.Txn) error {
+		for lsn, name := range []string{"init", "graph", "vertex"} {
+			buf, err := ioutil.ReadFile(filepath.Join("testdata", "logs", "v0", name+".golden"))
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(logKey(uint64(lsn+1)), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	migrated, err := store.Migrate()
+	assert.NilError(t, err)
+	assert.Equal(t, migrated, 3)
+
+	// All logs are now stored in the current version
+	err = store.db.View(func(txn *badger.Txn) error {
+		for lsn := uint64(1); lsn <= 3; lsn++ {
+			item, err := txn.Get(logKey(lsn))
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var log Log
+			if err := utils.DecodeMsgPack(val, &log); err != nil {
+				return err
+			}
+			assert.Equal(t, log.Version, LogVersion)
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+
+	// Migrated logs are indexed and recoverable
+	it := store.ScanSaga("1")
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	assert.NilError(t, it.Err())
+	assert.Equal(t, count, 2)
+
+	sagas, err := Recover(store)
+	assert.NilError(t, err)
+	vertex, ok := sagas["1"].getVtx("book")
+	assert.Assert(t, ok)
+	assert.Equal(t, vertex.Status, Status_END_T)
+
+	// Migrating again is a no-op
+	migrated, err = store.Migrate()
+	assert.NilError(t, err)
+	assert.Equal(t, migrated, 0)
+}
+
+func BenchmarkAppendLog(b *testing.B) {
+	data := encodeVertex(Vertex{Id: "0", Status: Status_NOT_REACHED})
+	path := filepath.Join(os.TempDir(), "sagas-bench")
+
+	benchmarks := []struct {
+		name     string
+		newStore func() LogStore
+	}{
+		{
+			name:     "badger",
+			newStore: func() LogStore { return NewBadgerDB(path, false) },
+		},
+		{
+			name:     "batch",
+			newStore: func() LogStore { return NewBatchLogStore(NewBadgerDB(path, false), DefaultMaxBatch) },
+		},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			store := bm.newStore()
+			defer store.RemoveAll()
+			defer store.Close()
+
+			// Many goroutines appending at once is what group commit is for
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := store.AppendLog("0", VertexLog, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Classes of errors returned by vertex calls
+const (
+	errClassAborted = "aborted"
+	errClassInvalid = "invalid"
+	errClassTimeout = "timeout"
+	errClassNetwork = "network"
+	errClassStatus  = "status"
+	errClassOther   = "other"
+)
+
+// metrics are the prometheus metrics of a coordinator. Each coordinator has its own
+// registry so that several coordinators can run in one process
+type metrics struct {
+	registry *prometheus.Registry
+
+	sagasStarted     prometheus.Counter
+	sagasCommitted   prometheus.Counter
+	sagasAborted     prometheus.Counter
+	sagasCompensated prometheus.Counter
+	sagasInFlight    prometheus.Gauge
+
+	vertexLatency    *prometheus.HistogramVec
+	vertexRetries    *prometheus.CounterVec
+	vertexErrors     *prometheus.CounterVec
+	verticesInFlight prometheus.Gauge
+
+	queueWait        *prometheus.HistogramVec
+	appendLogLatency prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		sagasStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sagas",
+			Name:      "started_total",
+			Help:      "Number of sagas started by clients.",
+		}),
+		sagasCommitted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sagas",
+			Name:      "committed_total",
+			Help:      "Number of sagas that finished with every T committed.",
+		}),
+		sagasAborted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sagas",
+			Name:      "aborted_total",
+			Help:      "Number of sagas that aborted.",
+		}),
+		sagasCompensated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sagas",
+			Name:      "compensated_total",
+			Help:      "Number of aborted sagas that finished compensating.",
+		}),
+		sagasInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sagas",
+			Name:      "in_flight",
+			Help:      "Number of sagas that have not finished.",
+		}),
+		vertexLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "sagas",
+			Name:      "vertex_call_duration_seconds",
+			Help:      "Latency of vertex T and C calls.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"url", "func"}),
+		vertexRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sagas",
+			Name:      "vertex_retries_total",
+			Help:      "Number of vertex calls issued again after a crash or a failed call.",
+		}, []string{"url", "func"}),
+		vertexErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "sagas",
+			Name:      "vertex_errors_total",
+			Help:      "Number of failed vertex calls by error class.",
+		}, []string{"func", "class"}),
+		verticesInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sagas",
+			Name:      "vertices_in_flight",
+			Help:      "Number of vertex calls in progress.",
+		}),
+		queueWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "sagas",
+			Name:      "queue_wait_seconds",
+			Help:      "Time messages wait before the coordinator's run loop receives them.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"queue"}),
+		appendLogLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "sagas",
+			Name:      "append_log_duration_seconds",
+			Help:      "Latency of appending a log to the log store.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.sagasStarted,
+		m.sagasCommitted,
+		m.sagasAborted,
+		m.sagasCompensated,
+		m.sagasInFlight,
+		m.vertexLatency,
+		m.vertexRetries,
+		m.vertexErrors,
+		m.verticesInFlight,
+		m.queueWait,
+		m.appendLogLatency,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// serveMetrics serves metrics at /metrics on addr
+func serveMetrics(addr string, m *metrics) *http.Server {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.handler())
+	srv := &http.Server{Handler: mux}
+
+	go srv.Serve(lis)
+
+	return srv
+}
+
+// errorClass groups errors of vertex calls into a few classes for metrics
+func errorClass(err error) string {
+	// Transports wrap these errors with details such as the reply status
+	switch {
+	case errors.Is(err, ErrAbortedLocalRequest):
+		return errClassAborted
+	case errors.Is(err, ErrInvalidLocalRequest), errors.Is(err, ErrInvalidHTTPMethod):
+		return errClassInvalid
+	case errors.Is(err, ErrHTTPStatus):
+		return errClassStatus
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errClassTimeout
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return errClassNetwork
+	}
+	return errClassOther
+}
+
+// call issues a vertex's T or C and records its latency and errors
+func (c *Coordinator) call(ctx context.Context, fn string, f *Func) (map[string]string, error) {
+	c.metrics.verticesInFlight.Inc()
+	defer c.metrics.verticesInFlight.Dec()
+
+	start := c.clock.Now()
+	resp, err := c.transport(ctx, f.GetUrl(), f.GetMethod(), f.GetRequestId(), f.GetBody())
+	c.metrics.vertexLatency.WithLabelValues(f.GetUrl(), fn).Observe(c.clock.Now().Sub(start).Seconds())
+	if err != nil {
+		c.metrics.vertexErrors.WithLabelValues(fn, errorClass(err)).Inc()
+	}
+	return resp, err
+}
+
+// appendLog appends a log, records its latency and adds an event to the span in ctx
+func (c *Coordinator) appendLog(ctx context.Context, sagaID string, logType LogType, data []byte) (uint64, error) {
+	start := c.clock.Now()
+	lsn, err := c.logs.AppendLog(sagaID, logType, data)
+	c.metrics.appendLogLatency.Observe(c.clock.Now().Sub(start).Seconds())
+	trace.SpanFromContext(ctx).AddEvent("append log", trace.WithAttributes(
+		attribute.String("log.type", logType.GoString()),
+		attribute.Int64("log.lsn", int64(lsn)),
+	))
+	return lsn, err
+}
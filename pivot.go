@@ -0,0 +1,161 @@
+package sagas
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Errors in pivot structures
+var (
+	ErrInvalidPivot  = errors.New("vertex kinds do not form a legal pivot structure")
+	ErrSagaPastPivot = errors.New("saga's pivot has started so it cannot be aborted")
+)
+
+// checkPivot checks that vertex kinds form a legal pivot structure. A saga has at
+// most one pivot. Every other vertex runs before it and is compensatable, or runs
+// after it and is retriable, so that nothing can fail and abort the saga once the
+// pivot commits. Retriable vertices need a pivot
+func checkPivot(kinds map[string]VertexKind, dag map[string]map[string][]string) error {
+	var pivots, retriable []string
+	for id, kind := range kinds {
+		switch kind {
+		case VertexKind_PIVOT:
+			pivots = append(pivots, id)
+		case VertexKind_RETRIABLE:
+			retriable = append(retriable, id)
+		}
+	}
+	sort.Strings(pivots)
+	sort.Strings(retriable)
+
+	if len(pivots) > 1 {
+		return fmt.Errorf("%w: vertices %v are all pivots", ErrInvalidPivot, pivots)
+	}
+	if len(pivots) == 0 {
+		if len(retriable) > 0 {
+			return fmt.Errorf("%w: retriable vertex %v has no pivot", ErrInvalidPivot, retriable[0])
+		}
+		return nil
+	}
+
+	pivot := pivots[0]
+	after := reachable(pivot, dag)
+	before := reachable(pivot, reverseDAG(dag))
+
+	ids := make([]string, 0, len(kinds))
+	for id := range kinds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		switch {
+		case id == pivot:
+		case after[id]:
+			if kinds[id] != VertexKind_RETRIABLE {
+				return fmt.Errorf("%w: vertex %v runs after pivot %v but is not retriable", ErrInvalidPivot, id, pivot)
+			}
+		case before[id]:
+			if kinds[id] != VertexKind_COMPENSATABLE {
+				return fmt.Errorf("%w: vertex %v runs before pivot %v but is not compensatable", ErrInvalidPivot, id, pivot)
+			}
+		default:
+			return fmt.Errorf("%w: vertex %v may run at the same time as pivot %v", ErrInvalidPivot, id, pivot)
+		}
+	}
+	return nil
+}
+
+// checkKinds checks the pivot structure of a saga message and its child sagas
+func checkKinds(msg *SagaMsg) error {
+	kinds := make(map[string]VertexKind, len(msg.GetVertices()))
+	dag := make(map[string]map[string][]string, len(msg.GetVertices()))
+	for id, vtx := range msg.GetVertices() {
+		child := vtx.GetSaga()
+		if err := checkVertexKind(id, vtx.GetKind(), vtx.GetUncertainPolicy(), child != nil, hasPivot(child)); err != nil {
+			return err
+		}
+		if child != nil {
+			if err := checkKinds(child); err != nil {
+				return err
+			}
+		}
+		kinds[id] = vtx.GetKind()
+		dag[id] = make(map[string][]string)
+	}
+	for _, edge := range msg.GetEdges() {
+		if _, ok := dag[edge.GetStartId()]; !ok {
+			return ErrIDNotFound
+		}
+		dag[edge.GetStartId()][edge.GetEndId()] = nil
+	}
+	return checkPivot(kinds, dag)
+}
+
+// checkVertexKind checks the rules a single vertex's kind must follow. child tells
+// whether the vertex runs a child saga and childPivot whether that saga has a pivot
+func checkVertexKind(id string, kind VertexKind, policy UncertainPolicy, child, childPivot bool) error {
+	if kind == VertexKind_PIVOT && policy == UncertainPolicy_BLIND_C {
+		return fmt.Errorf("%w: pivot %v cannot be compensated blindly", ErrInvalidPivot, id)
+	}
+	if child && kind == VertexKind_RETRIABLE {
+		return fmt.Errorf("%w: retriable vertex %v runs a child saga", ErrInvalidPivot, id)
+	}
+	// A child past its pivot cannot be compensated, so its vertex cannot be either
+	if childPivot && kind != VertexKind_PIVOT {
+		return fmt.Errorf("%w: vertex %v runs a child saga with a pivot but is not a pivot", ErrInvalidPivot, id)
+	}
+	return nil
+}
+
+func hasPivot(msg *SagaMsg) bool {
+	for _, vtx := range msg.GetVertices() {
+		if vtx.GetKind() == VertexKind_PIVOT {
+			return true
+		}
+	}
+	return false
+}
+
+// pivotStarted returns whether a saga's pivot has started or committed
+func pivotStarted(saga Saga) bool {
+	started := false
+	saga.Vertices.IterCb(func(key string, value interface{}) {
+		vtx := value.(Vertex)
+		if vtx.Kind == VertexKind_PIVOT && (vtx.Status == Status_START_T || vtx.Status == Status_END_T) {
+			started = true
+		}
+	})
+	return started
+}
+
+// reachable returns the ids reachable from id in dag, excluding id
+func reachable(id string, dag map[string]map[string][]string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := []string{id}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for child := range dag[next] {
+			if !seen[child] {
+				seen[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return seen
+}
+
+// reverseDAG returns dag with every edge reversed
+func reverseDAG(dag map[string]map[string][]string) map[string]map[string][]string {
+	res := make(map[string]map[string][]string, len(dag))
+	for parent, children := range dag {
+		for child := range children {
+			if res[child] == nil {
+				res[child] = make(map[string][]string)
+			}
+			res[child][parent] = nil
+		}
+	}
+	return res
+}
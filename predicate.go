@@ -0,0 +1,228 @@
+package sagas
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidPredicate is used when an edge predicate does not parse
+var ErrInvalidPredicate = errors.New("invalid edge predicate")
+
+// predicate is a compiled edge predicate, evaluated against the resp of the edge's parent T
+type predicate func(resp map[string]string) bool
+
+// compilePredicate compiles an edge predicate. Predicates compare fields of the
+// parent's T resp with string literals or other fields, for example
+//
+//	shuttle == "available" && (nights != "1" || !discount)
+//
+// A field on its own is true if it is set and not empty. An empty predicate is always true
+func compilePredicate(expr string) (predicate, error) {
+	if strings.TrimSpace(expr) == "" {
+		return func(map[string]string) bool { return true }, nil
+	}
+	tokens, err := lexPredicate(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &predicateParser{tokens: tokens}
+	pred, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %v in %q", ErrInvalidPredicate, p.tokens[p.pos].text, expr)
+	}
+	return pred, nil
+}
+
+// evalPredicate evaluates a predicate that was checked when its saga started
+func evalPredicate(expr string, resp map[string]string) bool {
+	pred, err := compilePredicate(expr)
+	if err != nil {
+		return false
+	}
+	return pred(resp)
+}
+
+type tokenKind int
+
+const (
+	tokenField tokenKind = iota
+	tokenString
+	tokenOp
+)
+
+type predicateToken struct {
+	kind tokenKind
+	text string
+}
+
+func lexPredicate(expr string) ([]predicateToken, error) {
+	var tokens []predicateToken
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '"':
+			// Find the closing quote, skipping escaped characters
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string in %q", ErrInvalidPredicate, expr)
+			}
+			s, err := strconv.Unquote(expr[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v in %q", ErrInvalidPredicate, err, expr)
+			}
+			tokens = append(tokens, predicateToken{tokenString, s})
+			i = j + 1
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			// Fields are ASCII, so every byte up to the next non-field byte is a rune
+			j := i
+			for ; j < len(expr) && isFieldByte(expr[j]); j++ {
+			}
+			tokens = append(tokens, predicateToken{tokenField, expr[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "&&", "||", "!", "(", ")"} {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected %q in %q", ErrInvalidPredicate, r, expr)
+			}
+			tokens = append(tokens, predicateToken{tokenOp, op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isFieldByte(b byte) bool {
+	return b == '_' || b == '.' || b == '-' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// predicateParser parses by recursive descent. || binds looser than &&, which binds looser than !
+type predicateParser struct {
+	tokens []predicateToken
+	pos    int
+}
+
+func (p *predicateParser) peek(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOp && p.tokens[p.pos].text == op
+}
+
+func (p *predicateParser) next() (predicateToken, error) {
+	if p.pos >= len(p.tokens) {
+		return predicateToken{}, fmt.Errorf("%w: unexpected end", ErrInvalidPredicate)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *predicateParser) or() (predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(resp map[string]string) bool { return l(resp) || right(resp) }
+	}
+	return left, nil
+}
+
+func (p *predicateParser) and() (predicate, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(resp map[string]string) bool { return l(resp) && right(resp) }
+	}
+	return left, nil
+}
+
+func (p *predicateParser) not() (predicate, error) {
+	if !p.peek("!") {
+		return p.primary()
+	}
+	p.pos++
+	pred, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return func(resp map[string]string) bool { return !pred(resp) }, nil
+}
+
+func (p *predicateParser) primary() (predicate, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == tokenOp {
+		if tok.text != "(" {
+			return nil, fmt.Errorf("%w: unexpected %v", ErrInvalidPredicate, tok.text)
+		}
+		pred, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidPredicate)
+		}
+		p.pos++
+		return pred, nil
+	}
+
+	left := operand(tok)
+	if p.peek("==") || p.peek("!=") {
+		equal := p.tokens[p.pos].text == "=="
+		p.pos++
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokenOp {
+			return nil, fmt.Errorf("%w: unexpected %v", ErrInvalidPredicate, tok.text)
+		}
+		right := operand(tok)
+		return func(resp map[string]string) bool { return (left(resp) == right(resp)) == equal }, nil
+	}
+	if tok.kind == tokenString {
+		return nil, fmt.Errorf("%w: string %q is not a condition", ErrInvalidPredicate, tok.text)
+	}
+	return func(resp map[string]string) bool { return left(resp) != "" }, nil
+}
+
+// operand returns the value of a field or string literal
+func operand(tok predicateToken) func(map[string]string) string {
+	if tok.kind == tokenString {
+		return func(map[string]string) string { return tok.text }
+	}
+	return func(resp map[string]string) string { return resp[tok.text] }
+}
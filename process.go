@@ -1,7 +1,16 @@
 package sagas
 
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
 // ProcessT runs a Vertex's  T
-func (c *Coordinator) ProcessT(sagaID string, vertex Vertex) {
+func (c *Coordinator) ProcessT(ctx context.Context, sagaID string, vertex Vertex) {
 	// Sanity check on vertex's status
 	if vertex.Status == Status_END_T {
 		return
@@ -10,19 +19,76 @@ func (c *Coordinator) ProcessT(sagaID string, vertex Vertex) {
 		panic(ErrInvalidSaga)
 	}
 
+	ctx, span := c.tracer.Start(ctx, "ProcessT", trace.WithAttributes(
+		attribute.String("saga.id", sagaID),
+		attribute.String("vertex.id", vertex.Id),
+		attribute.String("url", vertex.T.GetUrl()),
+	))
+	defer span.End()
+
+	vertex.Attempts++
+	log := c.vertexLogger(sagaID, vertex, "T")
+
+	// The child's id is logged with START_T so that recovery resumes the same child
+	if vertex.Saga != nil && vertex.SagaId == "" {
+		childID, err := c.logs.NewSagaID()
+		if err != nil {
+			log.Error("new child saga id failed", logKeyError, err)
+			panic(err)
+		}
+		vertex.SagaId = childID
+	}
+
 	// Append to log
-	data := encodeVertex(vertex)
-	c.logs.AppendLog(sagaID, VertexLog, data)
+	lsn, err := c.appendLog(ctx, sagaID, VertexLog, encodeVertex(vertex))
+	if err != nil {
+		log.Error("append vertex log failed", logKeyError, err)
+		panic(err)
+	}
+	log.Debug("issuing T", logKeyLsn, lsn, "url", vertex.T.GetUrl())
 
 	// Evaluate vertex's function
 	f := vertex.T
 
-	resp, err := HTTPReq(f.GetUrl(), f.GetMethod(), f.GetRequestId(), f.GetBody())
+	var resp map[string]string
+	if vertex.Saga != nil {
+		if err = c.runChild(ctx, sagaID, vertex); err == ErrCoordinatorClosed {
+			// Recovery resumes the vertex from START_T
+			return
+		}
+	} else {
+		resp, err = c.call(ctx, "T", f)
+		for wait := c.retryInterval; err != nil && c.retryForward(sagaID, vertex); wait = minDuration(2*wait, c.maxRetryInterval) {
+			log.Warn("T failed, retrying", logKeyLsn, lsn, logKeyError, err, "wait", wait)
+			vertex.LastError = err.Error()
+			c.recordAttempt(ctx, sagaID, vertex)
+			// An abort stops the retries of a saga in forward recovery. A retriable
+			// vertex is retried regardless, so an abort must not cut its wait short
+			var wake <-chan struct{}
+			if vertex.Kind != VertexKind_RETRIABLE {
+				wake = c.abortedCh(sagaID)
+			}
+			if !c.sleep(ctx, wake, wait) {
+				// The coordinator is stopping. Recovery resumes the vertex from START_T
+				return
+			}
+			if !c.retryForward(sagaID, vertex) {
+				break
+			}
+			c.metrics.vertexRetries.WithLabelValues(f.GetUrl(), "T").Inc()
+			vertex.Attempts++
+			log = c.vertexLogger(sagaID, vertex, "T")
+			resp, err = c.call(ctx, "T", f)
+		}
+	}
 	status := Status_END_T
 	if err != nil {
-		// Error.Println(err)
+		log.Warn("T failed", logKeyLsn, lsn, logKeyError, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		// Store error to output
 		f.Resp["error"] = err.Error()
+		vertex.LastError = err.Error()
 		// Set status to abort
 		status = Status_ABORT
 	} else {
@@ -33,20 +99,26 @@ func (c *Coordinator) ProcessT(sagaID string, vertex Vertex) {
 			vertex.C.Body[k] = f.Resp[k]
 		}
 	}
+	statusEvent(ctx, vertex.Status, status)
 	vertex.Status = status
 
 	// Append to log
-	c.logs.AppendLog(sagaID, VertexLog, encodeVertex(vertex))
+	if lsn, err = c.appendLog(ctx, sagaID, VertexLog, encodeVertex(vertex)); err != nil {
+		log.Error("append vertex log failed", logKeyError, err)
+		panic(err)
+	}
+	log.Debug("T finished", logKeyLsn, lsn, "status", status.String())
 
 	// Send newVertex to update chan for coordinator to update its map of sagas
 	c.updateCh <- updateMsg{
 		sagaID: sagaID,
 		vertex: vertex,
+		sent:   c.clock.Now(),
 	}
 }
 
 // ProcessC runs a Vertex's C
-func (c *Coordinator) ProcessC(sagaID string, vertex Vertex) {
+func (c *Coordinator) ProcessC(ctx context.Context, sagaID string, vertex Vertex) {
 	// Sanity check on vertex's status
 	if vertex.Status == Status_END_C {
 		return
@@ -54,38 +126,168 @@ func (c *Coordinator) ProcessC(sagaID string, vertex Vertex) {
 	if !(vertex.Status == Status_START_T || vertex.Status == Status_END_T || vertex.Status == Status_START_C) {
 		panic(ErrInvalidSaga)
 	}
-	// If vertex status is  Status_START_T should be ProcessT
+	// A vertex in START_T has an unknown T outcome. It can only be compensated
+	// blindly if its policy allows or it has nothing to compensate, otherwise T is
+	// re-issued to learn the outcome
+	if vertex.Status == Status_START_T && vertex.UncertainPolicy != UncertainPolicy_BLIND_C && !noCompensation(vertex) {
+		c.ProcessT(ctx, sagaID, vertex)
+		return
+	}
+
+	ctx, span := c.tracer.Start(ctx, "ProcessC", trace.WithAttributes(
+		attribute.String("saga.id", sagaID),
+		attribute.String("vertex.id", vertex.Id),
+		attribute.String("url", vertex.C.GetUrl()),
+	))
+	defer span.End()
+
+	vertex.CAttempts++
+	log := c.vertexLogger(sagaID, vertex, "C")
+
 	if vertex.Status == Status_START_T {
-		panic("Should be ProcessT")
+		statusEvent(ctx, vertex.Status, Status_START_C)
+		vertex.Status = Status_START_C
 	}
 
 	// Now vertex must either be Status_END_T or Status_START_C. Append to log
-	data := encodeVertex(vertex)
-	c.logs.AppendLog(sagaID, VertexLog, data)
+	lsn, err := c.appendLog(ctx, sagaID, VertexLog, encodeVertex(vertex))
+	if err != nil {
+		log.Error("append vertex log failed", logKeyError, err)
+		panic(err)
+	}
+	log.Debug("issuing C", logKeyLsn, lsn, "url", vertex.C.GetUrl())
 
 	// Evaluate vertex's function
 	f := vertex.C
 
-	resp, err := HTTPReq(f.GetUrl(), f.GetMethod(), f.GetRequestId(), f.GetBody())
-	status := Status_END_C
-	if err != nil {
-		// Store error to output
-		f.Resp["error"] = err.Error()
-		// set status to startC because did not succeed
-		status = Status_START_C
+	compensate := func() (map[string]string, error) {
+		if vertex.Saga != nil {
+			return nil, c.compensateChild(vertex)
+		}
+		return c.call(ctx, "C", f)
+	}
+
+	var resp map[string]string
+	if vertex.Saga == nil && f.noop() {
+		// Nothing to undo, so the vertex is compensated without a call
+		log.Debug("C is a no-op", logKeyLsn, lsn)
 	} else {
-		for k, v := range resp {
-			f.Resp[k] = v
+		// The saga cannot finish until C succeeds, so a failed C is retried with backoff
+		// for as long as it fails. Aborting does not wake it, since the saga is already
+		// aborted. Each failure is logged and each retry counted so operators see it
+		resp, err = compensate()
+		for wait := c.retryInterval; err != nil; wait = minDuration(2*wait, c.maxRetryInterval) {
+			if err == ErrCoordinatorClosed {
+				// Recovery resumes the vertex from START_C
+				return
+			}
+			log.Warn("C failed, retrying", logKeyLsn, lsn, logKeyError, err, "wait", wait)
+			span.RecordError(err)
+			vertex.LastError = err.Error()
+			c.recordAttempt(ctx, sagaID, vertex)
+			if !c.sleep(ctx, nil, wait) {
+				// The coordinator is stopping. Recovery resumes the vertex from START_C
+				return
+			}
+			c.metrics.vertexRetries.WithLabelValues(f.GetUrl(), "C").Inc()
+			vertex.CAttempts++
+			log = c.vertexLogger(sagaID, vertex, "C")
+			resp, err = compensate()
 		}
 	}
+	for k, v := range resp {
+		f.Resp[k] = v
+	}
+	status := Status_END_C
+	statusEvent(ctx, vertex.Status, status)
 	vertex.Status = status
 
 	// Append to log
-	c.logs.AppendLog(sagaID, VertexLog, encodeVertex(vertex))
+	if lsn, err = c.appendLog(ctx, sagaID, VertexLog, encodeVertex(vertex)); err != nil {
+		log.Error("append vertex log failed", logKeyError, err)
+		panic(err)
+	}
+	log.Debug("C finished", logKeyLsn, lsn, "status", status.String())
 
 	// Send newVertex to update chan for coordinator to continue saga
 	c.updateCh <- updateMsg{
 		sagaID: sagaID,
 		vertex: vertex,
+		sent:   c.clock.Now(),
+	}
+}
+
+// retryForward returns whether a failed T is retried rather than aborting its saga. A
+// retriable vertex is past the pivot, so it is always retried. A saga in forward
+// recovery retries until an operator aborts it
+func (c *Coordinator) retryForward(sagaID string, vertex Vertex) bool {
+	if vertex.Kind == VertexKind_RETRIABLE {
+		return true
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	saga, ok := c.sagas[sagaID]
+	return ok && saga.Recovery == RecoveryMode_FORWARD && !saga.aborted.Load()
+}
+
+// sleep waits for d on the coordinator's clock, or until wake is closed. It returns
+// false if ctx is done or the coordinator closes first
+func (c *Coordinator) sleep(ctx context.Context, wake <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-c.clock.After(d):
+	case <-wake:
+	case <-ctx.Done():
+		return false
+	case <-c.stop:
+		return false
+	}
+	return true
+}
+
+// abortedCh returns a channel that is closed once a saga is aborted
+func (c *Coordinator) abortedCh(sagaID string) <-chan struct{} {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	ch, ok := c.aborts[sagaID]
+	if !ok {
+		ch = make(chan struct{})
+		if saga, ok := c.sagas[sagaID]; !ok || saga.aborted.Load() {
+			close(ch)
+			return ch
+		}
+		c.aborts[sagaID] = ch
+	}
+	return ch
+}
+
+// wakeRetries ends the retry waits of an aborted saga. Must hold c.mtx
+func (c *Coordinator) wakeRetries(sagaID string) {
+	if ch, ok := c.aborts[sagaID]; ok {
+		close(ch)
+		delete(c.aborts, sagaID)
+	}
+}
+
+// recordAttempt logs a failed T or C that is about to be retried, so that its attempts
+// and last error survive a crash and show in the saga
+func (c *Coordinator) recordAttempt(ctx context.Context, sagaID string, vertex Vertex) {
+	lsn, err := c.appendLog(ctx, sagaID, VertexLog, encodeVertex(vertex))
+	if err != nil {
+		c.logger.Error("append vertex log failed", logKeySaga, sagaID, logKeyVertex, vertex.Id, logKeyError, err)
+		panic(err)
+	}
+	c.logger.Debug("attempt logged", logKeySaga, sagaID, logKeyVertex, vertex.Id, logKeyLsn, lsn)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if saga, ok := c.sagas[sagaID]; ok {
+		saga.Vertices.Set(vertex.Id, vertex)
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
 	}
+	return b
 }
@@ -0,0 +1,479 @@
+package sagas
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/triplewy/sagas/utils"
+
+	cmap "github.com/orcaman/concurrent-map"
+	"go.uber.org/atomic"
+)
+
+// Versions of the log record format. Payloads are encoded from the record structs
+// below rather than from proto structs, so that changing saga.proto does not change
+// what is written to disk. Adding a field whose zero value keeps the old behaviour
+// does not need a new version since missing fields decode as zero values. Any other
+// change needs a new version, an upgrade step in upgradeLog and golden files
+const (
+	// logVersion0 is the unversioned format which encoded proto structs directly
+	logVersion0 = 0
+	// logVersion1 encodes payloads from record structs
+	logVersion1 = 1
+	// logVersion2 compensates sagas in REVERSE_TOPOLOGICAL order by default, so
+	// version 1 sagas are upgraded to the TOPOLOGICAL order they were written with
+	logVersion2 = 2
+
+	// LogVersion is the version of logs written by this package
+	LogVersion = logVersion2
+)
+
+// ErrUnsupportedLogVersion is used when a log was written in a format this package cannot read
+var ErrUnsupportedLogVersion = errors.New("log record version is not supported")
+
+type funcRecord struct {
+	Url       string
+	Method    string
+	RequestId string
+	Body      map[string]string
+	Resp      map[string]string
+}
+
+type vertexRecord struct {
+	Id              string
+	T               *funcRecord
+	C               *funcRecord
+	TransferFields  []string
+	Status          int32
+	UncertainPolicy int32
+	Saga            *childRecord `codec:",omitempty"`
+	SagaID          string       `codec:",omitempty"`
+	Kind            int32        `codec:",omitempty"`
+	Attempts        uint32       `codec:",omitempty"`
+	LastError       string       `codec:",omitempty"`
+	ReadOnly        bool         `codec:",omitempty"`
+	CAttempts       uint32       `codec:",omitempty"`
+}
+
+// childRecord holds the definition of a vertex's child saga as its message
+// does, so that it reads back unchanged
+type childRecord struct {
+	Vertices          map[string]vertexRecord
+	Edges             []edgeRecord
+	RecoveryMode      int32 `codec:",omitempty"`
+	CompensationOrder int32 `codec:",omitempty"`
+}
+
+type edgeRecord struct {
+	StartId        string
+	EndId          string
+	TransferFields []string
+	Predicate      string `codec:",omitempty"`
+}
+
+type sagaRecord struct {
+	ID              string
+	Vertices        map[string]vertexRecord
+	DAG             map[string]map[string][]string
+	Template        string `codec:",omitempty"`
+	TemplateVersion uint64 `codec:",omitempty"`
+	Parent          string `codec:",omitempty"`
+	ParentVertex    string `codec:",omitempty"`
+	// Predicates of conditional edges, by parent and child id
+	Predicates   map[string]map[string]string `codec:",omitempty"`
+	Recovery     int32                        `codec:",omitempty"`
+	Compensation int32                        `codec:",omitempty"`
+}
+
+// templateRecord holds the definition in its json file format
+type templateRecord struct {
+	Name       string
+	Version    uint64
+	Definition []byte
+}
+
+func encodeSaga(saga Saga) []byte {
+	sr := sagaRecord{
+		ID:              saga.ID,
+		Vertices:        make(map[string]vertexRecord, saga.Vertices.Count()),
+		DAG:             saga.DAG,
+		Template:        saga.Template,
+		TemplateVersion: saga.TemplateVersion,
+		Parent:          saga.Parent,
+		ParentVertex:    saga.ParentVertex,
+		Predicates:      saga.Predicates,
+		Recovery:        int32(saga.Recovery),
+		Compensation:    int32(saga.Compensation),
+	}
+
+	saga.Vertices.IterCb(func(k string, v interface{}) {
+		sr.Vertices[k] = vertexToRecord(v.(Vertex))
+	})
+
+	buf, err := utils.EncodeMsgPack(sr)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeSaga(data []byte) Saga {
+	saga, err := unmarshalSaga(data)
+	if err != nil {
+		panic(err)
+	}
+	return saga
+}
+
+func unmarshalSaga(data []byte) (Saga, error) {
+	var sr sagaRecord
+
+	err := utils.DecodeMsgPack(data, &sr)
+	if err != nil {
+		return Saga{}, err
+	}
+
+	vtxs := cmap.New()
+
+	for key, value := range sr.Vertices {
+		vtxs.Set(key, recordToVertex(value))
+	}
+
+	return Saga{
+		ID:              sr.ID,
+		Vertices:        vtxs,
+		DAG:             sr.DAG,
+		Template:        sr.Template,
+		TemplateVersion: sr.TemplateVersion,
+		Parent:          sr.Parent,
+		ParentVertex:    sr.ParentVertex,
+		Predicates:      sr.Predicates,
+		Recovery:        RecoveryMode(sr.Recovery),
+		Compensation:    CompensationOrder(sr.Compensation),
+		dagMtx:          new(sync.RWMutex),
+		aborted:         atomic.NewBool(false),
+	}, nil
+}
+
+func encodeTemplate(t Template) []byte {
+	def, err := t.Definition.Marshal(DefinitionJSON)
+	if err != nil {
+		panic(err)
+	}
+	buf, err := utils.EncodeMsgPack(templateRecord{
+		Name:       t.Name,
+		Version:    t.Version,
+		Definition: def,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func unmarshalTemplate(data []byte) (Template, error) {
+	var tr templateRecord
+	if err := utils.DecodeMsgPack(data, &tr); err != nil {
+		return Template{}, err
+	}
+	def, err := ParseDefinition(tr.Definition, DefinitionJSON)
+	if err != nil {
+		return Template{}, err
+	}
+	return Template{
+		Name:       tr.Name,
+		Version:    tr.Version,
+		Definition: def,
+	}, nil
+}
+
+func encodeVertex(vertex Vertex) []byte {
+	buf, err := utils.EncodeMsgPack(vertexToRecord(vertex))
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func decodeVertex(data []byte) Vertex {
+	vertex, err := unmarshalVertex(data)
+	if err != nil {
+		panic(err)
+	}
+	return vertex
+}
+
+func unmarshalVertex(data []byte) (Vertex, error) {
+	var vr vertexRecord
+	if err := utils.DecodeMsgPack(data, &vr); err != nil {
+		return Vertex{}, err
+	}
+	return recordToVertex(vr), nil
+}
+
+func vertexToRecord(vertex Vertex) vertexRecord {
+	return vertexRecord{
+		Id:              vertex.Id,
+		T:               funcToRecord(vertex.T),
+		C:               funcToRecord(vertex.C),
+		TransferFields:  vertex.TransferFields,
+		Status:          int32(vertex.Status),
+		UncertainPolicy: int32(vertex.UncertainPolicy),
+		Saga:            childToRecord(vertex.Saga),
+		SagaID:          vertex.SagaId,
+		Kind:            int32(vertex.Kind),
+		Attempts:        vertex.Attempts,
+		LastError:       vertex.LastError,
+		ReadOnly:        vertex.ReadOnly,
+		CAttempts:       vertex.CAttempts,
+	}
+}
+
+func recordToVertex(vr vertexRecord) Vertex {
+	return Vertex{
+		Id:              vr.Id,
+		T:               recordToFunc(vr.T),
+		C:               recordToFunc(vr.C),
+		TransferFields:  vr.TransferFields,
+		Status:          Status(vr.Status),
+		UncertainPolicy: UncertainPolicy(vr.UncertainPolicy),
+		Saga:            recordToChild(vr.Saga),
+		SagaId:          vr.SagaID,
+		Kind:            VertexKind(vr.Kind),
+		Attempts:        vr.Attempts,
+		LastError:       vr.LastError,
+		ReadOnly:        vr.ReadOnly,
+		CAttempts:       vr.CAttempts,
+	}
+}
+
+func childToRecord(msg *SagaMsg) *childRecord {
+	if msg == nil {
+		return nil
+	}
+	cr := &childRecord{
+		Vertices:          make(map[string]vertexRecord, len(msg.Vertices)),
+		RecoveryMode:      int32(msg.RecoveryMode),
+		CompensationOrder: int32(msg.CompensationOrder),
+	}
+	for id, vtx := range msg.Vertices {
+		cr.Vertices[id] = vertexToRecord(*vtx)
+	}
+	for _, edge := range msg.Edges {
+		cr.Edges = append(cr.Edges, edgeRecord{
+			StartId:        edge.StartId,
+			EndId:          edge.EndId,
+			TransferFields: edge.TransferFields,
+			Predicate:      edge.Predicate,
+		})
+	}
+	return cr
+}
+
+func recordToChild(cr *childRecord) *SagaMsg {
+	if cr == nil {
+		return nil
+	}
+	msg := &SagaMsg{
+		Vertices:          make(map[string]*Vertex, len(cr.Vertices)),
+		RecoveryMode:      RecoveryMode(cr.RecoveryMode),
+		CompensationOrder: CompensationOrder(cr.CompensationOrder),
+	}
+	for id, vr := range cr.Vertices {
+		vtx := recordToVertex(vr)
+		msg.Vertices[id] = &vtx
+	}
+	for _, er := range cr.Edges {
+		msg.Edges = append(msg.Edges, &Edge{
+			StartId:        er.StartId,
+			EndId:          er.EndId,
+			TransferFields: er.TransferFields,
+			Predicate:      er.Predicate,
+		})
+	}
+	return msg
+}
+
+func funcToRecord(f *Func) *funcRecord {
+	if f == nil {
+		return nil
+	}
+	return &funcRecord{
+		Url:       f.Url,
+		Method:    f.Method,
+		RequestId: f.RequestId,
+		Body:      f.Body,
+		Resp:      f.Resp,
+	}
+}
+
+func recordToFunc(fr *funcRecord) *Func {
+	if fr == nil {
+		return nil
+	}
+	return &Func{
+		Url:       fr.Url,
+		Method:    fr.Method,
+		RequestId: fr.RequestId,
+		Body:      fr.Body,
+		Resp:      fr.Resp,
+	}
+}
+
+// upgradeLog converts a log of any supported version to the current version. Each
+// step upgrades by one version and falls through to the next
+func upgradeLog(log Log) (Log, error) {
+	switch log.Version {
+	case logVersion0:
+		data, err := upgradeDataV0(log.LogType, log.Data)
+		if err != nil {
+			return Log{}, err
+		}
+		log.Data = data
+		log.Version = logVersion1
+		fallthrough
+	case logVersion1:
+		data, err := upgradeDataV1(log.LogType, log.Data)
+		if err != nil {
+			return Log{}, err
+		}
+		log.Data = data
+		log.Version = logVersion2
+		fallthrough
+	case logVersion2:
+		return log, nil
+	default:
+		return Log{}, ErrUnsupportedLogVersion
+	}
+}
+
+// Version 0 structs are frozen copies of the proto structs as unversioned logs
+// encoded them. They must never change
+type funcV0 struct {
+	Url       string
+	Method    string
+	RequestId string
+	Body      map[string]string
+	Resp      map[string]string
+}
+
+type vertexV0 struct {
+	Id              string
+	T               *funcV0
+	C               *funcV0
+	TransferFields  []string
+	Status          int32
+	UncertainPolicy int32
+}
+
+type sagaV0 struct {
+	ID       string
+	Vertices map[string]vertexV0
+	DAG      map[string]map[string][]string
+}
+
+func (f *funcV0) record() *funcRecord {
+	if f == nil {
+		return nil
+	}
+	return &funcRecord{
+		Url:       f.Url,
+		Method:    f.Method,
+		RequestId: f.RequestId,
+		Body:      f.Body,
+		Resp:      f.Resp,
+	}
+}
+
+func (v vertexV0) record() vertexRecord {
+	return vertexRecord{
+		Id:              v.Id,
+		T:               v.T.record(),
+		C:               v.C.record(),
+		TransferFields:  v.TransferFields,
+		Status:          v.Status,
+		UncertainPolicy: v.UncertainPolicy,
+	}
+}
+
+// upgradeDataV0 re-encodes a version 0 payload in version 1
+func upgradeDataV0(logType LogType, data []byte) ([]byte, error) {
+	var out interface{}
+	switch logType {
+	case GraphLog:
+		var s sagaV0
+		if err := utils.DecodeMsgPack(data, &s); err != nil {
+			return nil, err
+		}
+		sr := sagaRecord{
+			ID:       s.ID,
+			Vertices: make(map[string]vertexRecord, len(s.Vertices)),
+			DAG:      s.DAG,
+		}
+		for id, v := range s.Vertices {
+			sr.Vertices[id] = v.record()
+		}
+		out = sr
+	case VertexLog:
+		var v vertexV0
+		if err := utils.DecodeMsgPack(data, &v); err != nil {
+			return nil, err
+		}
+		out = v.record()
+	default:
+		return data, nil
+	}
+
+	buf, err := utils.EncodeMsgPack(out)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// upgradeDataV1 re-encodes a version 1 payload in version 2
+func upgradeDataV1(logType LogType, data []byte) ([]byte, error) {
+	var out interface{}
+	switch logType {
+	case GraphLog:
+		var sr sagaRecord
+		if err := utils.DecodeMsgPack(data, &sr); err != nil {
+			return nil, err
+		}
+		sr.Compensation = int32(CompensationOrder_TOPOLOGICAL)
+		for id, vr := range sr.Vertices {
+			sr.Vertices[id] = vr.topologicalV1()
+		}
+		out = sr
+	case VertexLog:
+		var vr vertexRecord
+		if err := utils.DecodeMsgPack(data, &vr); err != nil {
+			return nil, err
+		}
+		out = vr.topologicalV1()
+	default:
+		return data, nil
+	}
+
+	buf, err := utils.EncodeMsgPack(out)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// topologicalV1 sets the child saga of a version 1 vertex and its descendants to
+// compensate in TOPOLOGICAL order
+func (vr vertexRecord) topologicalV1() vertexRecord {
+	if vr.Saga == nil {
+		return vr
+	}
+	cr := *vr.Saga
+	cr.CompensationOrder = int32(CompensationOrder_TOPOLOGICAL)
+	cr.Vertices = make(map[string]vertexRecord, len(vr.Saga.Vertices))
+	for id, child := range vr.Saga.Vertices {
+		cr.Vertices[id] = child.topologicalV1()
+	}
+	vr.Saga = &cr
+	return vr
+}
@@ -1,40 +1,140 @@
 package sagas
 
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Errors encountered while recovering sagas from logs
+var (
+	ErrUnknownLogSaga = errors.New("log of vertex has sagaID that does not exist")
+	ErrUnknownLogType = errors.New("unrecognized log type")
+	ErrSagaNotLogged  = errors.New("saga has no graph log at or before lsn")
+)
+
 // Recover reads logs from disks and reconstructs dags in memory
-func Recover(logs LogStore) map[string]Saga {
+func Recover(logs LogStore) (map[string]Saga, error) {
 	sagas := make(map[string]Saga, 0)
 
 	// Repopulate all sagas into memory
-	for i := uint64(1); i <= logs.LastIndex(); i++ {
-		log, err := logs.GetLog(i)
-		if err != nil {
-			// Error must be ErrLogIndexNotFound so we just skip the index
-			continue
-		}
-		switch log.LogType {
-		case InitLog:
-			continue
-		case GraphLog:
-			if _, ok := sagas[log.SagaID]; ok {
-				panic("multiple graphs with same sagaID")
-			}
-			saga := decodeSaga(log.Data)
-			sagas[log.SagaID] = saga
-		case VertexLog:
-			saga, ok := sagas[log.SagaID]
-			if !ok {
-				panic("log of vertex has sagaID that does not exist")
-			}
-			vertex := decodeVertex(log.Data)
-			if _, ok := saga.getVtx(vertex.Id); !ok {
-				panic(ErrIDNotFound)
-			}
-			saga.Vertices.Set(vertex.Id, vertex)
-		default:
-			panic("unrecognized log type")
+	it := logs.Scan(1, math.MaxUint64)
+	defer it.Close()
+
+	for it.Next() {
+		if err := applyLog(sagas, it.Log()); err != nil {
+			return nil, err
 		}
 	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
 
 	// Add all sagas into coordinator
-	return sagas
+	return sagas, nil
+}
+
+// Snapshot is the state of a saga as the coordinator saw it after applying a log
+type Snapshot struct {
+	Saga Saga
+	// Lsn of the last applied log
+	Lsn uint64
+	// NextLsn is the lsn of the saga's next log, 0 if there is none
+	NextLsn uint64
+	// Scheduled are the vertices the coordinator would start next, sorted by id
+	Scheduled []Vertex
+	Finished  bool
+	Aborted   bool
+}
+
+// SagaAt rebuilds a saga as of lsn by replaying its logs up to and including lsn. An
+// lsn of 0 replays all logs. The saga's first log is always replayed, so stepping
+// through a saga can start at lsn 1
+func SagaAt(logs LogStore, sagaID string, lsn uint64) (Snapshot, error) {
+	if lsn == 0 {
+		lsn = math.MaxUint64
+	}
+	sagas := make(map[string]Saga, 1)
+	snapshot := Snapshot{}
+	var last LogType
+
+	it := logs.ScanSaga(sagaID)
+	defer it.Close()
+
+	for it.Next() {
+		log := it.Log()
+		if log.Lsn > lsn && snapshot.Lsn != 0 {
+			snapshot.NextLsn = log.Lsn
+			break
+		}
+		if err := applyLog(sagas, log); err != nil {
+			return Snapshot{}, err
+		}
+		snapshot.Lsn = log.Lsn
+		last = log.LogType
+	}
+	if err := it.Err(); err != nil {
+		return Snapshot{}, err
+	}
+
+	saga, ok := sagas[sagaID]
+	if !ok {
+		return Snapshot{}, ErrSagaNotLogged
+	}
+	snapshot.Saga = saga
+	snapshot.Finished, snapshot.Aborted = CheckFinishedOrAbort(saga)
+	// A graph log starts or recovers the saga. After any other log, vertices in
+	// START_T or START_C are in flight
+	if last == GraphLog {
+		snapshot.Scheduled = createSchedule(saga)
+	} else {
+		snapshot.Scheduled = updateSchedule(saga, "")
+	}
+	sort.Slice(snapshot.Scheduled, func(i, j int) bool {
+		return snapshot.Scheduled[i].Id < snapshot.Scheduled[j].Id
+	})
+
+	return snapshot, nil
+}
+
+// applyLog replays a single log onto sagas
+func applyLog(sagas map[string]Saga, log Log) error {
+	switch log.LogType {
+	case InitLog:
+	case GraphLog:
+		// A saga's graph is logged again every time it is recovered, so a
+		// later graph log replaces the earlier one. Graphs do not record aborts
+		saga, err := log.Saga()
+		if err != nil {
+			return err
+		}
+		if prev, ok := sagas[log.SagaID]; ok && prev.aborted.Load() {
+			saga.aborted.Store(true)
+		}
+		sagas[log.SagaID] = saga
+	case VertexLog:
+		saga, ok := sagas[log.SagaID]
+		if !ok {
+			return ErrUnknownLogSaga
+		}
+		vertex, err := log.Vertex()
+		if err != nil {
+			return err
+		}
+		if _, ok := saga.getVtx(vertex.Id); !ok {
+			return ErrIDNotFound
+		}
+		saga.Vertices.Set(vertex.Id, vertex)
+	case AbortLog:
+		saga, ok := sagas[log.SagaID]
+		if !ok {
+			return ErrUnknownLogSaga
+		}
+		saga.aborted.Store(true)
+	case TemplateLog:
+		// Templates are recovered by RecoverTemplates
+	default:
+		return ErrUnknownLogType
+	}
+	return nil
 }
@@ -0,0 +1,300 @@
+package sagas
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Fill colours of vertices in DOT output by status
+var dotColours = map[Status]string{
+	Status_NOT_REACHED: "#bbbbbb",
+	Status_START_T:     "#7fb3e6",
+	Status_END_T:       "#5cb85c",
+	Status_START_C:     "#f0ad4e",
+	Status_END_C:       "#9b7fd4",
+	Status_ABORT:       "#d9534f",
+	Status_SKIPPED:     "#eeeeee",
+}
+
+// renderGraph is the part of a saga that renderers draw. Ids and edges are sorted
+type renderGraph struct {
+	id     string
+	ids    []string
+	status map[string]Status
+	edges  []*Edge
+}
+
+func sagaGraph(saga Saga) renderGraph {
+	g := renderGraph{id: saga.ID, status: make(map[string]Status, len(saga.DAG))}
+
+	for tuple := range saga.Vertices.IterBuffered() {
+		g.ids = append(g.ids, tuple.Key)
+		g.status[tuple.Key] = tuple.Val.(Vertex).Status
+	}
+
+	saga.dagMtx.RLock()
+	for from, children := range saga.DAG {
+		for to, fields := range children {
+			g.edges = append(g.edges, &Edge{StartId: from, EndId: to, TransferFields: fields, Predicate: saga.Predicates[from][to]})
+		}
+	}
+	saga.dagMtx.RUnlock()
+
+	g.sort()
+	return g
+}
+
+func msgGraph(msg *SagaMsg) renderGraph {
+	g := renderGraph{id: msg.GetId(), status: make(map[string]Status, len(msg.GetVertices()))}
+
+	for id, vtx := range msg.GetVertices() {
+		g.ids = append(g.ids, id)
+		g.status[id] = vtx.GetStatus()
+	}
+	g.edges = append(g.edges, msg.GetEdges()...)
+
+	g.sort()
+	return g
+}
+
+func (g *renderGraph) sort() {
+	sort.Strings(g.ids)
+	sort.Slice(g.edges, func(i, j int) bool {
+		if g.edges[i].StartId != g.edges[j].StartId {
+			return g.edges[i].StartId < g.edges[j].StartId
+		}
+		return g.edges[i].EndId < g.edges[j].EndId
+	})
+}
+
+// levels groups vertex ids by the length of the longest path to them from a source
+func (g renderGraph) levels() [][]string {
+	level := make(map[string]int, len(g.ids))
+	// A DAG's longest path has fewer edges than it has vertices
+	for i := 0; i < len(g.ids); i++ {
+		for _, edge := range g.edges {
+			if l := level[edge.StartId] + 1; l > level[edge.EndId] {
+				level[edge.EndId] = l
+			}
+		}
+	}
+
+	var levels [][]string
+	for _, id := range g.ids {
+		l := level[id]
+		for len(levels) <= l {
+			levels = append(levels, nil)
+		}
+		levels[l] = append(levels[l], id)
+	}
+	return levels
+}
+
+// DOT renders saga as a Graphviz digraph. Vertices are filled by status and
+// edges are labelled with their transfer fields and predicates
+func (s Saga) DOT() string {
+	return sagaGraph(s).dot()
+}
+
+// DOT renders a saga message as a Graphviz digraph
+func (m *SagaMsg) DOT() string {
+	return msgGraph(m).dot()
+}
+
+// ASCII renders saga in the grid layout of graphs.txt, with one row per level
+// and cells holding vertex ids and statuses. A vertex has an edge to each vertex
+// of the next row at most one column away, so edges are only listed if they carry
+// transfer fields or predicates. Sagas that cannot be drawn this way are laid out
+// one level per row with all of their edges listed
+func (s Saga) ASCII() string {
+	return sagaGraph(s).ascii()
+}
+
+// ASCII renders a saga message like Saga.ASCII
+func (m *SagaMsg) ASCII() string {
+	return msgGraph(m).ascii()
+}
+
+func (g renderGraph) dot() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "digraph %v {\n", strconv.Quote("saga "+g.id))
+	b.WriteString("\tnode [shape=box, style=\"rounded,filled\"];\n")
+	for _, id := range g.ids {
+		status := g.status[id]
+		fmt.Fprintf(&b, "\t%v [label=%v, fillcolor=%v];\n", strconv.Quote(id), strconv.Quote(id+"\n"+status.String()), strconv.Quote(dotColours[status]))
+	}
+	for _, edge := range g.edges {
+		fmt.Fprintf(&b, "\t%v -> %v", strconv.Quote(edge.StartId), strconv.Quote(edge.EndId))
+		var label []string
+		if len(edge.TransferFields) > 0 {
+			label = append(label, strings.Join(edge.TransferFields, ", "))
+		}
+		if edge.Predicate != "" {
+			label = append(label, "if "+edge.Predicate)
+		}
+		if len(label) > 0 {
+			fmt.Fprintf(&b, " [label=%v]", strconv.Quote(strings.Join(label, "\n")))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// maxGridSteps bounds the search for a grid layout, which is exponential in the
+// number of vertices per level
+const maxGridSteps = 100000
+
+func (g renderGraph) ascii() string {
+	levels := g.levels()
+	rows := g.grid(levels)
+	implied := rows != nil
+	if !implied {
+		rows = centered(levels)
+	}
+
+	cell := 0
+	for _, id := range g.ids {
+		if n := len(g.cell(id)); n > cell {
+			cell = n
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %v\n", g.id)
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, id := range row {
+			if id == "" {
+				cells[i] = strings.Repeat(" ", cell)
+			} else {
+				cells[i] = fmt.Sprintf("%-*v", cell, g.cell(id))
+			}
+		}
+		fmt.Fprintf(&b, "|%v|\n", strings.Join(cells, "|"))
+	}
+	for _, edge := range g.edges {
+		if implied && len(edge.TransferFields) == 0 && edge.Predicate == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%v -> %v", edge.StartId, edge.EndId)
+		if len(edge.TransferFields) > 0 {
+			fmt.Fprintf(&b, " %v", edge.TransferFields)
+		}
+		if edge.Predicate != "" {
+			fmt.Fprintf(&b, " if %v", edge.Predicate)
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// grid places each level in a row so that, like in graphs.txt, vertices of a row
+// are at least two columns apart and a vertex is at most one column away from
+// exactly its children in the next row. Returns nil if there is no such layout
+func (g renderGraph) grid(levels [][]string) [][]string {
+	level := make(map[string]int, len(g.ids))
+	for l, ids := range levels {
+		for _, id := range ids {
+			level[id] = l
+		}
+	}
+	edges := make(map[[2]string]bool, len(g.edges))
+	for _, edge := range g.edges {
+		if level[edge.EndId] != level[edge.StartId]+1 {
+			return nil
+		}
+		edges[[2]string{edge.StartId, edge.EndId}] = true
+	}
+
+	width := 0
+	for _, ids := range levels {
+		if len(ids) > width {
+			width = len(ids)
+		}
+	}
+
+	steps := 0
+	for columns := 2*width - 1; columns <= 2*width+1; columns++ {
+		rows := make([][]string, len(levels))
+		for l := range rows {
+			rows[l] = make([]string, columns)
+		}
+		col := make(map[string]int, len(g.ids))
+
+		var place func(l, i int) bool
+		place = func(l, i int) bool {
+			if l == len(levels) {
+				return true
+			}
+			if i == len(levels[l]) {
+				return place(l+1, 0)
+			}
+			if steps++; steps > maxGridSteps {
+				return false
+			}
+			id := levels[l][i]
+		next:
+			for c := 0; c < columns; c++ {
+				for _, d := range []int{c - 1, c, c + 1} {
+					if d >= 0 && d < columns && rows[l][d] != "" {
+						continue next
+					}
+				}
+				if l > 0 {
+					for _, parent := range levels[l-1] {
+						if near := abs(col[parent]-c) <= 1; near != edges[[2]string{parent, id}] {
+							continue next
+						}
+					}
+				}
+				rows[l][c], col[id] = id, c
+				if place(l, i+1) {
+					return true
+				}
+				rows[l][c] = ""
+			}
+			return false
+		}
+		if place(0, 0) {
+			return rows
+		}
+	}
+	return nil
+}
+
+// centered lays out each level centered in a row, with a blank cell between vertices
+func centered(levels [][]string) [][]string {
+	width := 0
+	for _, ids := range levels {
+		if len(ids) > width {
+			width = len(ids)
+		}
+	}
+
+	rows := make([][]string, len(levels))
+	for l, ids := range levels {
+		rows[l] = make([]string, 2*width-1)
+		offset := width - len(ids)
+		for i, id := range ids {
+			rows[l][offset+2*i] = id
+		}
+	}
+	return rows
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func (g renderGraph) cell(id string) string {
+	return id + ":" + g.status[id].String()
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"sync"
 
-	"github.com/triplewy/sagas/utils"
-
 	"github.com/google/go-cmp/cmp"
 	cmap "github.com/orcaman/concurrent-map"
 	"go.uber.org/atomic"
@@ -32,11 +30,41 @@ type Saga struct {
 	// since most of time we will only be reading from it
 	DAG    map[string]map[string][]string
 	dagMtx *sync.RWMutex
+	// Predicates of conditional edges, by parent and child id. Guarded by dagMtx
+	Predicates map[string]map[string]string
+
+	// Template and version the saga was started from, if any. A saga keeps the
+	// version it started with when newer versions are registered
+	Template        string
+	TemplateVersion uint64
+
+	// Saga and vertex that started this saga as a child, if any
+	Parent       string
+	ParentVertex string
+
+	// Recovery is how the saga recovers from a failed T
+	Recovery RecoveryMode
+	// Compensation is the order an aborted saga compensates its vertices in
+	Compensation CompensationOrder
 
 	// atomic boolean that signifies if saga has already been marked as aborted
 	aborted *atomic.Bool
 }
 
+// isAborted returns whether saga was aborted by an operator or has an aborted vertex
+func (s Saga) isAborted() bool {
+	if s.aborted.Load() {
+		return true
+	}
+	for tuple := range s.Vertices.IterBuffered() {
+		vtx := tuple.Val.(Vertex)
+		if vtx.Status == Status_ABORT {
+			return true
+		}
+	}
+	return false
+}
+
 // NewSaga creates a new saga and initializes concurrent data structures
 // NOTE: THIS DOES NOT INSTANTIATE **ID** FIELD
 func NewSaga(vertices map[string]Vertex, dag map[string]map[string][]string) Saga {
@@ -63,7 +91,13 @@ func (s Saga) Equal(t Saga) bool {
 	if s.ID != t.ID {
 		return false
 	}
-	if !cmp.Equal(s.DAG, t.DAG) {
+	if s.Template != t.Template || s.TemplateVersion != t.TemplateVersion {
+		return false
+	}
+	if s.Parent != t.Parent || s.ParentVertex != t.ParentVertex || s.Recovery != t.Recovery || s.Compensation != t.Compensation {
+		return false
+	}
+	if !cmp.Equal(s.DAG, t.DAG) || !cmp.Equal(s.Predicates, t.Predicates) {
 		return false
 	}
 	if !cmp.Equal(s.Vertices.Items(), t.Vertices.Items()) {
@@ -141,13 +175,16 @@ func CheckEquivalentDAGs(a, b map[string]map[string]struct{}) error {
 }
 
 // CheckFinishedOrAbort checks if saga has finished. If no abort in the saga,
-// then all vertices must have status Status_END_T to be finished.
-// If abort in the saga, then all vertices except aborted and not-reached vertexes
-// must have status Status_END_C to be finished.
+// then all vertices must have status Status_END_T or Status_SKIPPED to be finished.
+// If abort in the saga, then all vertices except aborted, skipped and not-reached
+// vertexes must have status Status_END_C to be finished. An aborted saga in forward
+// recovery is not compensated, so it is finished once no vertex is in flight.
 func CheckFinishedOrAbort(saga Saga) (finished, aborted bool) {
-	aborted = false
+	// A saga aborted by an operator may not have an aborted vertex
+	aborted = saga.aborted.Load()
 	finished = true
 	finishedC := true
+	inFlight := false
 
 	saga.Vertices.IterCb(func(key string, value interface{}) {
 		v := value.(Vertex)
@@ -157,19 +194,25 @@ func CheckFinishedOrAbort(saga Saga) (finished, aborted bool) {
 		if v.Status == Status_ABORT {
 			aborted = true
 		}
-		// If vertex is not Status_END_T, then impossible for saga to be finished forward
-		if v.Status != Status_END_T {
+		// If vertex is not Status_END_T nor Status_SKIPPED, then impossible for saga to be finished forward
+		if v.Status != Status_END_T && v.Status != Status_SKIPPED {
 			finished = false
 		}
-		// If status is not abort, notReached, nor  Status_END_C, then impossible for saga to be finished compensating
-		if !(v.Status == Status_ABORT || v.Status == Status_END_C || v.Status == Status_NOT_REACHED) {
+		// If status is not abort, notReached, skipped nor Status_END_C, then impossible for saga to be finished compensating
+		if !(v.Status == Status_ABORT || v.Status == Status_END_C || v.Status == Status_NOT_REACHED || v.Status == Status_SKIPPED) {
 			finishedC = false
 		}
+		if v.Status == Status_START_T || v.Status == Status_START_C {
+			inFlight = true
+		}
 	})
 
 	// If saga aborted, we set finished status to if saga finished compensating
 	if aborted {
 		finished = finishedC
+		if saga.Recovery == RecoveryMode_FORWARD {
+			finished = !inFlight
+		}
 	}
 
 	return
@@ -180,27 +223,18 @@ func CheckValidSaga(saga Saga) error {
 	saga.dagMtx.RLock()
 	defer saga.dagMtx.RUnlock()
 
-	// Get aborted from vertices rather than from saga for now
-	aborted := func(saga Saga) bool {
-		for tuple := range saga.Vertices.IterBuffered() {
-			vtx := tuple.Val.(Vertex)
-			if vtx.Status == Status_ABORT {
-				return true
-			}
-		}
-		return false
-	}(saga)
+	aborted := saga.isAborted()
 
 	// If not aborted, saga is valid iff:
-	// 1. Each vertex is either Status_NOT_REACHED, Status_START_T, Status_END_T
-	// 2. Parent is not Status_END_T, then child should be Status_NOT_REACHED
+	// 1. Each vertex is either Status_NOT_REACHED, Status_START_T, Status_END_T, Status_SKIPPED
+	// 2. Parent is not Status_END_T nor Status_SKIPPED, then child should be Status_NOT_REACHED
 	if !aborted {
 		// For each vertex in the saga...
 		for tuple := range saga.Vertices.IterBuffered() {
 			parentID := tuple.Key
 			parent := tuple.Val.(Vertex)
 			// #1
-			if !(parent.Status == Status_NOT_REACHED || parent.Status == Status_START_T || parent.Status == Status_END_T) {
+			if !(parent.Status == Status_NOT_REACHED || parent.Status == Status_START_T || parent.Status == Status_END_T || parent.Status == Status_SKIPPED) {
 				return ErrInvalidSaga
 			}
 			children, ok := saga.DAG[parentID]
@@ -213,7 +247,7 @@ func CheckValidSaga(saga Saga) error {
 					return ErrIDNotFound
 				}
 				// #2
-				if parent.Status != Status_END_T && child.Status != Status_NOT_REACHED {
+				if !parent.done() && child.Status != Status_NOT_REACHED {
 					return ErrInvalidSaga
 				}
 			}
@@ -243,21 +277,14 @@ func CheckValidSaga(saga Saga) error {
 	return nil
 }
 
-// SagaBFS finds set of all vertex ids to start processing using BFS
+// SagaBFS finds set of all vertex ids to start processing using BFS.
+// Vertices in START_T or START_C are included even though they may already be
+// in flight, since after a crash nothing else will resume them
 func SagaBFS(saga Saga) []Vertex {
 	saga.dagMtx.RLock()
 	defer saga.dagMtx.RUnlock()
 
-	// Get aborted from vertices rather than from saga for now
-	aborted := func(saga Saga) bool {
-		for tuple := range saga.Vertices.IterBuffered() {
-			vtx := tuple.Val.(Vertex)
-			if vtx.Status == Status_ABORT {
-				return true
-			}
-		}
-		return false
-	}(saga)
+	aborted := saga.isAborted()
 
 	// Get source nodes of graph
 	sources := findSourceVertices(saga.DAG)
@@ -267,6 +294,7 @@ func SagaBFS(saga Saga) []Vertex {
 
 	// If not aborted, find top most nodes with NOT_REACHED or START_T
 	if !aborted {
+		parents := findParents(saga.DAG)
 		// Use bfs to find the nodes with NOT_REACHED or START_T to add to process
 		for len(sources) > 0 {
 			vtxID, sources = sources[0], sources[1:]
@@ -274,20 +302,39 @@ func SagaBFS(saga Saga) []Vertex {
 			if !ok {
 				panic(ErrIDNotFound)
 			}
+			// A vertex is only reached once all of its parents are done, since
+			// CheckValidSaga rejects a started child of a parent still in flight.
+			// It is left to be skipped if none of its incoming edges are taken
+			if vtx.Status == Status_NOT_REACHED && (!saga.done(parents[vtxID]) || saga.skippable(vtxID, parents[vtxID])) {
+				continue
+			}
 			// If node has NOT_REACHED or START_T, add to process, and stop traveling down current path
-			if vtx.Status == Status_NOT_REACHED {
+			if vtx.Status == Status_NOT_REACHED || vtx.Status == Status_START_T {
 				process[vtxID] = vtx
 				continue
 			}
-			// Node must have END_T status, add children to queue
-			if vtx.Status == Status_END_T {
+			// Node must have END_T or SKIPPED status, add children to queue
+			if vtx.Status == Status_END_T || vtx.Status == Status_SKIPPED {
 				for child := range saga.DAG[vtxID] {
 					sources = append(sources, child)
 				}
 			}
 		}
-	} else {
-		// If aborted, find top most nodes with END_T or START_C to add to process
+	} else if saga.Recovery == RecoveryMode_BACKWARD && saga.Compensation == CompensationOrder_REVERSE_TOPOLOGICAL {
+		// If aborted, find nodes with END_T, START_T or START_C none of whose descendants
+		// have one, so a vertex is compensated after every vertex that may depend on it.
+		// Sagas in forward recovery are never compensated
+		for vtxID := range saga.DAG {
+			vtx, ok := saga.getVtx(vtxID)
+			if !ok {
+				panic(ErrIDNotFound)
+			}
+			if vtx.uncompensated() && !saga.anyUncompensated(reachable(vtxID, saga.DAG)) {
+				process[vtxID] = vtx
+			}
+		}
+	} else if saga.Recovery == RecoveryMode_BACKWARD {
+		// If aborted in topological order, find top most nodes with END_T, START_T or START_C to add to process
 		for len(sources) > 0 {
 			vtxID, sources = sources[0], sources[1:]
 			vtx, ok := saga.getVtx(vtxID)
@@ -298,13 +345,15 @@ func SagaBFS(saga Saga) []Vertex {
 			if vtx.Status == Status_NOT_REACHED || vtx.Status == Status_ABORT {
 				continue
 			}
-			// If END_T add to process and stop travelling down current path
-			if vtx.Status == Status_END_T {
+			// If END_T, START_T or START_C add to process and stop travelling down current path.
+			// A START_T vertex may have committed its T, so it must be resolved before the saga can finish
+			if vtx.Status == Status_END_T || vtx.Status == Status_START_T || vtx.Status == Status_START_C {
 				process[vtxID] = vtx
 				continue
 			}
-			// Node must have END_C status, add children to queue
-			if vtx.Status == Status_END_C {
+			// Node must have END_C or SKIPPED status, add children to queue. Children
+			// of a skipped vertex may have run through another parent
+			if vtx.Status == Status_END_C || vtx.Status == Status_SKIPPED {
 				for child := range saga.DAG[vtxID] {
 					sources = append(sources, child)
 				}
@@ -320,89 +369,158 @@ func SagaBFS(saga Saga) []Vertex {
 	return res
 }
 
-// findSourceVertices finds set of all vertex ids who have no parents.
-// dagMtx MUST BE RLOCKED before calling function
-func findSourceVertices(dag map[string]map[string][]string) (ids []string) {
-	// Build set of vertices that are children
-	childNodes := make(map[string]struct{}, 0)
-	for _, children := range dag {
-		for id := range children {
-			childNodes[id] = struct{}{}
-		}
-	}
-
-	// For all vertices, the ones not in childNodes are source nodes
-	for id := range dag {
-		if _, ok := childNodes[id]; !ok {
-			ids = append(ids, id)
-		}
-	}
+// done returns whether a vertex has finished going forward
+func (v Vertex) done() bool {
+	return v.Status == Status_END_T || v.Status == Status_SKIPPED
+}
 
-	return
+// uncompensated returns whether a vertex's T may have committed without being compensated yet
+func (v Vertex) uncompensated() bool {
+	return v.Status == Status_END_T || v.Status == Status_START_T || v.Status == Status_START_C
 }
 
-type sagaPack struct {
-	ID       string
-	Vertices map[string]Vertex
-	DAG      map[string]map[string][]string
-	aborted  bool
+// copy returns v with its own T and C, so that writing their maps does not
+// change v
+func (v Vertex) copy() Vertex {
+	v.T, v.C = v.T.copy(), v.C.copy()
+	return v
 }
 
-func encodeSaga(saga Saga) []byte {
-	sp := sagaPack{
-		ID:       saga.ID,
-		Vertices: make(map[string]Vertex, saga.Vertices.Count()),
-		DAG:      saga.DAG,
-		aborted:  saga.aborted.Load(),
+// copy returns a copy of f with its own maps. proto.Clone is not used since it
+// turns empty maps into nil maps
+func (f *Func) copy() *Func {
+	if f == nil {
+		return nil
+	}
+	return &Func{
+		Url:       f.Url,
+		Method:    f.Method,
+		RequestId: f.RequestId,
+		Body:      copyMap(f.Body),
+		Resp:      copyMap(f.Resp),
 	}
+}
 
-	saga.Vertices.IterCb(func(k string, v interface{}) {
-		sp.Vertices[k] = v.(Vertex)
-	})
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
 
-	buf, err := utils.EncodeMsgPack(sp)
-	if err != nil {
-		panic(err)
+// anyUncompensated returns whether any vertex in ids is uncompensated
+func (s Saga) anyUncompensated(ids map[string]bool) bool {
+	for id := range ids {
+		vtx, ok := s.getVtx(id)
+		if !ok {
+			panic(ErrIDNotFound)
+		}
+		if vtx.uncompensated() {
+			return true
+		}
 	}
-	return buf.Bytes()
+	return false
 }
 
-func decodeSaga(data []byte) Saga {
-	var sp sagaPack
+// done returns whether every vertex in ids has status END_T or SKIPPED
+func (s Saga) done(ids []string) bool {
+	for _, id := range ids {
+		vtx, ok := s.getVtx(id)
+		if !ok {
+			panic(ErrIDNotFound)
+		}
+		if !vtx.done() {
+			return false
+		}
+	}
+	return true
+}
 
-	err := utils.DecodeMsgPack(data, &sp)
-	if err != nil {
-		panic(err)
+// skippable returns whether a vertex whose parents are done has no incoming edge
+// taken. An edge is taken if its parent committed and its predicate holds.
+// dagMtx MUST BE RLOCKED before calling function
+func (s Saga) skippable(id string, parents []string) bool {
+	if len(parents) == 0 {
+		return false
 	}
+	for _, parentID := range parents {
+		parent, ok := s.getVtx(parentID)
+		if !ok {
+			panic(ErrIDNotFound)
+		}
+		if parent.Status == Status_END_T && evalPredicate(s.Predicates[parentID][id], parent.T.GetResp()) {
+			return false
+		}
+	}
+	return true
+}
 
-	vtxs := cmap.New()
+// skipVertices sets every vertex of a saga going forward that has no incoming edge
+// taken to SKIPPED. Skipped vertices are returned parents first
+func skipVertices(saga Saga) []Vertex {
+	saga.dagMtx.RLock()
+	defer saga.dagMtx.RUnlock()
 
-	for key, value := range sp.Vertices {
-		vtxs.Set(key, value)
+	parents := findParents(saga.DAG)
+	var skipped []Vertex
+	// A vertex not reached is visited again by each parent, but children are queued once
+	expanded := make(map[string]bool, len(saga.DAG))
+	queue := findSourceVertices(saga.DAG)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		vtx, ok := saga.getVtx(id)
+		if !ok {
+			panic(ErrIDNotFound)
+		}
+		if vtx.Status == Status_NOT_REACHED && saga.done(parents[id]) && saga.skippable(id, parents[id]) {
+			vtx.Status = Status_SKIPPED
+			saga.Vertices.Set(id, vtx)
+			skipped = append(skipped, vtx)
+		}
+		if vtx.done() && !expanded[id] {
+			expanded[id] = true
+			for child := range saga.DAG[id] {
+				queue = append(queue, child)
+			}
+		}
 	}
+	return skipped
+}
 
-	return Saga{
-		ID:       sp.ID,
-		Vertices: vtxs,
-		DAG:      sp.DAG,
-		dagMtx:   new(sync.RWMutex),
-		aborted:  atomic.NewBool(sp.aborted),
+// findParents maps each vertex id to the ids of its parents.
+// dagMtx MUST BE RLOCKED before calling function
+func findParents(dag map[string]map[string][]string) map[string][]string {
+	parents := make(map[string][]string, len(dag))
+	for id, children := range dag {
+		for child := range children {
+			parents[child] = append(parents[child], id)
+		}
 	}
+	return parents
 }
 
-func encodeVertex(vertex Vertex) []byte {
-	buf, err := utils.EncodeMsgPack(vertex)
-	if err != nil {
-		panic(err)
+// findSourceVertices finds set of all vertex ids who have no parents.
+// dagMtx MUST BE RLOCKED before calling function
+func findSourceVertices(dag map[string]map[string][]string) (ids []string) {
+	// Build set of vertices that are children
+	childNodes := make(map[string]struct{}, 0)
+	for _, children := range dag {
+		for id := range children {
+			childNodes[id] = struct{}{}
+		}
 	}
-	return buf.Bytes()
-}
 
-func decodeVertex(data []byte) Vertex {
-	var vertex Vertex
-	err := utils.DecodeMsgPack(data, &vertex)
-	if err != nil {
-		panic(err)
+	// For all vertices, the ones not in childNodes are source nodes
+	for id := range dag {
+		if _, ok := childNodes[id]; !ok {
+			ids = append(ids, id)
+		}
 	}
-	return vertex
+
+	return
 }
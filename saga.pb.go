@@ -33,6 +33,8 @@ const (
 	Status_START_C     Status = 3
 	Status_END_C       Status = 4
 	Status_ABORT       Status = 5
+	// Every incoming edge's predicate was false, so the vertex is not run
+	Status_SKIPPED Status = 6
 )
 
 var Status_name = map[int32]string{
@@ -42,6 +44,7 @@ var Status_name = map[int32]string{
 	3: "START_C",
 	4: "END_C",
 	5: "ABORT",
+	6: "SKIPPED",
 }
 
 var Status_value = map[string]int32{
@@ -51,6 +54,7 @@ var Status_value = map[string]int32{
 	"START_C":     3,
 	"END_C":       4,
 	"ABORT":       5,
+	"SKIPPED":     6,
 }
 
 func (x Status) String() string {
@@ -61,13 +65,145 @@ func (Status) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_9818be635ac82bc9, []int{0}
 }
 
+// How to resolve a vertex found in START_T after a crash once the saga aborts
+type UncertainPolicy int32
+
+const (
+	// Re-issue T with the same request id to learn its outcome
+	UncertainPolicy_REISSUE_T UncertainPolicy = 0
+	// Compensate without learning T's outcome. Participant's C must be idempotent
+	// and accept a T that never happened
+	UncertainPolicy_BLIND_C UncertainPolicy = 1
+)
+
+var UncertainPolicy_name = map[int32]string{
+	0: "REISSUE_T",
+	1: "BLIND_C",
+}
+
+var UncertainPolicy_value = map[string]int32{
+	"REISSUE_T": 0,
+	"BLIND_C":   1,
+}
+
+func (x UncertainPolicy) String() string {
+	return proto.EnumName(UncertainPolicy_name, int32(x))
+}
+
+func (UncertainPolicy) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{1}
+}
+
+// How a saga recovers from a failed t
+type RecoveryMode int32
+
+const (
+	// Abort and compensate committed vertices
+	RecoveryMode_BACKWARD RecoveryMode = 0
+	// Retry t with backoff until it commits. Never compensates
+	RecoveryMode_FORWARD RecoveryMode = 1
+)
+
+var RecoveryMode_name = map[int32]string{
+	0: "BACKWARD",
+	1: "FORWARD",
+}
+
+var RecoveryMode_value = map[string]int32{
+	"BACKWARD": 0,
+	"FORWARD":  1,
+}
+
+func (x RecoveryMode) String() string {
+	return proto.EnumName(RecoveryMode_name, int32(x))
+}
+
+func (RecoveryMode) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{2}
+}
+
+// Order an aborted saga compensates its vertices in
+type CompensationOrder int32
+
+const (
+	// Compensate a vertex once every descendant that committed is compensated
+	CompensationOrder_REVERSE_TOPOLOGICAL CompensationOrder = 0
+	// Compensate the topmost committed vertices first, without waiting on descendants
+	CompensationOrder_TOPOLOGICAL CompensationOrder = 1
+)
+
+var CompensationOrder_name = map[int32]string{
+	0: "REVERSE_TOPOLOGICAL",
+	1: "TOPOLOGICAL",
+}
+
+var CompensationOrder_value = map[string]int32{
+	"REVERSE_TOPOLOGICAL": 0,
+	"TOPOLOGICAL":         1,
+}
+
+func (x CompensationOrder) String() string {
+	return proto.EnumName(CompensationOrder_name, int32(x))
+}
+
+func (CompensationOrder) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{3}
+}
+
+// Role of a vertex in the saga's pivot structure
+type VertexKind int32
+
+const (
+	// Undone by c if the saga aborts
+	VertexKind_COMPENSATABLE VertexKind = 0
+	// Go or no-go point of the saga. Once it commits the saga cannot abort
+	VertexKind_PIVOT VertexKind = 1
+	// Runs after the pivot. A failed t is retried until it commits
+	VertexKind_RETRIABLE VertexKind = 2
+)
+
+var VertexKind_name = map[int32]string{
+	0: "COMPENSATABLE",
+	1: "PIVOT",
+	2: "RETRIABLE",
+}
+
+var VertexKind_value = map[string]int32{
+	"COMPENSATABLE": 0,
+	"PIVOT":         1,
+	"RETRIABLE":     2,
+}
+
+func (x VertexKind) String() string {
+	return proto.EnumName(VertexKind_name, int32(x))
+}
+
+func (VertexKind) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{4}
+}
+
 type Vertex struct {
 	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	T  *Func  `protobuf:"bytes,2,opt,name=t,proto3" json:"t,omitempty"`
 	C  *Func  `protobuf:"bytes,3,opt,name=c,proto3" json:"c,omitempty"`
 	// Transfer fields from Func t resp to Func c body
-	TransferFields       []string `protobuf:"bytes,4,rep,name=transfer_fields,json=transferFields,proto3" json:"transfer_fields,omitempty"`
-	Status               Status   `protobuf:"varint,5,opt,name=status,proto3,enum=sagas.Status" json:"status,omitempty"`
+	TransferFields  []string        `protobuf:"bytes,4,rep,name=transfer_fields,json=transferFields,proto3" json:"transfer_fields,omitempty"`
+	Status          Status          `protobuf:"varint,5,opt,name=status,proto3,enum=sagas.Status" json:"status,omitempty"`
+	UncertainPolicy UncertainPolicy `protobuf:"varint,6,opt,name=uncertain_policy,json=uncertainPolicy,proto3,enum=sagas.UncertainPolicy" json:"uncertain_policy,omitempty"`
+	// Child saga run in place of t and c. The vertex commits when the child
+	// commits and is compensated by compensating the child
+	Saga *SagaMsg `protobuf:"bytes,7,opt,name=saga,proto3" json:"saga,omitempty"`
+	// Id of the child saga once started
+	SagaId string     `protobuf:"bytes,8,opt,name=saga_id,json=sagaId,proto3" json:"saga_id,omitempty"`
+	Kind   VertexKind `protobuf:"varint,9,opt,name=kind,proto3,enum=sagas.VertexKind" json:"kind,omitempty"`
+	// Number of times t was issued and the error of its last failed attempt
+	Attempts  uint32 `protobuf:"varint,10,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	LastError string `protobuf:"bytes,11,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	// A read-only vertex changes nothing, so it may omit c. A c that is omitted
+	// or has method NOOP is never called, and the vertex is compensated at once
+	ReadOnly bool `protobuf:"varint,12,opt,name=read_only,json=readOnly,proto3" json:"read_only,omitempty"`
+	// Number of times c was issued
+	CAttempts            uint32   `protobuf:"varint,13,opt,name=c_attempts,json=cAttempts,proto3" json:"c_attempts,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -133,6 +269,62 @@ func (m *Vertex) GetStatus() Status {
 	return Status_NOT_REACHED
 }
 
+func (m *Vertex) GetUncertainPolicy() UncertainPolicy {
+	if m != nil {
+		return m.UncertainPolicy
+	}
+	return UncertainPolicy_REISSUE_T
+}
+
+func (m *Vertex) GetSaga() *SagaMsg {
+	if m != nil {
+		return m.Saga
+	}
+	return nil
+}
+
+func (m *Vertex) GetSagaId() string {
+	if m != nil {
+		return m.SagaId
+	}
+	return ""
+}
+
+func (m *Vertex) GetKind() VertexKind {
+	if m != nil {
+		return m.Kind
+	}
+	return VertexKind_COMPENSATABLE
+}
+
+func (m *Vertex) GetAttempts() uint32 {
+	if m != nil {
+		return m.Attempts
+	}
+	return 0
+}
+
+func (m *Vertex) GetLastError() string {
+	if m != nil {
+		return m.LastError
+	}
+	return ""
+}
+
+func (m *Vertex) GetReadOnly() bool {
+	if m != nil {
+		return m.ReadOnly
+	}
+	return false
+}
+
+func (m *Vertex) GetCAttempts() uint32 {
+	if m != nil {
+		return m.CAttempts
+	}
+	return 0
+}
+
 type Func struct {
 	Url                  string            `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
 	Method               string            `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
@@ -208,7 +400,9 @@ type Edge struct {
 	StartId string `protobuf:"bytes,1,opt,name=start_id,json=startId,proto3" json:"start_id,omitempty"`
 	EndId   string `protobuf:"bytes,2,opt,name=end_id,json=endId,proto3" json:"end_id,omitempty"`
 	// Transfer fields from start node resp to end node body
-	TransferFields       []string `protobuf:"bytes,3,rep,name=transfer_fields,json=transferFields,proto3" json:"transfer_fields,omitempty"`
+	TransferFields []string `protobuf:"bytes,3,rep,name=transfer_fields,json=transferFields,proto3" json:"transfer_fields,omitempty"`
+	// Condition on start node resp for the edge to be taken. Empty is always taken
+	Predicate            string   `protobuf:"bytes,4,opt,name=predicate,proto3" json:"predicate,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -260,13 +454,28 @@ func (m *Edge) GetTransferFields() []string {
 	return nil
 }
 
+func (m *Edge) GetPredicate() string {
+	if m != nil {
+		return m.Predicate
+	}
+	return ""
+}
+
 type SagaMsg struct {
-	Id                   string             `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Vertices             map[string]*Vertex `protobuf:"bytes,2,rep,name=vertices,proto3" json:"vertices,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	Edges                []*Edge            `protobuf:"bytes,3,rep,name=edges,proto3" json:"edges,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
-	XXX_unrecognized     []byte             `json:"-"`
-	XXX_sizecache        int32              `json:"-"`
+	Id       string             `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Vertices map[string]*Vertex `protobuf:"bytes,2,rep,name=vertices,proto3" json:"vertices,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Edges    []*Edge            `protobuf:"bytes,3,rep,name=edges,proto3" json:"edges,omitempty"`
+	// Template and version the saga was started from, if any
+	Template        string `protobuf:"bytes,4,opt,name=template,proto3" json:"template,omitempty"`
+	TemplateVersion uint64 `protobuf:"varint,5,opt,name=template_version,json=templateVersion,proto3" json:"template_version,omitempty"`
+	// Saga and vertex that started this saga as a child, if any
+	ParentId             string            `protobuf:"bytes,6,opt,name=parent_id,json=parentId,proto3" json:"parent_id,omitempty"`
+	ParentVertex         string            `protobuf:"bytes,7,opt,name=parent_vertex,json=parentVertex,proto3" json:"parent_vertex,omitempty"`
+	RecoveryMode         RecoveryMode      `protobuf:"varint,8,opt,name=recovery_mode,json=recoveryMode,proto3,enum=sagas.RecoveryMode" json:"recovery_mode,omitempty"`
+	CompensationOrder    CompensationOrder `protobuf:"varint,9,opt,name=compensation_order,json=compensationOrder,proto3,enum=sagas.CompensationOrder" json:"compensation_order,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *SagaMsg) Reset()         { *m = SagaMsg{} }
@@ -315,8 +524,367 @@ func (m *SagaMsg) GetEdges() []*Edge {
 	return nil
 }
 
+func (m *SagaMsg) GetTemplate() string {
+	if m != nil {
+		return m.Template
+	}
+	return ""
+}
+
+func (m *SagaMsg) GetTemplateVersion() uint64 {
+	if m != nil {
+		return m.TemplateVersion
+	}
+	return 0
+}
+
+func (m *SagaMsg) GetParentId() string {
+	if m != nil {
+		return m.ParentId
+	}
+	return ""
+}
+
+func (m *SagaMsg) GetParentVertex() string {
+	if m != nil {
+		return m.ParentVertex
+	}
+	return ""
+}
+
+func (m *SagaMsg) GetRecoveryMode() RecoveryMode {
+	if m != nil {
+		return m.RecoveryMode
+	}
+	return RecoveryMode_BACKWARD
+}
+
+func (m *SagaMsg) GetCompensationOrder() CompensationOrder {
+	if m != nil {
+		return m.CompensationOrder
+	}
+	return CompensationOrder_REVERSE_TOPOLOGICAL
+}
+
+type SagaAtMsg struct {
+	SagaId string `protobuf:"bytes,1,opt,name=saga_id,json=sagaId,proto3" json:"saga_id,omitempty"`
+	// Replay logs up to and including lsn. 0 replays all logs
+	Lsn                  uint64   `protobuf:"varint,2,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SagaAtMsg) Reset()         { *m = SagaAtMsg{} }
+func (m *SagaAtMsg) String() string { return proto.CompactTextString(m) }
+func (*SagaAtMsg) ProtoMessage()    {}
+func (*SagaAtMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{4}
+}
+
+func (m *SagaAtMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SagaAtMsg.Unmarshal(m, b)
+}
+func (m *SagaAtMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SagaAtMsg.Marshal(b, m, deterministic)
+}
+func (m *SagaAtMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SagaAtMsg.Merge(m, src)
+}
+func (m *SagaAtMsg) XXX_Size() int {
+	return xxx_messageInfo_SagaAtMsg.Size(m)
+}
+func (m *SagaAtMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_SagaAtMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SagaAtMsg proto.InternalMessageInfo
+
+func (m *SagaAtMsg) GetSagaId() string {
+	if m != nil {
+		return m.SagaId
+	}
+	return ""
+}
+
+func (m *SagaAtMsg) GetLsn() uint64 {
+	if m != nil {
+		return m.Lsn
+	}
+	return 0
+}
+
+type SnapshotMsg struct {
+	Saga *SagaMsg `protobuf:"bytes,1,opt,name=saga,proto3" json:"saga,omitempty"`
+	// Lsn of the last replayed log
+	Lsn uint64 `protobuf:"varint,2,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	// Lsn of the saga's next log, 0 if there is none
+	NextLsn uint64 `protobuf:"varint,3,opt,name=next_lsn,json=nextLsn,proto3" json:"next_lsn,omitempty"`
+	// Ids of vertices SagaBFS would schedule next
+	Scheduled            []string `protobuf:"bytes,4,rep,name=scheduled,proto3" json:"scheduled,omitempty"`
+	Finished             bool     `protobuf:"varint,5,opt,name=finished,proto3" json:"finished,omitempty"`
+	Aborted              bool     `protobuf:"varint,6,opt,name=aborted,proto3" json:"aborted,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SnapshotMsg) Reset()         { *m = SnapshotMsg{} }
+func (m *SnapshotMsg) String() string { return proto.CompactTextString(m) }
+func (*SnapshotMsg) ProtoMessage()    {}
+func (*SnapshotMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{5}
+}
+
+func (m *SnapshotMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SnapshotMsg.Unmarshal(m, b)
+}
+func (m *SnapshotMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SnapshotMsg.Marshal(b, m, deterministic)
+}
+func (m *SnapshotMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SnapshotMsg.Merge(m, src)
+}
+func (m *SnapshotMsg) XXX_Size() int {
+	return xxx_messageInfo_SnapshotMsg.Size(m)
+}
+func (m *SnapshotMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_SnapshotMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SnapshotMsg proto.InternalMessageInfo
+
+func (m *SnapshotMsg) GetSaga() *SagaMsg {
+	if m != nil {
+		return m.Saga
+	}
+	return nil
+}
+
+func (m *SnapshotMsg) GetLsn() uint64 {
+	if m != nil {
+		return m.Lsn
+	}
+	return 0
+}
+
+func (m *SnapshotMsg) GetNextLsn() uint64 {
+	if m != nil {
+		return m.NextLsn
+	}
+	return 0
+}
+
+func (m *SnapshotMsg) GetScheduled() []string {
+	if m != nil {
+		return m.Scheduled
+	}
+	return nil
+}
+
+func (m *SnapshotMsg) GetFinished() bool {
+	if m != nil {
+		return m.Finished
+	}
+	return false
+}
+
+func (m *SnapshotMsg) GetAborted() bool {
+	if m != nil {
+		return m.Aborted
+	}
+	return false
+}
+
+type TemplateMsg struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Assigned by the coordinator on registration
+	Version uint64 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	// Saga definition encoded as json
+	Definition           string   `protobuf:"bytes,3,opt,name=definition,proto3" json:"definition,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TemplateMsg) Reset()         { *m = TemplateMsg{} }
+func (m *TemplateMsg) String() string { return proto.CompactTextString(m) }
+func (*TemplateMsg) ProtoMessage()    {}
+func (*TemplateMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{6}
+}
+
+func (m *TemplateMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TemplateMsg.Unmarshal(m, b)
+}
+func (m *TemplateMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TemplateMsg.Marshal(b, m, deterministic)
+}
+func (m *TemplateMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TemplateMsg.Merge(m, src)
+}
+func (m *TemplateMsg) XXX_Size() int {
+	return xxx_messageInfo_TemplateMsg.Size(m)
+}
+func (m *TemplateMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_TemplateMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TemplateMsg proto.InternalMessageInfo
+
+func (m *TemplateMsg) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *TemplateMsg) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *TemplateMsg) GetDefinition() string {
+	if m != nil {
+		return m.Definition
+	}
+	return ""
+}
+
+type ListTemplatesMsg struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListTemplatesMsg) Reset()         { *m = ListTemplatesMsg{} }
+func (m *ListTemplatesMsg) String() string { return proto.CompactTextString(m) }
+func (*ListTemplatesMsg) ProtoMessage()    {}
+func (*ListTemplatesMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{7}
+}
+
+func (m *ListTemplatesMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListTemplatesMsg.Unmarshal(m, b)
+}
+func (m *ListTemplatesMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListTemplatesMsg.Marshal(b, m, deterministic)
+}
+func (m *ListTemplatesMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListTemplatesMsg.Merge(m, src)
+}
+func (m *ListTemplatesMsg) XXX_Size() int {
+	return xxx_messageInfo_ListTemplatesMsg.Size(m)
+}
+func (m *ListTemplatesMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListTemplatesMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListTemplatesMsg proto.InternalMessageInfo
+
+type TemplatesMsg struct {
+	Templates            []*TemplateMsg `protobuf:"bytes,1,rep,name=templates,proto3" json:"templates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *TemplatesMsg) Reset()         { *m = TemplatesMsg{} }
+func (m *TemplatesMsg) String() string { return proto.CompactTextString(m) }
+func (*TemplatesMsg) ProtoMessage()    {}
+func (*TemplatesMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{8}
+}
+
+func (m *TemplatesMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TemplatesMsg.Unmarshal(m, b)
+}
+func (m *TemplatesMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TemplatesMsg.Marshal(b, m, deterministic)
+}
+func (m *TemplatesMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TemplatesMsg.Merge(m, src)
+}
+func (m *TemplatesMsg) XXX_Size() int {
+	return xxx_messageInfo_TemplatesMsg.Size(m)
+}
+func (m *TemplatesMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_TemplatesMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TemplatesMsg proto.InternalMessageInfo
+
+func (m *TemplatesMsg) GetTemplates() []*TemplateMsg {
+	if m != nil {
+		return m.Templates
+	}
+	return nil
+}
+
+type StartTemplateMsg struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Version to start. 0 starts the latest version
+	Version              uint64            `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	Params               map[string]string `protobuf:"bytes,3,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *StartTemplateMsg) Reset()         { *m = StartTemplateMsg{} }
+func (m *StartTemplateMsg) String() string { return proto.CompactTextString(m) }
+func (*StartTemplateMsg) ProtoMessage()    {}
+func (*StartTemplateMsg) Descriptor() ([]byte, []int) {
+	return fileDescriptor_9818be635ac82bc9, []int{9}
+}
+
+func (m *StartTemplateMsg) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StartTemplateMsg.Unmarshal(m, b)
+}
+func (m *StartTemplateMsg) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StartTemplateMsg.Marshal(b, m, deterministic)
+}
+func (m *StartTemplateMsg) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StartTemplateMsg.Merge(m, src)
+}
+func (m *StartTemplateMsg) XXX_Size() int {
+	return xxx_messageInfo_StartTemplateMsg.Size(m)
+}
+func (m *StartTemplateMsg) XXX_DiscardUnknown() {
+	xxx_messageInfo_StartTemplateMsg.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StartTemplateMsg proto.InternalMessageInfo
+
+func (m *StartTemplateMsg) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *StartTemplateMsg) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *StartTemplateMsg) GetParams() map[string]string {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("sagas.Status", Status_name, Status_value)
+	proto.RegisterEnum("sagas.UncertainPolicy", UncertainPolicy_name, UncertainPolicy_value)
+	proto.RegisterEnum("sagas.RecoveryMode", RecoveryMode_name, RecoveryMode_value)
+	proto.RegisterEnum("sagas.CompensationOrder", CompensationOrder_name, CompensationOrder_value)
+	proto.RegisterEnum("sagas.VertexKind", VertexKind_name, VertexKind_value)
 	proto.RegisterType((*Vertex)(nil), "sagas.Vertex")
 	proto.RegisterType((*Func)(nil), "sagas.Func")
 	proto.RegisterMapType((map[string]string)(nil), "sagas.Func.BodyEntry")
@@ -324,44 +892,93 @@ func init() {
 	proto.RegisterType((*Edge)(nil), "sagas.Edge")
 	proto.RegisterType((*SagaMsg)(nil), "sagas.SagaMsg")
 	proto.RegisterMapType((map[string]*Vertex)(nil), "sagas.SagaMsg.VerticesEntry")
+	proto.RegisterType((*SagaAtMsg)(nil), "sagas.SagaAtMsg")
+	proto.RegisterType((*SnapshotMsg)(nil), "sagas.SnapshotMsg")
+	proto.RegisterType((*TemplateMsg)(nil), "sagas.TemplateMsg")
+	proto.RegisterType((*ListTemplatesMsg)(nil), "sagas.ListTemplatesMsg")
+	proto.RegisterType((*TemplatesMsg)(nil), "sagas.TemplatesMsg")
+	proto.RegisterType((*StartTemplateMsg)(nil), "sagas.StartTemplateMsg")
+	proto.RegisterMapType((map[string]string)(nil), "sagas.StartTemplateMsg.ParamsEntry")
 }
 
 func init() { proto.RegisterFile("saga.proto", fileDescriptor_9818be635ac82bc9) }
 
 var fileDescriptor_9818be635ac82bc9 = []byte{
-	// 497 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x53, 0x41, 0x6f, 0xd3, 0x30,
-	0x14, 0xc6, 0x69, 0x93, 0x2e, 0x2f, 0xac, 0x8b, 0x2c, 0x86, 0xb2, 0x0a, 0xa4, 0x52, 0x84, 0x28,
-	0x1c, 0x72, 0x28, 0x07, 0x26, 0x24, 0x0e, 0x5d, 0x96, 0x89, 0x22, 0xb1, 0x21, 0x37, 0xda, 0x35,
-	0xf2, 0x6a, 0x2f, 0x44, 0x2b, 0x49, 0xb1, 0x9d, 0x89, 0xfc, 0x12, 0x6e, 0xfc, 0x18, 0x7e, 0x19,
-	0xb2, 0x93, 0x76, 0x1d, 0xeb, 0x85, 0x9b, 0xdf, 0xfb, 0xbe, 0xcf, 0xf9, 0xfc, 0xbd, 0x17, 0x00,
-	0x49, 0x33, 0x1a, 0xae, 0x44, 0xa9, 0x4a, 0x6c, 0xeb, 0xb3, 0x1c, 0xfd, 0x46, 0xe0, 0x5c, 0x72,
-	0xa1, 0xf8, 0x4f, 0xdc, 0x07, 0x2b, 0x67, 0x01, 0x1a, 0xa2, 0xb1, 0x4b, 0xac, 0x9c, 0xe1, 0x23,
-	0x40, 0x2a, 0xb0, 0x86, 0x68, 0xec, 0x4d, 0xbc, 0xd0, 0xb0, 0xc3, 0xb3, 0xaa, 0x58, 0x10, 0xa4,
-	0x34, 0xb4, 0x08, 0x3a, 0x3b, 0xa0, 0x05, 0x7e, 0x0d, 0x07, 0x4a, 0xd0, 0x42, 0x5e, 0x73, 0x91,
-	0x5e, 0xe7, 0x7c, 0xc9, 0x64, 0xd0, 0x1d, 0x76, 0xc6, 0x2e, 0xe9, 0xaf, 0xdb, 0x67, 0xa6, 0x8b,
-	0x5f, 0x81, 0x23, 0x15, 0x55, 0x95, 0x0c, 0xec, 0x21, 0x1a, 0xf7, 0x27, 0xfb, 0xed, 0x45, 0x73,
-	0xd3, 0x24, 0x2d, 0x38, 0xfa, 0x65, 0x41, 0x57, 0xdf, 0x8d, 0x7d, 0xe8, 0x54, 0x62, 0xd9, 0xfa,
-	0xd3, 0x47, 0xfc, 0x14, 0x9c, 0xef, 0x5c, 0x7d, 0x2b, 0x99, 0x71, 0xe9, 0x92, 0xb6, 0xc2, 0xcf,
-	0x01, 0x04, 0xff, 0x51, 0x71, 0xa9, 0xd2, 0x9c, 0x19, 0x9b, 0x2e, 0x71, 0xdb, 0xce, 0x8c, 0xe1,
-	0x37, 0xd0, 0xbd, 0x2a, 0x59, 0x6d, 0x6c, 0x79, 0x93, 0xc3, 0x2d, 0xff, 0xe1, 0x49, 0xc9, 0xea,
-	0xb8, 0x50, 0xa2, 0x26, 0x86, 0xa2, 0xa9, 0x82, 0xcb, 0x55, 0x60, 0x3f, 0xa4, 0x12, 0x2e, 0x57,
-	0x2d, 0x55, 0x53, 0x06, 0xef, 0xc1, 0xdd, 0xa8, 0xb5, 0xd7, 0x1b, 0x5e, 0xaf, 0xbd, 0xde, 0xf0,
-	0x1a, 0x3f, 0x01, 0xfb, 0x96, 0x2e, 0x2b, 0xde, 0x5a, 0x6d, 0x8a, 0x0f, 0xd6, 0x31, 0xd2, 0xc2,
-	0xcd, 0x5d, 0xff, 0x23, 0x1c, 0x51, 0xe8, 0xc6, 0x2c, 0xe3, 0xf8, 0x08, 0xf6, 0xa4, 0xa2, 0xc2,
-	0x3c, 0xb6, 0x11, 0xf6, 0x4c, 0x3d, 0x63, 0xf8, 0x10, 0x1c, 0x5e, 0x30, 0x0d, 0xb4, 0x6a, 0x5e,
-	0xb0, 0x19, 0xdb, 0x35, 0xa3, 0xce, 0xae, 0x19, 0x8d, 0xfe, 0x20, 0xe8, 0xcd, 0x69, 0x46, 0xbf,
-	0xc8, 0xec, 0xc1, 0x7a, 0x1c, 0xc3, 0xde, 0x2d, 0x17, 0x2a, 0x5f, 0x70, 0x19, 0x58, 0x26, 0x9f,
-	0x67, 0xeb, 0x09, 0x36, 0x8a, 0xf0, 0xb2, 0x85, 0x9b, 0x98, 0x36, 0x6c, 0xfc, 0x02, 0x6c, 0xce,
-	0x32, 0xde, 0x7c, 0xf4, 0x6e, 0x83, 0xf4, 0x63, 0x48, 0x83, 0x0c, 0x3e, 0xc3, 0xfe, 0x3d, 0xf5,
-	0x8e, 0x60, 0x5e, 0x6e, 0x07, 0xe3, 0x6d, 0xd6, 0xa7, 0x59, 0xe6, 0xad, 0x9c, 0xde, 0x26, 0xe0,
-	0x34, 0x3b, 0x85, 0x0f, 0xc0, 0x3b, 0xbf, 0x48, 0x52, 0x12, 0x4f, 0xa3, 0x4f, 0xf1, 0xa9, 0xff,
-	0x08, 0x7b, 0xd0, 0x9b, 0x27, 0x53, 0x92, 0xa4, 0x89, 0x8f, 0xb0, 0x0b, 0x76, 0x7c, 0x7e, 0x9a,
-	0x26, 0xbe, 0x75, 0xd7, 0x8f, 0xfc, 0xce, 0xba, 0x1f, 0xf9, 0x5d, 0x7d, 0x9c, 0x9e, 0x5c, 0x90,
-	0xc4, 0xb7, 0x27, 0x1f, 0xc1, 0x8b, 0xca, 0x52, 0xb0, 0xbc, 0xa0, 0xaa, 0x14, 0x38, 0x84, 0xc7,
-	0x73, 0x1d, 0xba, 0x7e, 0x3b, 0xf9, 0x1a, 0xe1, 0xfe, 0xfd, 0x2c, 0x06, 0xff, 0xd4, 0x57, 0x8e,
-	0xf9, 0x0b, 0xdf, 0xfd, 0x0d, 0x00, 0x00, 0xff, 0xff, 0x04, 0xec, 0xef, 0xd8, 0x93, 0x03, 0x00,
-	0x00,
+	// 1174 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0xdd, 0x6e, 0xe2, 0x46,
+	0x14, 0x5e, 0xf3, 0xef, 0x03, 0x24, 0xce, 0xa4, 0xbb, 0xf1, 0xd2, 0x6d, 0x45, 0x89, 0x56, 0x25,
+	0x91, 0x8a, 0xda, 0x54, 0x6a, 0xb3, 0x5d, 0xad, 0xb4, 0x84, 0x38, 0x5b, 0x1a, 0x12, 0xd0, 0xc0,
+	0xa6, 0x17, 0xbd, 0xb0, 0x1c, 0xcf, 0x84, 0x58, 0x01, 0x9b, 0xce, 0x0c, 0xd1, 0x72, 0xd5, 0xc7,
+	0xe8, 0x5b, 0xf4, 0xa2, 0x57, 0xbd, 0xe8, 0x33, 0xf4, 0x99, 0xaa, 0x19, 0x8f, 0xc1, 0x21, 0xa8,
+	0xd2, 0xf6, 0x8a, 0x39, 0xdf, 0xf9, 0x99, 0x33, 0x73, 0xbe, 0x6f, 0x0c, 0x00, 0xf7, 0xc6, 0x5e,
+	0x6b, 0xc6, 0x22, 0x11, 0xa1, 0xbc, 0x5c, 0xf3, 0xc6, 0xdf, 0x59, 0x28, 0x5c, 0x51, 0x26, 0xe8,
+	0x07, 0xb4, 0x05, 0x99, 0x80, 0xd8, 0x46, 0xdd, 0x68, 0x9a, 0x38, 0x13, 0x10, 0xf4, 0x1c, 0x0c,
+	0x61, 0x67, 0xea, 0x46, 0xb3, 0x7c, 0x54, 0x6e, 0xa9, 0xe8, 0xd6, 0xd9, 0x3c, 0xf4, 0xb1, 0x21,
+	0xa4, 0xcb, 0xb7, 0xb3, 0x1b, 0x5c, 0x3e, 0xfa, 0x12, 0xb6, 0x05, 0xf3, 0x42, 0x7e, 0x43, 0x99,
+	0x7b, 0x13, 0xd0, 0x09, 0xe1, 0x76, 0xae, 0x9e, 0x6d, 0x9a, 0x78, 0x2b, 0x81, 0xcf, 0x14, 0x8a,
+	0x5e, 0x42, 0x81, 0x0b, 0x4f, 0xcc, 0xb9, 0x9d, 0xaf, 0x1b, 0xcd, 0xad, 0xa3, 0xaa, 0x2e, 0x34,
+	0x54, 0x20, 0xd6, 0x4e, 0xd4, 0x06, 0x6b, 0x1e, 0xfa, 0x94, 0x09, 0x2f, 0x08, 0xdd, 0x59, 0x34,
+	0x09, 0xfc, 0x85, 0x5d, 0x50, 0x09, 0xcf, 0x74, 0xc2, 0xfb, 0xc4, 0x3d, 0x50, 0x5e, 0xbc, 0x3d,
+	0x7f, 0x08, 0xa0, 0x06, 0xe4, 0x64, 0xa4, 0x5d, 0x54, 0x0d, 0x6f, 0x25, 0xfb, 0x78, 0x63, 0xef,
+	0x82, 0x8f, 0xb1, 0xf2, 0xa1, 0x3d, 0x28, 0xca, 0x5f, 0x37, 0x20, 0x76, 0x49, 0xdd, 0x40, 0x41,
+	0x9a, 0x5d, 0x82, 0x5e, 0x42, 0xee, 0x2e, 0x08, 0x89, 0x6d, 0xaa, 0x3d, 0x77, 0x74, 0x72, 0x7c,
+	0x65, 0xe7, 0x41, 0x48, 0xb0, 0x72, 0xa3, 0x1a, 0x94, 0x3c, 0x21, 0xe8, 0x74, 0x26, 0xb8, 0x0d,
+	0x75, 0xa3, 0x59, 0xc5, 0x4b, 0x1b, 0x7d, 0x06, 0x30, 0xf1, 0xb8, 0x70, 0x29, 0x63, 0x11, 0xb3,
+	0xcb, 0xaa, 0xbc, 0x29, 0x11, 0x47, 0x02, 0xe8, 0x53, 0x30, 0x19, 0xf5, 0x88, 0x1b, 0x85, 0x93,
+	0x85, 0x5d, 0xa9, 0x1b, 0xcd, 0x12, 0x2e, 0x49, 0xa0, 0x1f, 0x4e, 0x16, 0x32, 0xd7, 0x77, 0x97,
+	0x95, 0xab, 0xaa, 0xb2, 0xe9, 0xb7, 0x35, 0xd0, 0xf8, 0x3d, 0x03, 0x39, 0x79, 0xf3, 0xc8, 0x82,
+	0xec, 0x9c, 0x4d, 0xf4, 0xf4, 0xe4, 0x12, 0x3d, 0x83, 0xc2, 0x94, 0x8a, 0xdb, 0x88, 0xa8, 0x19,
+	0x9a, 0x58, 0x5b, 0xb2, 0x22, 0xa3, 0xbf, 0xce, 0x29, 0x17, 0xf2, 0xb0, 0xd9, 0xb8, 0x1b, 0x8d,
+	0x74, 0x09, 0x3a, 0x80, 0xdc, 0x75, 0x44, 0x16, 0x6a, 0x68, 0xe5, 0xa3, 0xa7, 0xa9, 0xe9, 0xb6,
+	0x4e, 0x22, 0xb2, 0x70, 0x42, 0xc1, 0x16, 0x58, 0x85, 0xc8, 0x50, 0x46, 0xf9, 0xcc, 0xce, 0x3f,
+	0x0e, 0xc5, 0x94, 0xcf, 0x74, 0xa8, 0x0c, 0xa9, 0x7d, 0x0f, 0xe6, 0x32, 0x5b, 0xf6, 0x7a, 0x47,
+	0x17, 0x49, 0xaf, 0x77, 0x74, 0x81, 0x3e, 0x81, 0xfc, 0xbd, 0x37, 0x99, 0x53, 0xdd, 0x6a, 0x6c,
+	0xfc, 0x90, 0x39, 0x36, 0x64, 0xe2, 0xb2, 0xd6, 0xc7, 0x24, 0x36, 0x7e, 0x83, 0x9c, 0x43, 0xc6,
+	0x14, 0x3d, 0x87, 0x12, 0x17, 0x1e, 0x53, 0x87, 0x8d, 0x13, 0x8b, 0xca, 0xee, 0x12, 0xf4, 0x14,
+	0x0a, 0x34, 0x24, 0xd2, 0xa1, 0xb3, 0x69, 0x48, 0xba, 0x64, 0x13, 0x83, 0xb3, 0x1b, 0x19, 0xfc,
+	0x02, 0xcc, 0x19, 0xa3, 0x24, 0xf0, 0x3d, 0x41, 0xed, 0x5c, 0x7c, 0x91, 0x4b, 0xa0, 0xf1, 0x4f,
+	0x16, 0x8a, 0x9a, 0x63, 0x8f, 0xa4, 0x75, 0x0c, 0xa5, 0x7b, 0xca, 0x44, 0xe0, 0x53, 0x6e, 0x67,
+	0xd4, 0xed, 0xbd, 0x78, 0xc8, 0xca, 0xd6, 0x95, 0x76, 0xc7, 0x97, 0xb8, 0x8c, 0x46, 0x5f, 0x40,
+	0x9e, 0x92, 0x31, 0x8d, 0x5b, 0x5a, 0xa9, 0x4f, 0x1e, 0x15, 0xc7, 0x1e, 0x49, 0x45, 0xc9, 0x8e,
+	0xc9, 0xaa, 0xab, 0xa5, 0x8d, 0x0e, 0xc0, 0x4a, 0xd6, 0xee, 0x3d, 0x65, 0x3c, 0x88, 0x42, 0x25,
+	0xbf, 0x1c, 0xde, 0x4e, 0xf0, 0xab, 0x18, 0x96, 0xb4, 0x9c, 0x79, 0x8c, 0x86, 0xea, 0xe6, 0x0a,
+	0x71, 0x9d, 0x18, 0xe8, 0x12, 0xb4, 0x0f, 0x55, 0xed, 0xbc, 0x57, 0x4a, 0x50, 0xda, 0x32, 0x71,
+	0x25, 0x06, 0xf5, 0x83, 0x72, 0x0c, 0x55, 0x46, 0xfd, 0xe8, 0x9e, 0xb2, 0x85, 0x3b, 0x8d, 0x08,
+	0x55, 0xca, 0xda, 0x3a, 0xda, 0xd5, 0x3d, 0x63, 0xed, 0xbb, 0x88, 0x08, 0xc5, 0x15, 0x96, 0xb2,
+	0xd0, 0x3b, 0x40, 0x7e, 0x34, 0x9d, 0xd1, 0x90, 0x7b, 0x22, 0x88, 0x42, 0x37, 0x62, 0x84, 0x32,
+	0x2d, 0x41, 0x5b, 0xa7, 0x77, 0x52, 0x01, 0x7d, 0xe9, 0xc7, 0x3b, 0xfe, 0x3a, 0x54, 0xfb, 0x09,
+	0xaa, 0x0f, 0x6e, 0x72, 0x03, 0x85, 0xf6, 0xd3, 0x14, 0x2a, 0x2f, 0x9f, 0xa1, 0xf8, 0x0c, 0x69,
+	0x46, 0x7d, 0x07, 0xa6, 0x9c, 0x4e, 0x5b, 0xc8, 0x89, 0xa6, 0xde, 0x0b, 0xe3, 0xc1, 0x7b, 0x61,
+	0x41, 0x76, 0xc2, 0x43, 0x55, 0x2c, 0x87, 0xe5, 0xb2, 0xf1, 0xa7, 0x01, 0xe5, 0x61, 0xe8, 0xcd,
+	0xf8, 0x6d, 0xa4, 0x52, 0x93, 0xe7, 0xc8, 0xf8, 0x8f, 0xe7, 0xe8, 0x51, 0x15, 0xc9, 0xe3, 0x90,
+	0x7e, 0x10, 0xae, 0x84, 0xb3, 0x0a, 0x2e, 0x4a, 0xbb, 0xc7, 0x43, 0xc9, 0x43, 0xee, 0xdf, 0x52,
+	0x32, 0x9f, 0x50, 0xa2, 0x1f, 0xdb, 0x15, 0x20, 0xe9, 0x70, 0x13, 0x84, 0x01, 0xbf, 0xa5, 0x44,
+	0x8d, 0xba, 0x84, 0x97, 0x36, 0xb2, 0xa1, 0xe8, 0x5d, 0x47, 0x4c, 0xd0, 0x78, 0xc2, 0x25, 0x9c,
+	0x98, 0x8d, 0x5f, 0xa0, 0x3c, 0xd2, 0x84, 0x90, 0x3d, 0x23, 0xc8, 0x85, 0xde, 0x94, 0xea, 0xb3,
+	0xaa, 0xb5, 0x4c, 0x4e, 0x28, 0x14, 0xf7, 0x99, 0x98, 0xe8, 0x73, 0x00, 0x42, 0xe5, 0x26, 0x72,
+	0x10, 0xfa, 0x89, 0x49, 0x21, 0x0d, 0x04, 0x56, 0x2f, 0xe0, 0x22, 0xd9, 0x80, 0x5f, 0xf0, 0x71,
+	0xe3, 0x2d, 0x54, 0xd2, 0x36, 0xfa, 0x1a, 0xcc, 0x84, 0x91, 0xdc, 0x36, 0x14, 0xd9, 0x91, 0xbe,
+	0xaa, 0x54, 0x63, 0x78, 0x15, 0xd4, 0xf8, 0xcb, 0x00, 0x6b, 0x28, 0xa5, 0xfd, 0xff, 0x1b, 0x7f,
+	0x0d, 0x85, 0x99, 0xc7, 0xbc, 0x69, 0x22, 0xaf, 0xfd, 0xd5, 0x37, 0xe9, 0x41, 0xd9, 0xd6, 0x40,
+	0x45, 0xc5, 0xe2, 0xd4, 0x29, 0xb5, 0x57, 0x50, 0x4e, 0xc1, 0x1f, 0xf3, 0x58, 0x1d, 0x7a, 0x50,
+	0x88, 0x3f, 0x7b, 0x68, 0x1b, 0xca, 0x97, 0xfd, 0x91, 0x8b, 0x9d, 0x76, 0xe7, 0x47, 0xe7, 0xd4,
+	0x7a, 0x82, 0xca, 0x50, 0x1c, 0x8e, 0xda, 0x78, 0xe4, 0x8e, 0x2c, 0x03, 0x99, 0x90, 0x77, 0x2e,
+	0x4f, 0xdd, 0x91, 0x95, 0x59, 0xe1, 0x1d, 0x2b, 0x9b, 0xe0, 0x1d, 0x2b, 0x27, 0x97, 0xed, 0x93,
+	0x3e, 0x1e, 0x59, 0x79, 0x15, 0x72, 0xde, 0x1d, 0x0c, 0x9c, 0x53, 0xab, 0x70, 0xf8, 0x15, 0x6c,
+	0xaf, 0x7d, 0x28, 0x51, 0x15, 0x4c, 0xec, 0x74, 0x87, 0xc3, 0xf7, 0x8e, 0x3b, 0x8a, 0x77, 0x3a,
+	0xe9, 0x75, 0x55, 0x19, 0xe3, 0xf0, 0x00, 0x2a, 0x69, 0x7d, 0xa2, 0x0a, 0x94, 0x4e, 0xda, 0x9d,
+	0xf3, 0x9f, 0xdb, 0x58, 0x37, 0x75, 0xd6, 0xc7, 0xca, 0x30, 0x0e, 0xdf, 0xc0, 0xce, 0x23, 0x2d,
+	0xa2, 0x3d, 0xd8, 0xc5, 0xce, 0x95, 0x83, 0x87, 0x8e, 0x3b, 0xea, 0x0f, 0xfa, 0xbd, 0xfe, 0xbb,
+	0x6e, 0xa7, 0xdd, 0xb3, 0x9e, 0xc8, 0x03, 0xa6, 0x01, 0xe3, 0xf0, 0x15, 0xc0, 0xea, 0x6b, 0x8a,
+	0x76, 0xa0, 0xda, 0xe9, 0x5f, 0x0c, 0x9c, 0xcb, 0x61, 0x7b, 0xd4, 0x3e, 0xe9, 0x39, 0xd6, 0x13,
+	0x79, 0xa2, 0x41, 0xf7, 0xaa, 0x2f, 0xcf, 0xaf, 0x3a, 0x1e, 0xe1, 0xae, 0xf2, 0x64, 0x8e, 0xfe,
+	0xc8, 0x40, 0xb9, 0x13, 0x45, 0x8c, 0x04, 0xa1, 0x27, 0x22, 0x86, 0x5a, 0x50, 0x51, 0x93, 0x92,
+	0x5a, 0xc2, 0x83, 0x0e, 0x5a, 0xd3, 0x56, 0x6d, 0xcd, 0x46, 0xdf, 0x24, 0x8a, 0x96, 0xc1, 0x56,
+	0xca, 0xa9, 0x34, 0x5e, 0x4b, 0xf8, 0x96, 0x16, 0xef, 0x1b, 0xd8, 0xc5, 0x74, 0x1c, 0x70, 0x41,
+	0x59, 0xc2, 0x07, 0x99, 0xbc, 0x81, 0x9a, 0xb5, 0x0d, 0x18, 0x7a, 0xbb, 0xc6, 0x7c, 0x99, 0xbb,
+	0xa7, 0xe3, 0xd6, 0x25, 0x51, 0xdb, 0x5d, 0x2b, 0x20, 0x41, 0xf4, 0x7a, 0x8d, 0xe4, 0xe9, 0x0a,
+	0xeb, 0x34, 0x5d, 0x3f, 0xf0, 0x75, 0x41, 0xfd, 0xf7, 0xfb, 0xf6, 0xdf, 0x01, 0x00, 0x3d, 0x0d,
+	0xe6, 0x69, 0x09, 0x0a, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -377,6 +994,13 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type CoordinatorClient interface {
 	StartSagaRPC(ctx context.Context, in *SagaMsg, opts ...grpc.CallOption) (*SagaMsg, error)
+	// Rebuild a saga as the coordinator saw it at a point in its log
+	SagaAtRPC(ctx context.Context, in *SagaAtMsg, opts ...grpc.CallOption) (*SnapshotMsg, error)
+	// Register a new version of a named saga template
+	RegisterTemplateRPC(ctx context.Context, in *TemplateMsg, opts ...grpc.CallOption) (*TemplateMsg, error)
+	ListTemplatesRPC(ctx context.Context, in *ListTemplatesMsg, opts ...grpc.CallOption) (*TemplatesMsg, error)
+	// Start a saga from a registered template
+	StartTemplateRPC(ctx context.Context, in *StartTemplateMsg, opts ...grpc.CallOption) (*SagaMsg, error)
 }
 
 type coordinatorClient struct {
@@ -396,9 +1020,52 @@ func (c *coordinatorClient) StartSagaRPC(ctx context.Context, in *SagaMsg, opts
 	return out, nil
 }
 
+func (c *coordinatorClient) SagaAtRPC(ctx context.Context, in *SagaAtMsg, opts ...grpc.CallOption) (*SnapshotMsg, error) {
+	out := new(SnapshotMsg)
+	err := c.cc.Invoke(ctx, "/sagas.Coordinator/SagaAtRPC", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) RegisterTemplateRPC(ctx context.Context, in *TemplateMsg, opts ...grpc.CallOption) (*TemplateMsg, error) {
+	out := new(TemplateMsg)
+	err := c.cc.Invoke(ctx, "/sagas.Coordinator/RegisterTemplateRPC", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) ListTemplatesRPC(ctx context.Context, in *ListTemplatesMsg, opts ...grpc.CallOption) (*TemplatesMsg, error) {
+	out := new(TemplatesMsg)
+	err := c.cc.Invoke(ctx, "/sagas.Coordinator/ListTemplatesRPC", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *coordinatorClient) StartTemplateRPC(ctx context.Context, in *StartTemplateMsg, opts ...grpc.CallOption) (*SagaMsg, error) {
+	out := new(SagaMsg)
+	err := c.cc.Invoke(ctx, "/sagas.Coordinator/StartTemplateRPC", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CoordinatorServer is the server API for Coordinator service.
 type CoordinatorServer interface {
 	StartSagaRPC(context.Context, *SagaMsg) (*SagaMsg, error)
+	// Rebuild a saga as the coordinator saw it at a point in its log
+	SagaAtRPC(context.Context, *SagaAtMsg) (*SnapshotMsg, error)
+	// Register a new version of a named saga template
+	RegisterTemplateRPC(context.Context, *TemplateMsg) (*TemplateMsg, error)
+	ListTemplatesRPC(context.Context, *ListTemplatesMsg) (*TemplatesMsg, error)
+	// Start a saga from a registered template
+	StartTemplateRPC(context.Context, *StartTemplateMsg) (*SagaMsg, error)
 }
 
 // UnimplementedCoordinatorServer can be embedded to have forward compatible implementations.
@@ -408,6 +1075,18 @@ type UnimplementedCoordinatorServer struct {
 func (*UnimplementedCoordinatorServer) StartSagaRPC(ctx context.Context, req *SagaMsg) (*SagaMsg, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method StartSagaRPC not implemented")
 }
+func (*UnimplementedCoordinatorServer) SagaAtRPC(ctx context.Context, req *SagaAtMsg) (*SnapshotMsg, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SagaAtRPC not implemented")
+}
+func (*UnimplementedCoordinatorServer) RegisterTemplateRPC(ctx context.Context, req *TemplateMsg) (*TemplateMsg, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterTemplateRPC not implemented")
+}
+func (*UnimplementedCoordinatorServer) ListTemplatesRPC(ctx context.Context, req *ListTemplatesMsg) (*TemplatesMsg, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTemplatesRPC not implemented")
+}
+func (*UnimplementedCoordinatorServer) StartTemplateRPC(ctx context.Context, req *StartTemplateMsg) (*SagaMsg, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartTemplateRPC not implemented")
+}
 
 func RegisterCoordinatorServer(s *grpc.Server, srv CoordinatorServer) {
 	s.RegisterService(&_Coordinator_serviceDesc, srv)
@@ -431,6 +1110,78 @@ func _Coordinator_StartSagaRPC_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Coordinator_SagaAtRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SagaAtMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).SagaAtRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sagas.Coordinator/SagaAtRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).SagaAtRPC(ctx, req.(*SagaAtMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_RegisterTemplateRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TemplateMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).RegisterTemplateRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sagas.Coordinator/RegisterTemplateRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).RegisterTemplateRPC(ctx, req.(*TemplateMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_ListTemplatesRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTemplatesMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).ListTemplatesRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sagas.Coordinator/ListTemplatesRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).ListTemplatesRPC(ctx, req.(*ListTemplatesMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Coordinator_StartTemplateRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartTemplateMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CoordinatorServer).StartTemplateRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sagas.Coordinator/StartTemplateRPC",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CoordinatorServer).StartTemplateRPC(ctx, req.(*StartTemplateMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Coordinator_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "sagas.Coordinator",
 	HandlerType: (*CoordinatorServer)(nil),
@@ -439,6 +1190,22 @@ var _Coordinator_serviceDesc = grpc.ServiceDesc{
 			MethodName: "StartSagaRPC",
 			Handler:    _Coordinator_StartSagaRPC_Handler,
 		},
+		{
+			MethodName: "SagaAtRPC",
+			Handler:    _Coordinator_SagaAtRPC_Handler,
+		},
+		{
+			MethodName: "RegisterTemplateRPC",
+			Handler:    _Coordinator_RegisterTemplateRPC_Handler,
+		},
+		{
+			MethodName: "ListTemplatesRPC",
+			Handler:    _Coordinator_ListTemplatesRPC_Handler,
+		},
+		{
+			MethodName: "StartTemplateRPC",
+			Handler:    _Coordinator_StartTemplateRPC_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "saga.proto",
@@ -1,9 +1,15 @@
 package sagas
 
 import (
+	"errors"
+	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/triplewy/sagas/utils"
 	"gotest.tools/assert"
 )
 
@@ -140,6 +146,7 @@ func TestSaga(t *testing.T) {
 				{"START_T", Status_START_T, false, false},
 				{"END_T", Status_END_T, true, false},
 				{"ABORT", Status_ABORT, true, true},
+				{"SKIPPED", Status_SKIPPED, true, false},
 			}
 
 			for _, tt := range tests {
@@ -175,6 +182,11 @@ func TestSaga(t *testing.T) {
 				{"START_T END_T", Status_START_T, Status_END_T, false, false},
 
 				{"END_T END_T", Status_END_T, Status_END_T, true, false},
+
+				{"SKIPPED START_T", Status_SKIPPED, Status_START_T, false, false},
+				{"SKIPPED END_T", Status_SKIPPED, Status_END_T, true, false},
+				{"SKIPPED ABORT", Status_SKIPPED, Status_ABORT, true, true},
+				{"SKIPPED END_C", Status_SKIPPED, Status_END_C, false, false},
 			}
 
 			for _, tt := range tests {
@@ -189,6 +201,252 @@ func TestSaga(t *testing.T) {
 				})
 			}
 		})
+
+		// Aborted sagas in forward recovery finish without compensating
+		t.Run("forward", func(t *testing.T) {
+			tests := []struct {
+				name           string
+				status1        Status
+				status2        Status
+				expectFinished bool
+			}{
+				{"END_T ABORT", Status_END_T, Status_ABORT, true},
+				{"END_T NOT_REACHED", Status_END_T, Status_NOT_REACHED, true},
+				{"END_T START_T", Status_END_T, Status_START_T, false},
+				{"ABORT START_T", Status_ABORT, Status_START_T, false},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					vertices := map[string]Vertex{
+						"1": Vertex{Id: "1", Status: tt.status1},
+						"2": Vertex{Id: "2", Status: tt.status2},
+					}
+					saga := NewSaga(vertices, map[string]map[string][]string{"1": {"2": nil}, "2": {}})
+					saga.Recovery = RecoveryMode_FORWARD
+					saga.aborted.Store(true)
+					finished, aborted := CheckFinishedOrAbort(saga)
+					assert.Assert(t, finished == tt.expectFinished && aborted)
+					assert.Equal(t, len(SagaBFS(saga)), 0)
+				})
+			}
+		})
+	})
+
+	t.Run("bfs", func(t *testing.T) {
+		dag := map[string]map[string][]string{"1": {}, "2": {}}
+		tests := []struct {
+			name    string
+			status1 Status
+			status2 Status
+			ids     []string
+		}{
+			{"NOT_REACHED NOT_REACHED", Status_NOT_REACHED, Status_NOT_REACHED, []string{"1", "2"}},
+			{"END_T START_T", Status_END_T, Status_START_T, []string{"2"}},
+			{"ABORT NOT_REACHED", Status_ABORT, Status_NOT_REACHED, nil},
+			{"ABORT START_T", Status_ABORT, Status_START_T, []string{"2"}},
+			{"ABORT END_T", Status_ABORT, Status_END_T, []string{"2"}},
+			{"ABORT START_C", Status_ABORT, Status_START_C, []string{"2"}},
+			{"ABORT END_C", Status_ABORT, Status_END_C, nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				vertices := map[string]Vertex{
+					"1": Vertex{Id: "1", Status: tt.status1},
+					"2": Vertex{Id: "2", Status: tt.status2},
+				}
+				var ids []string
+				for _, vtx := range SagaBFS(NewSaga(vertices, dag)) {
+					ids = append(ids, vtx.Id)
+				}
+				sort.Strings(ids)
+				assert.DeepEqual(t, ids, tt.ids)
+			})
+		}
+
+		// A child with two parents waits for both to commit
+		peak := map[string]map[string][]string{"1": {"3": nil}, "2": {"3": nil}, "3": {}}
+		peakTests := []struct {
+			name    string
+			status2 Status
+			ids     []string
+		}{
+			{"START_T", Status_START_T, []string{"2"}},
+			{"END_T", Status_END_T, []string{"3"}},
+			{"SKIPPED", Status_SKIPPED, []string{"3"}},
+		}
+		for _, tt := range peakTests {
+			t.Run("bottom peak "+tt.name, func(t *testing.T) {
+				vertices := map[string]Vertex{
+					"1": Vertex{Id: "1", Status: Status_END_T},
+					"2": Vertex{Id: "2", Status: tt.status2},
+					"3": Vertex{Id: "3", Status: Status_NOT_REACHED},
+				}
+				var ids []string
+				for _, vtx := range SagaBFS(NewSaga(vertices, peak)) {
+					ids = append(ids, vtx.Id)
+				}
+				assert.DeepEqual(t, ids, tt.ids)
+			})
+		}
+
+		// An aborted chain compensates children first unless topological order is chosen
+		chain := map[string]map[string][]string{"1": {"2": nil}, "2": {"3": nil}, "3": {"4": nil}, "4": {}}
+		orderTests := []struct {
+			name    string
+			order   CompensationOrder
+			status2 Status
+			status3 Status
+			ids     []string
+		}{
+			{"reverse END_T END_T", CompensationOrder_REVERSE_TOPOLOGICAL, Status_END_T, Status_END_T, []string{"3"}},
+			{"reverse END_T START_C", CompensationOrder_REVERSE_TOPOLOGICAL, Status_END_T, Status_START_C, []string{"3"}},
+			{"reverse END_T END_C", CompensationOrder_REVERSE_TOPOLOGICAL, Status_END_T, Status_END_C, []string{"2"}},
+			{"reverse END_C END_C", CompensationOrder_REVERSE_TOPOLOGICAL, Status_END_C, Status_END_C, []string{"1"}},
+			{"reverse SKIPPED END_T", CompensationOrder_REVERSE_TOPOLOGICAL, Status_SKIPPED, Status_END_T, []string{"3"}},
+			{"topological END_T END_T", CompensationOrder_TOPOLOGICAL, Status_END_T, Status_END_T, []string{"1"}},
+			{"topological END_T END_C", CompensationOrder_TOPOLOGICAL, Status_END_T, Status_END_C, []string{"1"}},
+		}
+		for _, tt := range orderTests {
+			t.Run("chain "+tt.name, func(t *testing.T) {
+				vertices := map[string]Vertex{
+					"1": Vertex{Id: "1", Status: Status_END_T},
+					"2": Vertex{Id: "2", Status: tt.status2},
+					"3": Vertex{Id: "3", Status: tt.status3},
+					"4": Vertex{Id: "4", Status: Status_ABORT},
+				}
+				saga := NewSaga(vertices, chain)
+				saga.Compensation = tt.order
+				var ids []string
+				for _, vtx := range SagaBFS(saga) {
+					ids = append(ids, vtx.Id)
+				}
+				assert.DeepEqual(t, ids, tt.ids)
+			})
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		// 1 -> 2 is taken if the shuttle is available, 2 -> 3 always and 1 -> 4 if it is not
+		dag := map[string]map[string][]string{"1": {"2": nil, "4": nil}, "2": {"3": nil}, "3": {}, "4": {}}
+		predicates := map[string]map[string]string{"1": {"2": `shuttle == "available"`, "4": `shuttle != "available"`}}
+		tests := []struct {
+			name    string
+			shuttle string
+			skipped []string
+		}{
+			{"available", "available", []string{"4"}},
+			{"full", "full", []string{"2", "3"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				vertices := map[string]Vertex{
+					"1": Vertex{Id: "1", Status: Status_END_T, T: &Func{Resp: map[string]string{"shuttle": tt.shuttle}}},
+					"2": Vertex{Id: "2"},
+					"3": Vertex{Id: "3"},
+					"4": Vertex{Id: "4"},
+				}
+				saga := NewSaga(vertices, dag)
+				saga.Predicates = predicates
+				var ids []string
+				for _, vtx := range skipVertices(saga) {
+					ids = append(ids, vtx.Id)
+				}
+				assert.DeepEqual(t, ids, tt.skipped)
+				assert.NilError(t, CheckValidSaga(saga))
+				// The vertex taken is the only one left to run
+				bfs := SagaBFS(saga)
+				assert.Equal(t, len(bfs), 1)
+				assert.Assert(t, bfs[0].Status == Status_NOT_REACHED)
+			})
+		}
+	})
+
+	t.Run("pivot", func(t *testing.T) {
+		// 1 -> 2 -> 3 -> 4 with 5 branching off 1
+		dag := map[string]map[string][]string{"1": {"2": nil, "5": nil}, "2": {"3": nil}, "3": {"4": nil}, "4": {}, "5": {}}
+		c, p, r := VertexKind_COMPENSATABLE, VertexKind_PIVOT, VertexKind_RETRIABLE
+		tests := []struct {
+			name  string
+			kinds [5]VertexKind
+			valid bool
+		}{
+			{"no pivot", [5]VertexKind{c, c, c, c, c}, true},
+			{"retriable without pivot", [5]VertexKind{c, c, c, r, c}, false},
+			{"two pivots", [5]VertexKind{c, p, p, r, c}, false},
+			{"parallel to pivot", [5]VertexKind{c, p, r, r, c}, false},
+			{"pivot at fork", [5]VertexKind{p, r, r, r, r}, true},
+			{"compensatable after pivot", [5]VertexKind{p, r, c, r, r}, false},
+			{"retriable before pivot", [5]VertexKind{r, r, p, r, c}, false},
+			{"pivot last", [5]VertexKind{c, c, c, c, p}, false},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				kinds := make(map[string]VertexKind, len(tt.kinds))
+				for i, kind := range tt.kinds {
+					kinds[strconv.Itoa(i+1)] = kind
+				}
+				err := checkPivot(kinds, dag)
+				assert.Equal(t, err == nil, tt.valid, err)
+				if err != nil {
+					assert.Assert(t, errors.Is(err, ErrInvalidPivot))
+				}
+			})
+		}
+
+		child := &SagaMsg{Vertices: map[string]*Vertex{"x": {Id: "x", Kind: p}}}
+		childTests := []struct {
+			name  string
+			msg   *SagaMsg
+			valid bool
+		}{
+			{"child pivot", &SagaMsg{Vertices: map[string]*Vertex{"k": {Id: "k", Saga: child, Kind: p}}}, true},
+			{"child pivot not pivot", &SagaMsg{Vertices: map[string]*Vertex{"k": {Id: "k", Saga: child}}}, false},
+			{"retriable child", &SagaMsg{
+				Vertices: map[string]*Vertex{"a": {Id: "a", Kind: p}, "k": {Id: "k", Saga: &SagaMsg{}, Kind: r}},
+				Edges:    []*Edge{{StartId: "a", EndId: "k"}},
+			}, false},
+			{"blind pivot", &SagaMsg{Vertices: map[string]*Vertex{"a": {Id: "a", Kind: p, UncertainPolicy: UncertainPolicy_BLIND_C}}}, false},
+			{"invalid in child", &SagaMsg{Vertices: map[string]*Vertex{"k": {Id: "k", Saga: &SagaMsg{Vertices: map[string]*Vertex{"x": {Id: "x", Kind: r}}}}}}, false},
+		}
+		for _, tt := range childTests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := checkKinds(tt.msg)
+				assert.Equal(t, err == nil, tt.valid, err)
+			})
+		}
+	})
+
+	t.Run("compensation", func(t *testing.T) {
+		post := &Func{Url: "u", Method: "POST"}
+		noop := &Func{Method: "noop"}
+		tests := []struct {
+			name  string
+			vtx   *Vertex
+			valid bool
+		}{
+			{"with C", &Vertex{Id: "a", C: post}, true},
+			{"omitted C", &Vertex{Id: "a"}, false},
+			{"empty C", &Vertex{Id: "a", C: &Func{Body: map[string]string{}}}, false},
+			{"noop C", &Vertex{Id: "a", C: noop}, false},
+			{"read-only omitted C", &Vertex{Id: "a", ReadOnly: true}, true},
+			{"read-only noop C", &Vertex{Id: "a", C: noop, ReadOnly: true}, true},
+			{"pivot omitted C", &Vertex{Id: "a", Kind: VertexKind_PIVOT}, true},
+			{"child saga", &Vertex{Id: "a", Saga: &SagaMsg{Vertices: map[string]*Vertex{"x": {Id: "x", C: post}}}}, true},
+			{"invalid in child", &Vertex{Id: "a", Saga: &SagaMsg{Vertices: map[string]*Vertex{"x": {Id: "x"}}}}, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := checkCompensations(&SagaMsg{Vertices: map[string]*Vertex{"a": tt.vtx}})
+				assert.Equal(t, err == nil, tt.valid, err)
+				if err != nil {
+					assert.Assert(t, errors.Is(err, ErrMissingCompensation))
+				}
+			})
+		}
 	})
 
 	t.Run("valid saga", func(t *testing.T) {
@@ -316,6 +574,14 @@ func TestSaga(t *testing.T) {
 					{"END_C START_C", Status_START_C, Status_START_C, ErrInvalidSaga},
 					{"END_C END_C", Status_START_C, Status_END_C, ErrInvalidSaga},
 					{"END_C ABORT", Status_START_C, Status_ABORT, nil},
+
+					{"NOT_REACHED SKIPPED", Status_NOT_REACHED, Status_SKIPPED, ErrInvalidSaga},
+					{"START_T SKIPPED", Status_START_T, Status_SKIPPED, ErrInvalidSaga},
+					{"END_T SKIPPED", Status_END_T, Status_SKIPPED, nil},
+					{"SKIPPED NOT_REACHED", Status_SKIPPED, Status_NOT_REACHED, nil},
+					{"SKIPPED START_T", Status_SKIPPED, Status_START_T, nil},
+					{"SKIPPED SKIPPED", Status_SKIPPED, Status_SKIPPED, nil},
+					{"SKIPPED ABORT", Status_SKIPPED, Status_ABORT, nil},
 				}
 
 				for _, tt := range tests {
@@ -379,5 +645,319 @@ func TestSaga(t *testing.T) {
 				})
 			}
 		})
+
+		// A child with two parents may only start once both have committed, so a
+		// scheduler that starts it after the first leaves the saga invalid
+		t.Run("bottom peak", func(t *testing.T) {
+			dag := map[string]map[string][]string{"1": {"3": nil}, "2": {"3": nil}, "3": {}}
+			tests := []struct {
+				name        string
+				status1     Status
+				status2     Status
+				status3     Status
+				expectError error
+			}{
+				{"END_T START_T NOT_REACHED", Status_END_T, Status_START_T, Status_NOT_REACHED, nil},
+				{"END_T START_T START_T", Status_END_T, Status_START_T, Status_START_T, ErrInvalidSaga},
+				{"END_T END_T START_T", Status_END_T, Status_END_T, Status_START_T, nil},
+				{"END_C ABORT NOT_REACHED", Status_END_C, Status_ABORT, Status_NOT_REACHED, nil},
+				{"END_C ABORT ABORT", Status_END_C, Status_ABORT, Status_ABORT, ErrInvalidSaga},
+				{"END_C ABORT END_C", Status_END_C, Status_ABORT, Status_END_C, ErrInvalidSaga},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					vertices := map[string]Vertex{
+						"1": Vertex{Id: "1", Status: tt.status1},
+						"2": Vertex{Id: "2", Status: tt.status2},
+						"3": Vertex{Id: "3", Status: tt.status3},
+					}
+					saga := NewSaga(vertices, dag)
+					assert.Equal(t, CheckValidSaga(saga), tt.expectError)
+				})
+			}
+		})
+	})
+}
+
+func TestPredicate(t *testing.T) {
+	resp := map[string]string{"shuttle": "available", "nights": "2", "discount": "", "quote": `say "hi"`}
+	tests := []struct {
+		expr   string
+		expect bool
+	}{
+		{``, true},
+		{`shuttle == "available"`, true},
+		{`shuttle != "available"`, false},
+		{`"2" == nights`, true},
+		{`missing == ""`, true},
+		{`shuttle`, true},
+		{`discount`, false},
+		{`!discount`, true},
+		{`!!shuttle`, true},
+		{`quote == "say \"hi\""`, true},
+		{`shuttle == "full" || nights == "2"`, true},
+		{`shuttle == "full" || nights == "2" && discount`, false},
+		{`(shuttle == "full" || nights == "2") && !discount`, true},
+		{`shuttle == "café"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			pred, err := compilePredicate(tt.expr)
+			assert.NilError(t, err)
+			assert.Equal(t, pred(resp), tt.expect)
+		})
+	}
+
+	for _, expr := range []string{`shuttle ==`, `"available"`, `(shuttle`, `shuttle)`, `shuttle = "a"`, `shuttle == "a`, `&& shuttle`, `a b`,
+		// Fields are ASCII, and strings must be terminated
+		`café == "x"`, `é`, "\xff", `shuttle == "a\`, `"`} {
+		t.Run("invalid "+expr, func(t *testing.T) {
+			_, err := compilePredicate(expr)
+			assert.Assert(t, errors.Is(err, ErrInvalidPredicate), err)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	saga := NewSaga(map[string]Vertex{
+		"1": {Id: "1", Status: Status_END_T},
+		"2": {Id: "2", Status: Status_ABORT},
+		"3": {Id: "3", Status: Status_END_C},
+		"4": {Id: "4", Status: Status_NOT_REACHED},
+	}, map[string]map[string][]string{
+		"1": {"2": []string{"id"}, "4": nil},
+		"2": {"4": nil},
+		"3": {"4": nil},
+		"4": {},
+	})
+	saga.ID = "7"
+
+	t.Run("dot", func(t *testing.T) {
+		dot := `digraph "saga 7" {
+	node [shape=box, style="rounded,filled"];
+	"1" [label="1\nEND_T", fillcolor="#5cb85c"];
+	"2" [label="2\nABORT", fillcolor="#d9534f"];
+	"3" [label="3\nEND_C", fillcolor="#9b7fd4"];
+	"4" [label="4\nNOT_REACHED", fillcolor="#bbbbbb"];
+	"1" -> "2" [label="id"];
+	"1" -> "4";
+	"2" -> "4";
+	"3" -> "4";
+}
+`
+		assert.Equal(t, saga.DOT(), dot)
+		assert.Equal(t, sagaToProto(saga).DOT(), dot)
+	})
+
+	t.Run("ascii", func(t *testing.T) {
+		ascii := `# 7
+|1:END_T      |             |3:END_C      |
+|             |2:ABORT      |             |
+|             |4:NOT_REACHED|             |
+1 -> 2 [id]
+1 -> 4
+2 -> 4
+3 -> 4
+`
+		assert.Equal(t, saga.ASCII(), ascii)
+		assert.Equal(t, sagaToProto(saga).ASCII(), ascii)
+	})
+
+	t.Run("grid", func(t *testing.T) {
+		saga := NewSaga(map[string]Vertex{
+			"a": {Id: "a", Status: Status_END_T},
+			"b": {Id: "b", Status: Status_END_T},
+			"c": {Id: "c", Status: Status_NOT_REACHED},
+			"d": {Id: "d", Status: Status_END_T},
+		}, map[string]map[string][]string{
+			"a": {"c": nil},
+			"b": {"c": []string{"id"}, "d": nil},
+			"c": {},
+			"d": {},
+		})
+		saga.ID = "8"
+		ascii := `# 8
+|a:END_T      |             |b:END_T      |             |
+|             |c:NOT_REACHED|             |d:END_T      |
+b -> c [id]
+`
+		assert.Equal(t, saga.ASCII(), ascii)
+		assert.Equal(t, sagaToProto(saga).ASCII(), ascii)
+	})
+
+	// Every scenario of graphs.txt is drawn in its layout, so replacing cells with
+	// status codes gives a graph file that parses back to the same saga
+	graphs, err := utils.ParseGraphFile("graphs.txt")
+	assert.NilError(t, err)
+	codes := map[Status]string{Status_NOT_REACHED: "0", Status_END_T: "1", Status_END_C: "4", Status_ABORT: "5", Status_SKIPPED: "6"}
+	for _, graph := range graphs {
+		name, children, statuses, err := utils.ParseGraphText(graph)
+		assert.NilError(t, err)
+
+		t.Run(name, func(t *testing.T) {
+			vertices := make(map[string]Vertex, len(statuses))
+			dag := make(map[string]map[string][]string, len(children))
+			for id, status := range statuses {
+				vertices[id] = Vertex{Id: id, Status: Status(status)}
+				dag[id] = make(map[string][]string)
+				for _, child := range children[id] {
+					dag[id][child] = nil
+				}
+			}
+			lines := strings.Split(strings.TrimSuffix(NewSaga(vertices, dag).ASCII(), "\n"), "\n")
+
+			// ParseGraphText names vertices by position, so map them back to the saga's ids
+			ids := make(map[string]string)
+			for l, line := range lines[1:] {
+				assert.Assert(t, strings.HasPrefix(line, "|"), "edges are implied by the grid: %v", line)
+				cells := strings.Split(strings.Trim(line, "|"), "|")
+				for c, cell := range cells {
+					cell = strings.TrimSpace(cell)
+					if cell == "" {
+						continue
+					}
+					id := cell[:strings.Index(cell, ":")]
+					ids[fmt.Sprintf("%d-%d", l, c)] = id
+					cells[c] = codes[vertices[id].Status]
+				}
+				lines[l+1] = "|" + strings.Join(cells, "|") + "|"
+			}
+
+			_, parsedChildren, parsedStatuses, err := utils.ParseGraphText(strings.Join(lines, "\n"))
+			assert.NilError(t, err)
+			assert.Equal(t, len(parsedStatuses), len(statuses))
+			for pos, status := range parsedStatuses {
+				id := ids[pos]
+				assert.Equal(t, status, statuses[id], "vertex %v", id)
+				var got []string
+				for _, child := range parsedChildren[pos] {
+					got = append(got, ids[child])
+				}
+				sort.Strings(got)
+				want := append([]string(nil), children[id]...)
+				sort.Strings(want)
+				assert.DeepEqual(t, got, want)
+			}
+		})
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	params := map[string]string{"userID": "alice", "first": "101", "second": "102"}
+
+	// sortEdges orders edges of a saga message, which sagaToProto leaves in map order
+	sortEdges := func(msg *SagaMsg) *SagaMsg {
+		sort.Slice(msg.Edges, func(i, j int) bool {
+			return msg.Edges[i].StartId+" "+msg.Edges[i].EndId < msg.Edges[j].StartId+" "+msg.Edges[j].EndId
+		})
+		return msg
+	}
+
+	for _, file := range []string{"testdata/definitions/book.yaml", "testdata/definitions/book.json"} {
+		t.Run(file, func(t *testing.T) {
+			def, err := ReadDefinition(file)
+			assert.NilError(t, err)
+			msg, err := def.SagaMsg(params)
+			assert.NilError(t, err)
+
+			first := msg.Vertices["first"]
+			assert.Equal(t, first.Id, "first")
+			assert.DeepEqual(t, first.T.Body, map[string]string{"userID": "alice", "roomID": "101"})
+			assert.Equal(t, msg.Vertices["second"].UncertainPolicy, UncertainPolicy_BLIND_C)
+
+			// Through the coordinator's representation and back
+			assert.Assert(t, proto.Equal(sortEdges(sagaToProto(protoToSaga(msg))), msg))
+
+			// Through a definition file without params and back
+			for _, format := range []string{DefinitionYAML, DefinitionJSON} {
+				data, err := NewDefinition(msg).Marshal(format)
+				assert.NilError(t, err)
+				parsed, err := ParseDefinition(data, format)
+				assert.NilError(t, err)
+				again, err := parsed.SagaMsg(nil)
+				assert.NilError(t, err)
+				assert.Assert(t, proto.Equal(again, msg), format)
+			}
+		})
+	}
+
+	t.Run("params", func(t *testing.T) {
+		def, err := ReadDefinition("testdata/definitions/book.yaml")
+		assert.NilError(t, err)
+
+		_, err = def.SagaMsg(map[string]string{"userID": "alice", "first": "101"})
+		assert.Assert(t, errors.Is(err, ErrMissingParam))
+		_, err = def.SagaMsg(map[string]string{"userID": "alice", "first": "101", "second": "102", "third": "103"})
+		assert.Assert(t, errors.Is(err, ErrUnknownParam))
+	})
+
+	t.Run("child saga", func(t *testing.T) {
+		def, err := ParseDefinition([]byte(`
+params: [userID]
+compensation: TOPOLOGICAL
+vertices:
+  quote: {t: {url: "u/quote", method: GET}, readOnly: true}
+  pay: {t: {url: "u/pay", method: POST}, c: {url: "u/refund", method: POST}}
+  hotel:
+    saga:
+      recovery: FORWARD
+      vertices:
+        book: {t: {url: "u/book", method: POST, body: {userID: "${userID}"}}, c: {url: "u/cancel", method: POST}}
+edges: [{from: pay, to: hotel}, {from: quote, to: pay}]
+`), DefinitionYAML)
+		assert.NilError(t, err)
+		msg, err := def.SagaMsg(map[string]string{"userID": "alice"})
+		assert.NilError(t, err)
+		assert.Equal(t, msg.CompensationOrder, CompensationOrder_TOPOLOGICAL)
+		assert.Assert(t, msg.Vertices["quote"].ReadOnly && msg.Vertices["quote"].C.noop())
+		child := msg.Vertices["hotel"].Saga
+		assert.Assert(t, child != nil)
+		assert.Equal(t, child.RecoveryMode, RecoveryMode_FORWARD)
+		assert.DeepEqual(t, child.Vertices["book"].T.Body, map[string]string{"userID": "alice"})
+
+		for _, format := range []string{DefinitionYAML, DefinitionJSON} {
+			data, err := NewDefinition(msg).Marshal(format)
+			assert.NilError(t, err)
+			parsed, err := ParseDefinition(data, format)
+			assert.NilError(t, err)
+			again, err := parsed.SagaMsg(nil)
+			assert.NilError(t, err)
+			assert.Assert(t, proto.Equal(again, msg), format)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name string
+			def  string
+		}{
+			{"no vertices", `vertices: {}`},
+			{"no method", `vertices: {a: {t: {url: u}, c: {url: u, method: POST}}}`},
+			{"no url", `vertices: {a: {t: {method: POST}, c: {url: u, method: POST}}}`},
+			{"undeclared param", `vertices: {a: {t: {url: "${host}/book", method: POST}, c: {method: LOCAL}}}`},
+			{"invalid param", `{params: ["a b"], vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}}}`},
+			{"unknown policy", `vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}, uncertainPolicy: MAYBE}}`},
+			{"unknown vertex", `{vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}}, edges: [{from: a, to: b}]}`},
+			{"duplicate edge", `{vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}, b: {t: {method: LOCAL}, c: {method: LOCAL}}}, edges: [{from: a, to: b}, {from: a, to: b}]}`},
+			{"unknown recovery", `{recovery: MAYBE, vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}}}`},
+			{"unknown compensation", `{compensation: MAYBE, vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}}}`},
+			{"missing compensation", `vertices: {a: {t: {method: LOCAL}}}`},
+			{"noop compensation", `vertices: {a: {t: {method: LOCAL}, c: {method: NOOP}}}`},
+			{"unknown kind", `vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}, kind: MAYBE}}`},
+			{"illegal pivot", `{vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}, kind: PIVOT}, b: {t: {method: LOCAL}, c: {method: LOCAL}}}, edges: [{from: a, to: b}]}`},
+			{"invalid predicate", `{vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}, b: {t: {method: LOCAL}, c: {method: LOCAL}}}, edges: [{from: a, to: b, predicate: "a =="}]}`},
+			{"cycle", `{vertices: {a: {t: {method: LOCAL}, c: {method: LOCAL}}, b: {t: {method: LOCAL}, c: {method: LOCAL}}}, edges: [{from: a, to: b}, {from: b, to: a}]}`},
+			{"saga and funcs", `vertices: {a: {t: {method: LOCAL}, saga: {vertices: {b: {t: {method: LOCAL}, c: {method: LOCAL}}}}}}`},
+			{"child params", `vertices: {a: {saga: {params: [p], vertices: {b: {t: {method: LOCAL}, c: {method: LOCAL}}}}}}`},
+			{"invalid child", `vertices: {a: {saga: {vertices: {b: {t: {method: LOCAL}}}}}}`},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := ParseDefinition([]byte(tt.def), DefinitionYAML)
+				assert.Assert(t, errors.Is(err, ErrInvalidDefinition), err)
+			})
+		}
 	})
 }
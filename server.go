@@ -6,6 +6,10 @@ import (
 	"net"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 // Errors encountered by client API
@@ -24,6 +28,8 @@ func NewServer(addr string, c *Coordinator) *grpc.Server {
 	}
 
 	RegisterCoordinatorServer(s, c)
+	// The server starts once the coordinator has recovered, so it is ready to serve
+	healthpb.RegisterHealthServer(s, health.NewServer())
 
 	go s.Serve(lis)
 
@@ -32,30 +38,167 @@ func NewServer(addr string, c *Coordinator) *grpc.Server {
 
 // Authorization unary interceptor function to handle authorize per RPC call
 func serverInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	// Continue the caller's trace
+	ctx = extractMetadata(ctx)
+
 	// Calls the handler
 	return handler(ctx, req)
 }
 
 // StartSagaRPC starts a saga
 func (c *Coordinator) StartSagaRPC(ctx context.Context, req *SagaMsg) (*SagaMsg, error) {
+	if err := checkPredicates(req); err != nil {
+		return nil, err
+	}
+	if err := checkKinds(req); err != nil {
+		return nil, err
+	}
+	if err := checkCompensations(req); err != nil {
+		return nil, err
+	}
 	saga := protoToSaga(req)
 	// Don't forget to set sagaID
-	sagaID := c.logs.NewSagaID()
+	sagaID, err := c.logs.NewSagaID()
+	if err != nil {
+		return nil, err
+	}
 	saga.ID = sagaID
+	// Only the coordinator starts child sagas
+	saga.Parent, saga.ParentVertex = "", ""
+
+	if err := c.setRequestIDs(saga); err != nil {
+		return nil, err
+	}
 
 	replyCh := make(chan Saga, 1)
 	c.createCh <- createMsg{
+		ctx:     ctx,
 		saga:    saga,
 		replyCh: replyCh,
+		sent:    c.clock.Now(),
 	}
 
-	replySaga := <-replyCh
+	var replySaga Saga
+	select {
+	case replySaga = <-replyCh:
+	case <-c.stop:
+		return nil, ErrCoordinatorClosed
+	}
 
 	sagaResp := sagaToProto(replySaga)
 
 	return sagaResp, nil
 }
 
+// checkPredicates compiles the edge predicates of a saga message and its child sagas
+func checkPredicates(msg *SagaMsg) error {
+	for _, edge := range msg.GetEdges() {
+		if _, err := compilePredicate(edge.GetPredicate()); err != nil {
+			return err
+		}
+	}
+	for _, vtx := range msg.GetVertices() {
+		if vtx.GetSaga() != nil {
+			if err := checkPredicates(vtx.GetSaga()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setRequestIDs sets request IDs so participants can dedupe retried and re-issued requests
+func (c *Coordinator) setRequestIDs(saga Saga) error {
+	for tuple := range saga.Vertices.IterBuffered() {
+		vtx := tuple.Val.(Vertex)
+		for _, f := range []*Func{vtx.T, vtx.C} {
+			if f.RequestId != "" {
+				continue
+			}
+			id, err := c.logs.NewRequestID()
+			if err != nil {
+				return err
+			}
+			f.RequestId = id
+		}
+	}
+	return nil
+}
+
+// SagaAtRPC rebuilds a saga as of an lsn in its log
+func (c *Coordinator) SagaAtRPC(ctx context.Context, req *SagaAtMsg) (*SnapshotMsg, error) {
+	snapshot, err := SagaAt(c.logs, req.GetSagaId(), req.GetLsn())
+	switch err {
+	case nil:
+		return snapshotToProto(snapshot), nil
+	case ErrSagaNotLogged:
+		return nil, status.Error(codes.NotFound, err.Error())
+	case ErrUnknownLogSaga, ErrUnknownLogType, ErrIDNotFound:
+		return nil, status.Error(codes.DataLoss, err.Error())
+	default:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+}
+
+// RegisterTemplateRPC registers a new version of a template
+func (c *Coordinator) RegisterTemplateRPC(ctx context.Context, req *TemplateMsg) (*TemplateMsg, error) {
+	def, err := ParseDefinition([]byte(req.GetDefinition()), DefinitionJSON)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.RegisterTemplate(req.GetName(), def)
+	if err != nil {
+		return nil, err
+	}
+	return templateToProto(t), nil
+}
+
+// ListTemplatesRPC lists every version of every registered template
+func (c *Coordinator) ListTemplatesRPC(ctx context.Context, req *ListTemplatesMsg) (*TemplatesMsg, error) {
+	resp := &TemplatesMsg{}
+	for _, t := range c.ListTemplates() {
+		resp.Templates = append(resp.Templates, templateToProto(t))
+	}
+	return resp, nil
+}
+
+// StartTemplateRPC starts a saga from a version of a template
+func (c *Coordinator) StartTemplateRPC(ctx context.Context, req *StartTemplateMsg) (*SagaMsg, error) {
+	t, err := c.Template(req.GetName(), req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+	msg, err := t.SagaMsg(req.GetParams())
+	if err != nil {
+		return nil, err
+	}
+	return c.StartSagaRPC(ctx, msg)
+}
+
+func templateToProto(t Template) *TemplateMsg {
+	def, err := t.Definition.Marshal(DefinitionJSON)
+	if err != nil {
+		panic(err)
+	}
+	return &TemplateMsg{
+		Name:       t.Name,
+		Version:    t.Version,
+		Definition: string(def),
+	}
+}
+
+func protoToTemplate(msg *TemplateMsg) (Template, error) {
+	def, err := ParseDefinition([]byte(msg.GetDefinition()), DefinitionJSON)
+	if err != nil {
+		return Template{}, err
+	}
+	return Template{
+		Name:       msg.GetName(),
+		Version:    msg.GetVersion(),
+		Definition: def,
+	}, nil
+}
+
 func protoToSaga(req *SagaMsg) Saga {
 	vertices := make(map[string]Vertex, len(req.GetVertices()))
 	dag := make(map[string]map[string][]string, 0)
@@ -65,6 +208,14 @@ func protoToSaga(req *SagaMsg) Saga {
 		if vtx == nil {
 			continue
 		}
+		// Vertices running a child saga have no funcs of their own, and vertices
+		// without compensation may omit C
+		if vtx.T == nil {
+			vtx.T = &Func{}
+		}
+		if vtx.C == nil {
+			vtx.C = &Func{}
+		}
 		if vtx.T.Body == nil {
 			vtx.T.Body = make(map[string]string, 0)
 		}
@@ -82,11 +233,30 @@ func protoToSaga(req *SagaMsg) Saga {
 	}
 
 	// Populate dag
+	var predicates map[string]map[string]string
 	for _, edge := range req.GetEdges() {
 		dag[edge.GetStartId()][edge.GetEndId()] = edge.GetTransferFields()
+		if edge.GetPredicate() == "" {
+			continue
+		}
+		if predicates == nil {
+			predicates = make(map[string]map[string]string)
+		}
+		if predicates[edge.GetStartId()] == nil {
+			predicates[edge.GetStartId()] = make(map[string]string)
+		}
+		predicates[edge.GetStartId()][edge.GetEndId()] = edge.GetPredicate()
 	}
 
-	return NewSaga(vertices, dag)
+	saga := NewSaga(vertices, dag)
+	saga.Predicates = predicates
+	saga.Template = req.GetTemplate()
+	saga.TemplateVersion = req.GetTemplateVersion()
+	saga.Parent = req.GetParentId()
+	saga.ParentVertex = req.GetParentVertex()
+	saga.Recovery = req.GetRecoveryMode()
+	saga.Compensation = req.GetCompensationOrder()
+	return saga
 }
 
 func sagaToProto(saga Saga) *SagaMsg {
@@ -108,12 +278,53 @@ func sagaToProto(saga Saga) *SagaMsg {
 				StartId:        k,
 				EndId:          c,
 				TransferFields: fields,
+				Predicate:      saga.Predicates[k][c],
 			})
 		}
 	}
 	return &SagaMsg{
-		Id:       saga.ID,
-		Vertices: vertices,
-		Edges:    edges,
+		Id:                saga.ID,
+		Vertices:          vertices,
+		Edges:             edges,
+		Template:          saga.Template,
+		TemplateVersion:   saga.TemplateVersion,
+		ParentId:          saga.Parent,
+		ParentVertex:      saga.ParentVertex,
+		RecoveryMode:      saga.Recovery,
+		CompensationOrder: saga.Compensation,
+	}
+}
+
+func snapshotToProto(snapshot Snapshot) *SnapshotMsg {
+	scheduled := make([]string, len(snapshot.Scheduled))
+	for i, vtx := range snapshot.Scheduled {
+		scheduled[i] = vtx.Id
+	}
+	return &SnapshotMsg{
+		Saga:      sagaToProto(snapshot.Saga),
+		Lsn:       snapshot.Lsn,
+		NextLsn:   snapshot.NextLsn,
+		Scheduled: scheduled,
+		Finished:  snapshot.Finished,
+		Aborted:   snapshot.Aborted,
+	}
+}
+
+func protoToSnapshot(msg *SnapshotMsg) Snapshot {
+	saga := protoToSaga(msg.GetSaga())
+	saga.ID = msg.GetSaga().GetId()
+	scheduled := make([]Vertex, 0, len(msg.GetScheduled()))
+	for _, id := range msg.GetScheduled() {
+		if vtx, ok := saga.getVtx(id); ok {
+			scheduled = append(scheduled, vtx)
+		}
+	}
+	return Snapshot{
+		Saga:      saga,
+		Lsn:       msg.GetLsn(),
+		NextLsn:   msg.GetNextLsn(),
+		Scheduled: scheduled,
+		Finished:  msg.GetFinished(),
+		Aborted:   msg.GetAborted(),
 	}
 }
@@ -0,0 +1,41 @@
+package sagas
+
+import (
+	"context"
+	"time"
+)
+
+// Clock tells the coordinator the time. Simulations replace the system clock to
+// make runs repeatable
+type Clock interface {
+	Now() time.Time
+	// After sends the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Transport issues the request of a vertex's T or C. HTTPReq is the default
+// transport. Simulations replace it with a fake network
+type Transport func(ctx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error)
+
+// Scheduler runs the processing of vertices. By default each vertex runs on its own
+// goroutine. Simulations replace it to run one vertex at a time in a repeatable order
+type Scheduler interface {
+	// Go runs f, which processes a vertex, without waiting for it to return
+	Go(f func())
+}
+
+type goScheduler struct{}
+
+func (goScheduler) Go(f func()) {
+	go f()
+}
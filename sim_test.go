@@ -0,0 +1,805 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/atomic"
+	"gotest.tools/assert"
+)
+
+var errSimAborted = errors.New("simulated participant aborted")
+
+// simClock advances a millisecond every time it is read so runs do not depend on the system clock
+type simClock struct {
+	ticks atomic.Int64
+}
+
+func (c *simClock) Now() time.Time {
+	return time.Unix(0, 0).Add(time.Duration(c.ticks.Inc()) * time.Millisecond)
+}
+
+// After advances the clock by d at once, so waits take no real time
+func (c *simClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Unix(0, 0).Add(time.Duration(c.ticks.Add(int64(d/time.Millisecond)+1)) * time.Millisecond)
+	return ch
+}
+
+// simNetwork is a set of fake participants shared by every coordinator of a
+// simulation. Participants are addressed by sim://<vertex>/t and sim://<vertex>/c
+type simNetwork struct {
+	mtx         sync.Mutex
+	applied     map[string]bool
+	compensated map[string]bool
+	// Number of times each T was issued
+	attempts map[string]int
+	// Vertices in the order they were first compensated
+	order []string
+	// Number of times each C was issued
+	compensations map[string]int
+}
+
+func newSimNetwork() *simNetwork {
+	return &simNetwork{
+		applied:       make(map[string]bool),
+		compensated:   make(map[string]bool),
+		attempts:      make(map[string]int),
+		compensations: make(map[string]int),
+	}
+}
+
+// simNode is one incarnation of a coordinator. Once it crashes, its requests
+// never reach the network
+type simNode struct {
+	net  *simNetwork
+	dead bool
+}
+
+func (n *simNode) transport(ctx context.Context, url, method, requestID string, body map[string]string) (map[string]string, error) {
+	n.net.mtx.Lock()
+	if n.dead {
+		n.net.mtx.Unlock()
+		runtime.Goexit()
+	}
+	defer n.net.mtx.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(url, "sim://"), "/")
+	id, fn := parts[0], parts[1]
+	switch fn {
+	case "t":
+		n.net.attempts[id]++
+		if body["success"] == "0" {
+			return nil, errSimAborted
+		}
+		// A flaky participant fails its first attempts
+		if failures, _ := strconv.Atoi(body["failures"]); n.net.attempts[id] <= failures {
+			return nil, errSimAborted
+		}
+		// Re-issued T is deduped by the participant
+		n.net.applied[id] = true
+		// T replies with its body
+		resp := make(map[string]string, len(body))
+		for k, v := range body {
+			resp[k] = v
+		}
+		return resp, nil
+	case "c":
+		n.net.compensations[id]++
+		if failures, _ := strconv.Atoi(body["failures"]); n.net.compensations[id] <= failures {
+			return nil, errSimAborted
+		}
+		// C of a T that never happened has nothing to undo
+		if n.net.applied[id] && !n.net.compensated[id] {
+			n.net.compensated[id] = true
+			n.net.order = append(n.net.order, id)
+		}
+	}
+	return map[string]string{"success": "1"}, nil
+}
+
+// crashLogStore crashes its coordinator instead of appending log number after+1.
+// Crashed calls never return, as the process they run in is gone
+type crashLogStore struct {
+	LogStore
+	node    *simNode
+	after   int
+	appends int
+	// sagaID of the first log appended
+	sagaID string
+	// Logs appended, in order
+	appended []string
+}
+
+func (s *crashLogStore) AppendLog(sagaID string, logType LogType, data []byte) (uint64, error) {
+	s.node.net.mtx.Lock()
+	if s.appends == s.after {
+		s.node.dead = true
+		s.node.net.mtx.Unlock()
+		runtime.Goexit()
+	}
+	if s.appends == 0 {
+		s.sagaID = sagaID
+	}
+	s.appends++
+	entry := fmt.Sprintf("%v %v", sagaID, logType.GoString())
+	if logType == VertexLog {
+		vtx := decodeVertex(data)
+		entry = fmt.Sprintf("%v %v %v", entry, vtx.Id, vtx.Status)
+	}
+	s.appended = append(s.appended, entry)
+	s.node.net.mtx.Unlock()
+	return s.LogStore.AppendLog(sagaID, logType, data)
+}
+
+// Close leaves the shared store open for the coordinator that recovers
+func (s *crashLogStore) Close() {}
+
+// simScheduler runs the vertices of a coordinator one at a time, in the order
+// they are started, each once everything else in the simulation is blocked.
+// Requests that sleep resume in order of virtual time once no vertex is left to start
+type simScheduler struct {
+	mtx   sync.Mutex
+	queue []func()
+	now   time.Duration
+	// Sleeping requests in the order they started sleeping
+	sleepers []simSleeper
+}
+
+type simSleeper struct {
+	wake time.Duration
+	ch   chan struct{}
+}
+
+func (s *simScheduler) Go(f func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.queue = append(s.queue, f)
+}
+
+// sleep blocks until run reaches virtual time now+d
+func (s *simScheduler) sleep(d time.Duration) {
+	ch := make(chan struct{})
+	s.mtx.Lock()
+	s.sleepers = append(s.sleepers, simSleeper{wake: s.now + d, ch: ch})
+	s.mtx.Unlock()
+	<-ch
+}
+
+// run runs queued vertices and wakes sleepers until none is left. Must be called
+// in a synctest bubble
+func (s *simScheduler) run() {
+	for {
+		synctest.Wait()
+		s.mtx.Lock()
+		switch {
+		case len(s.queue) > 0:
+			f := s.queue[0]
+			s.queue = s.queue[1:]
+			go f()
+		case len(s.sleepers) > 0:
+			// The first to sleep wakes first among sleepers with the same wake time
+			next := 0
+			for i, sleeper := range s.sleepers {
+				if sleeper.wake < s.sleepers[next].wake {
+					next = i
+				}
+			}
+			sleeper := s.sleepers[next]
+			s.sleepers = append(s.sleepers[:next], s.sleepers[next+1:]...)
+			s.now = sleeper.wake
+			close(sleeper.ch)
+		default:
+			s.mtx.Unlock()
+			return
+		}
+		s.mtx.Unlock()
+	}
+}
+
+type simScenario struct {
+	name     string
+	vertices map[string]string
+	edges    [][2]string
+	blindC   []string
+	// Vertices that run a child saga instead of a participant
+	children map[string]simScenario
+	// Fields added to T bodies, which participants reply with
+	bodies map[string]map[string]string
+	// Fields added to C bodies
+	cBodies    map[string]map[string]string
+	predicates map[[2]string]string
+	// Vertices expected to be skipped
+	skipped  []string
+	kinds    map[string]VertexKind
+	recovery RecoveryMode
+	// Vertices without compensation
+	readOnly []string
+}
+
+func (s simScenario) msg() *SagaMsg {
+	msg := &SagaMsg{
+		Vertices:     make(map[string]*Vertex, len(s.vertices)+len(s.children)),
+		RecoveryMode: s.recovery,
+	}
+	for id, success := range s.vertices {
+		body := map[string]string{"success": success}
+		for k, v := range s.bodies[id] {
+			body[k] = v
+		}
+		msg.Vertices[id] = &Vertex{
+			Id:   id,
+			T:    &Func{Url: "sim://" + id + "/t", Method: "POST", Body: body},
+			C:    &Func{Url: "sim://" + id + "/c", Method: "POST", Body: s.cBodies[id]},
+			Kind: s.kinds[id],
+		}
+	}
+	for id, child := range s.children {
+		msg.Vertices[id] = &Vertex{Id: id, Saga: child.msg()}
+	}
+	for _, id := range s.blindC {
+		msg.Vertices[id].UncertainPolicy = UncertainPolicy_BLIND_C
+	}
+	for _, id := range s.readOnly {
+		msg.Vertices[id].ReadOnly, msg.Vertices[id].C = true, nil
+	}
+	for _, e := range s.edges {
+		msg.Edges = append(msg.Edges, &Edge{StartId: e[0], EndId: e[1], Predicate: s.predicates[e]})
+	}
+	return msg
+}
+
+func (s simScenario) aborts() bool {
+	skipped := make(map[string]bool, len(s.skipped))
+	for _, id := range s.skipped {
+		skipped[id] = true
+	}
+	for id, success := range s.vertices {
+		if success == "0" && !skipped[id] {
+			return true
+		}
+	}
+	for _, child := range s.children {
+		if child.aborts() {
+			return true
+		}
+	}
+	return false
+}
+
+// allEdges returns the edges of the scenario and its children
+func (s simScenario) allEdges() [][2]string {
+	edges := append([][2]string(nil), s.edges...)
+	for _, child := range s.children {
+		edges = append(edges, child.allEdges()...)
+	}
+	return edges
+}
+
+// participants returns the ids of the vertices of the scenario and its children that call participants
+func (s simScenario) participants() []string {
+	var ids []string
+	for id := range s.vertices {
+		ids = append(ids, id)
+	}
+	for _, child := range s.children {
+		ids = append(ids, child.participants()...)
+	}
+	return ids
+}
+
+// simulate runs a scenario, crashing the coordinator instead of its log append
+// number after+1 and recovering on a new coordinator. Returns the logs both
+// coordinators appended, and false if the scenario finished without reaching the
+// crash point.
+// Each run has its own log store and runs in a synctest bubble, one vertex at a
+// time in a fixed order, so a scenario and crash index always replay the same run.
+// Explore checks the other interleavings of a saga
+func simulate(t *testing.T, s simScenario, after int) (trace []string, crashed bool) {
+	synctest.Test(t, func(t *testing.T) {
+		logs := NewBadgerDB("", true)
+		defer logs.Close()
+		net := newSimNetwork()
+		clock := &simClock{}
+
+		newCoordinator := func(store *crashLogStore, autoRecover bool) (*Coordinator, *simScheduler) {
+			sched := &simScheduler{}
+			config := DefaultConfig()
+			config.Logger = discardLogger()
+			config.Clock = clock
+			config.Transport = store.node.transport
+			config.Scheduler = sched
+			config.RetryInterval = time.Millisecond
+			config.AutoRecover = autoRecover
+			return NewCoordinator(config, store), sched
+		}
+
+		store := &crashLogStore{LogStore: logs, node: &simNode{net: net}, after: after}
+		c, sched := newCoordinator(store, false)
+		defer c.Close()
+		done := make(chan struct{})
+		go func() {
+			c.StartSagaRPC(context.Background(), s.msg())
+			close(done)
+		}()
+		sched.run()
+		trace = store.appended
+
+		net.mtx.Lock()
+		crashed = store.node.dead
+		net.mtx.Unlock()
+		if !crashed {
+			select {
+			case <-done:
+			default:
+				t.Fatalf("saga did not finish: %v", trace)
+			}
+			return
+		}
+
+		// Recover from the surviving logs on a new coordinator
+		recovered := &crashLogStore{LogStore: logs, node: &simNode{net: net}, after: -1}
+		c, sched = newCoordinator(recovered, true)
+		defer c.Close()
+		sched.run()
+		trace = append(trace, recovered.appended...)
+
+		if store.sagaID == "" {
+			net.mtx.Lock()
+			defer net.mtx.Unlock()
+			assert.Equal(t, len(net.applied), 0, "saga was lost after participants ran")
+			return
+		}
+		c.mtx.Lock()
+		saga := c.sagas[store.sagaID]
+		c.mtx.Unlock()
+		if finished, _ := CheckFinishedOrAbort(saga); !finished {
+			t.Fatalf("saga did not finish after recovery: %v", trace)
+		}
+
+		net.mtx.Lock()
+		defer net.mtx.Unlock()
+		_, aborted := CheckFinishedOrAbort(saga)
+		assert.Equal(t, aborted, s.aborts())
+		for _, id := range s.skipped {
+			vtx, _ := saga.getVtx(id)
+			assert.Equal(t, vtx.Status, Status_SKIPPED, "vertex %v", id)
+			assert.Assert(t, !net.applied[id], "vertex %v", id)
+		}
+		skipped := make(map[string]bool, len(s.skipped))
+		for _, id := range s.skipped {
+			skipped[id] = true
+		}
+		// Read-only vertices stay applied without C being called
+		for _, id := range s.readOnly {
+			assert.Equal(t, net.compensations[id], 0, "vertex %v", id)
+			skipped[id] = true
+		}
+		for _, id := range s.participants() {
+			if skipped[id] {
+				continue
+			}
+			if aborted {
+				assert.Equal(t, net.applied[id], net.compensated[id], "vertex %v", id)
+				if vtx, ok := saga.getVtx(id); ok && net.applied[id] {
+					assert.Equal(t, vtx.Status, Status_END_C, "vertex %v", id)
+				}
+			} else {
+				assert.Assert(t, net.applied[id], "vertex %v", id)
+				assert.Assert(t, !net.compensated[id], "vertex %v", id)
+			}
+		}
+		// Children are compensated before their parents
+		compensated := make(map[string]int, len(net.order))
+		for i, id := range net.order {
+			compensated[id] = i
+		}
+		for _, e := range s.allEdges() {
+			parent, okParent := compensated[e[0]]
+			child, okChild := compensated[e[1]]
+			if okParent && okChild {
+				assert.Assert(t, child < parent, "edge %v compensated in order %v", e, net.order)
+			}
+		}
+	})
+	return trace, crashed
+}
+
+func TestSimulateCrashes(t *testing.T) {
+	tests := []simScenario{
+		{
+			name:     "single",
+			vertices: map[string]string{"a": "1"},
+		},
+		{
+			name:     "single abort",
+			vertices: map[string]string{"a": "0"},
+		},
+		{
+			name:     "chain abort",
+			vertices: map[string]string{"a": "1", "b": "1", "c": "0"},
+			edges:    [][2]string{{"a", "b"}, {"b", "c"}},
+		},
+		{
+			name:     "diamond",
+			vertices: map[string]string{"a": "1", "b": "1", "c": "1", "d": "1"},
+			edges:    [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}},
+		},
+		{
+			name:     "diamond abort",
+			vertices: map[string]string{"a": "1", "b": "1", "c": "0", "d": "1"},
+			edges:    [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}},
+		},
+		{
+			name:     "blind compensation",
+			vertices: map[string]string{"a": "1", "b": "0"},
+			edges:    [][2]string{{"a", "b"}},
+			blindC:   []string{"a", "b"},
+		},
+		{
+			name:       "skipped",
+			vertices:   map[string]string{"a": "1", "b": "0", "c": "1", "d": "1"},
+			edges:      [][2]string{{"a", "b"}, {"b", "c"}, {"a", "d"}},
+			bodies:     map[string]map[string]string{"a": {"shuttle": "full"}},
+			predicates: map[[2]string]string{{"a", "b"}: `shuttle == "available"`, {"a", "d"}: `shuttle != "available"`},
+			skipped:    []string{"b", "c"},
+		},
+		{
+			name:       "skipped abort",
+			vertices:   map[string]string{"a": "1", "b": "1", "c": "1", "d": "0"},
+			edges:      [][2]string{{"a", "b"}, {"b", "c"}, {"a", "d"}},
+			bodies:     map[string]map[string]string{"a": {"shuttle": "full"}},
+			predicates: map[[2]string]string{{"a", "b"}: `shuttle == "available"`, {"a", "d"}: `shuttle != "available"`},
+			skipped:    []string{"b", "c"},
+		},
+		{
+			name:     "retriable",
+			vertices: map[string]string{"a": "1", "p": "1", "r": "1"},
+			edges:    [][2]string{{"a", "p"}, {"p", "r"}},
+			bodies:   map[string]map[string]string{"r": {"failures": "3"}},
+			kinds:    map[string]VertexKind{"p": VertexKind_PIVOT, "r": VertexKind_RETRIABLE},
+		},
+		{
+			name:     "forward",
+			vertices: map[string]string{"a": "1", "b": "1", "c": "1"},
+			edges:    [][2]string{{"a", "b"}, {"b", "c"}},
+			bodies:   map[string]map[string]string{"b": {"failures": "3"}},
+			recovery: RecoveryMode_FORWARD,
+		},
+		{
+			name:     "failed compensation",
+			vertices: map[string]string{"a": "1", "b": "0"},
+			edges:    [][2]string{{"a", "b"}},
+			cBodies:  map[string]map[string]string{"a": {"failures": "1"}},
+		},
+		{
+			name:     "read-only abort",
+			vertices: map[string]string{"a": "1", "q": "1", "b": "1", "c": "0"},
+			edges:    [][2]string{{"a", "q"}, {"q", "b"}, {"b", "c"}},
+			readOnly: []string{"q"},
+		},
+		{
+			name:     "pivot abort",
+			vertices: map[string]string{"a": "1", "p": "0", "r": "1"},
+			edges:    [][2]string{{"a", "p"}, {"p", "r"}},
+			kinds:    map[string]VertexKind{"p": VertexKind_PIVOT, "r": VertexKind_RETRIABLE},
+		},
+		{
+			name:     "child",
+			vertices: map[string]string{"a": "1"},
+			edges:    [][2]string{{"a", "k"}},
+			children: map[string]simScenario{
+				"k": {vertices: map[string]string{"x": "1", "y": "1"}, edges: [][2]string{{"x", "y"}}},
+			},
+		},
+		{
+			name:     "child abort",
+			vertices: map[string]string{"a": "1"},
+			edges:    [][2]string{{"a", "k"}},
+			children: map[string]simScenario{
+				"k": {vertices: map[string]string{"x": "1", "y": "0"}, edges: [][2]string{{"x", "y"}}},
+			},
+		},
+		{
+			name:     "committed child compensated",
+			vertices: map[string]string{"b": "0"},
+			edges:    [][2]string{{"k", "b"}},
+			children: map[string]simScenario{
+				"k": {vertices: map[string]string{"x": "1", "y": "1"}, edges: [][2]string{{"x", "y"}}},
+			},
+		},
+		{
+			name:     "nested children",
+			vertices: map[string]string{"b": "0"},
+			edges:    [][2]string{{"k", "b"}},
+			children: map[string]simScenario{
+				"k": {
+					vertices: map[string]string{"x": "1"},
+					edges:    [][2]string{{"x", "l"}},
+					children: map[string]simScenario{"l": {vertices: map[string]string{"y": "1"}}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := 0
+			for ; ; after++ {
+				trace, crashed := simulate(t, tt, after)
+				if !crashed {
+					break
+				}
+				// The same crash point must replay the same run
+				replay, _ := simulate(t, tt, after)
+				assert.DeepEqual(t, replay, trace)
+			}
+			assert.Assert(t, after > 0, fmt.Sprintf("%v never appended a log", tt.name))
+			t.Logf("%v crash points", after)
+		})
+	}
+}
+
+func TestChildSagaLinks(t *testing.T) {
+	s := simScenario{
+		vertices: map[string]string{"b": "0"},
+		edges:    [][2]string{{"k", "b"}},
+		children: map[string]simScenario{
+			"k": {vertices: map[string]string{"x": "1", "y": "1"}, edges: [][2]string{{"x", "y"}}},
+		},
+	}
+	net := newSimNetwork()
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	config.Transport = (&simNode{net: net}).transport
+	logs := NewBadgerDB(config.Path, true)
+	c := NewCoordinator(config, logs)
+	defer c.Cleanup()
+
+	resp, err := c.StartSagaRPC(context.Background(), s.msg())
+	assert.NilError(t, err)
+	assert.Equal(t, resp.Vertices["k"].Status, Status_END_C)
+	childID := resp.Vertices["k"].SagaId
+	assert.Assert(t, childID != "")
+
+	// The committed child was compensated through the parent
+	assert.Assert(t, net.applied["x"] && net.compensated["x"])
+	assert.Assert(t, net.applied["y"] && net.compensated["y"])
+
+	sagas, err := Recover(logs)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sagas), 2)
+	child, ok := sagas[childID]
+	assert.Assert(t, ok)
+	assert.Equal(t, child.Parent, resp.Id)
+	assert.Equal(t, child.ParentVertex, "k")
+	finished, aborted := CheckFinishedOrAbort(child)
+	assert.Assert(t, finished && aborted)
+	parent := sagas[resp.Id]
+	k, _ := parent.getVtx("k")
+	assert.Equal(t, k.SagaId, childID)
+	assert.Assert(t, k.Saga != nil)
+}
+
+func TestCompensateForwardChild(t *testing.T) {
+	// A child in forward recovery commits instead of compensating, so the parent's
+	// compensation of it fails and is retried rather than waiting forever
+	s := simScenario{
+		vertices: map[string]string{"b": "0"},
+		edges:    [][2]string{{"k", "b"}},
+		children: map[string]simScenario{
+			"k": {vertices: map[string]string{"x": "1"}, recovery: RecoveryMode_FORWARD},
+		},
+	}
+	net := newSimNetwork()
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	config.Transport = (&simNode{net: net}).transport
+	config.RetryInterval = time.Hour
+	c := NewCoordinator(config, NewBadgerDB(config.Path, true))
+	defer c.Cleanup()
+	go c.StartSagaRPC(context.Background(), s.msg())
+
+	var k Vertex
+	for deadline := time.Now().Add(5 * time.Second); k.LastError == ""; time.Sleep(time.Millisecond) {
+		assert.Assert(t, time.Now().Before(deadline), "compensation of k did not fail")
+		c.mtx.Lock()
+		for _, saga := range c.sagas {
+			if vtx, ok := saga.getVtx("k"); ok {
+				k = vtx
+			}
+		}
+		c.mtx.Unlock()
+	}
+	assert.Equal(t, k.Status, Status_START_C)
+	assert.Equal(t, k.LastError, ErrChildNotCompensatable.Error())
+	net.mtx.Lock()
+	defer net.mtx.Unlock()
+	assert.Assert(t, net.applied["x"] && !net.compensated["x"])
+}
+
+func TestAbortPastPivot(t *testing.T) {
+	config := DefaultConfig()
+	config.Logger = discardLogger()
+	c := NewCoordinator(config, NewBadgerDB(config.Path, true))
+	defer c.Cleanup()
+
+	saga := NewSaga(map[string]Vertex{
+		"a": {Id: "a", Status: Status_END_T},
+		"p": {Id: "p", Status: Status_START_T, Kind: VertexKind_PIVOT},
+		"r": {Id: "r", Kind: VertexKind_RETRIABLE},
+	}, map[string]map[string][]string{"a": {"p": nil}, "p": {"r": nil}, "r": {}})
+	saga.ID = "1"
+	c.mtx.Lock()
+	c.sagas[saga.ID] = saga
+	c.mtx.Unlock()
+
+	assert.Equal(t, c.Abort(saga.ID), ErrSagaPastPivot)
+}
+
+func TestForwardRecovery(t *testing.T) {
+	logs := NewBadgerDB(DefaultConfig().Path, true)
+	defer logs.RemoveAll()
+	defer logs.Close()
+
+	net := newSimNetwork()
+	newConfig := func(node *simNode) *Config {
+		config := DefaultConfig()
+		config.Logger = discardLogger()
+		config.Clock = &simClock{}
+		config.Transport = node.transport
+		config.RetryInterval = time.Millisecond
+		config.MaxRetryInterval = 4 * time.Millisecond
+		return config
+	}
+
+	// b fails until an operator intervenes
+	s := simScenario{
+		vertices: map[string]string{"a": "1", "b": "0", "c": "1"},
+		edges:    [][2]string{{"a", "b"}, {"b", "c"}},
+		recovery: RecoveryMode_FORWARD,
+	}
+	node := &simNode{net: net}
+	store := &crashLogStore{LogStore: logs, node: node, after: -1}
+	config := newConfig(node)
+	config.AutoRecover = false
+	c := NewCoordinator(config, store)
+	go c.StartSagaRPC(context.Background(), s.msg())
+
+	// Crash once b has been retried a few times
+	for {
+		net.mtx.Lock()
+		attempts := net.attempts["b"]
+		if attempts >= 3 {
+			node.dead = true
+			store.after = store.appends
+		}
+		net.mtx.Unlock()
+		if attempts >= 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sagas, err := Recover(logs)
+	assert.NilError(t, err)
+	saga := sagas[store.sagaID]
+	assert.Equal(t, saga.Recovery, RecoveryMode_FORWARD)
+	b, _ := saga.getVtx("b")
+	assert.Assert(t, b.Attempts >= 2, "attempts %v", b.Attempts)
+	assert.Equal(t, b.LastError, errSimAborted.Error())
+	assert.Assert(t, testutil.ToFloat64(c.metrics.vertexRetries.WithLabelValues("sim://b/t", "T")) >= 2)
+
+	// Recover from the surviving logs on a new coordinator, which keeps retrying b
+	c = NewCoordinator(newConfig(&simNode{net: net}), logs)
+	for {
+		c.mtx.Lock()
+		_, ok := c.sagas[store.sagaID]
+		c.mtx.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.NilError(t, c.Abort(store.sagaID))
+	saga = waitFinished(t, c, store.sagaID)
+	assert.Equal(t, sagaState(saga), stateStopped)
+
+	net.mtx.Lock()
+	defer net.mtx.Unlock()
+	assert.Assert(t, net.applied["a"] && !net.compensated["a"])
+	assert.Assert(t, !net.applied["b"] && !net.applied["c"])
+	b, _ = saga.getVtx("b")
+	assert.Equal(t, b.Status, Status_ABORT)
+	c2, _ := saga.getVtx("c")
+	assert.Equal(t, c2.Status, Status_NOT_REACHED)
+}
+
+func TestRetryWait(t *testing.T) {
+	// Waits are an hour long, so the test only finishes if they are cut short
+	newCoordinator := func(net *simNetwork) *Coordinator {
+		config := DefaultConfig()
+		config.Logger = discardLogger()
+		config.Transport = (&simNode{net: net}).transport
+		config.RetryInterval = time.Hour
+		return NewCoordinator(config, NewBadgerDB(config.Path, true))
+	}
+	s := simScenario{
+		vertices: map[string]string{"a": "0"},
+		recovery: RecoveryMode_FORWARD,
+	}
+	waitRetrying := func(c *Coordinator, vertexID string) string {
+		for {
+			c.mtx.Lock()
+			for id, saga := range c.sagas {
+				if vtx, _ := saga.getVtx(vertexID); vtx.LastError != "" {
+					c.mtx.Unlock()
+					return id
+				}
+			}
+			c.mtx.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		net := newSimNetwork()
+		c := newCoordinator(net)
+		defer c.Cleanup()
+		go c.StartSagaRPC(context.Background(), s.msg())
+
+		sagaID := waitRetrying(c, "a")
+		assert.NilError(t, c.Abort(sagaID))
+		saga := waitFinished(t, c, sagaID)
+		assert.Equal(t, sagaState(saga), stateStopped)
+		net.mtx.Lock()
+		defer net.mtx.Unlock()
+		assert.Equal(t, net.attempts["a"], 1)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		net := newSimNetwork()
+		c := newCoordinator(net)
+		go c.StartSagaRPC(context.Background(), s.msg())
+
+		waitRetrying(c, "a")
+		// A retry that outlived Cleanup would append to the closed store and panic
+		c.Cleanup()
+		time.Sleep(10 * time.Millisecond)
+		net.mtx.Lock()
+		defer net.mtx.Unlock()
+		assert.Equal(t, net.attempts["a"], 1)
+	})
+
+	t.Run("retriable", func(t *testing.T) {
+		net := newSimNetwork()
+		c := newCoordinator(net)
+		defer c.Cleanup()
+		go c.StartSagaRPC(context.Background(), simScenario{
+			vertices: map[string]string{"p": "1", "r": "0"},
+			edges:    [][2]string{{"p", "r"}},
+			kinds:    map[string]VertexKind{"p": VertexKind_PIVOT, "r": VertexKind_RETRIABLE},
+		}.msg())
+
+		// A retriable vertex keeps waiting even if its saga is marked aborted
+		sagaID := waitRetrying(c, "r")
+		c.mtx.Lock()
+		c.sagas[sagaID].aborted.Store(true)
+		c.wakeRetries(sagaID)
+		c.mtx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		net.mtx.Lock()
+		defer net.mtx.Unlock()
+		assert.Equal(t, net.attempts["r"], 1)
+	})
+}
@@ -0,0 +1,126 @@
+package sagas
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// Errors involving saga templates
+var (
+	ErrTemplateNotFound        = errors.New("template is not registered")
+	ErrTemplateVersionNotFound = errors.New("template has no such version")
+	ErrInvalidTemplateName     = errors.New("template name must not be empty")
+)
+
+// templateSagaID is the saga id of template logs, which belong to no saga
+const templateSagaID = ""
+
+// Template is a named saga definition registered on the coordinator. Registering
+// a name again adds a new version, starting from 1
+type Template struct {
+	Name       string
+	Version    uint64
+	Definition *Definition
+}
+
+// RecoverTemplates reads all registered template versions from logs, keyed by name
+// and ordered by version
+func RecoverTemplates(logs LogStore) (map[string][]Template, error) {
+	templates := make(map[string][]Template)
+
+	it := logs.ScanSaga(templateSagaID)
+	defer it.Close()
+
+	for it.Next() {
+		log := it.Log()
+		if log.LogType != TemplateLog {
+			continue
+		}
+		t, err := log.Template()
+		if err != nil {
+			return nil, err
+		}
+		templates[t.Name] = append(templates[t.Name], t)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// RegisterTemplate validates and persists a new version of the named template.
+// Sagas already started from older versions are unaffected
+func (c *Coordinator) RegisterTemplate(name string, def *Definition) (Template, error) {
+	if name == "" {
+		return Template{}, ErrInvalidTemplateName
+	}
+	if err := def.Validate(); err != nil {
+		return Template{}, err
+	}
+
+	c.templateMtx.Lock()
+	defer c.templateMtx.Unlock()
+
+	t := Template{
+		Name:       name,
+		Version:    uint64(len(c.templates[name]) + 1),
+		Definition: def,
+	}
+	lsn, err := c.appendLog(context.Background(), templateSagaID, TemplateLog, encodeTemplate(t))
+	if err != nil {
+		return Template{}, err
+	}
+	c.templates[name] = append(c.templates[name], t)
+	c.logger.Info("template registered", "template", name, "version", t.Version, logKeyLsn, lsn)
+
+	return t, nil
+}
+
+// ListTemplates returns every version of every registered template, ordered by name and version
+func (c *Coordinator) ListTemplates() []Template {
+	c.templateMtx.RLock()
+	defer c.templateMtx.RUnlock()
+
+	var list []Template
+	for _, versions := range c.templates {
+		list = append(list, versions...)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// Template returns a version of the named template. Version 0 returns the latest version
+func (c *Coordinator) Template(name string, version uint64) (Template, error) {
+	c.templateMtx.RLock()
+	defer c.templateMtx.RUnlock()
+
+	versions, ok := c.templates[name]
+	if !ok {
+		return Template{}, ErrTemplateNotFound
+	}
+	if version == 0 {
+		return versions[len(versions)-1], nil
+	}
+	if version > uint64(len(versions)) {
+		return Template{}, ErrTemplateVersionNotFound
+	}
+	return versions[version-1], nil
+}
+
+// SagaMsg fills in the template's params and converts it into a saga message
+// pinned to this version
+func (t Template) SagaMsg(params map[string]string) (*SagaMsg, error) {
+	msg, err := t.Definition.SagaMsg(params)
+	if err != nil {
+		return nil, err
+	}
+	msg.Template = t.Name
+	msg.TemplateVersion = t.Version
+	return msg, nil
+}
@@ -0,0 +1,83 @@
+package sagas
+
+import (
+	"context"
+
+	"github.com/triplewy/sagas/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const tracerName = "github.com/triplewy/sagas"
+
+// propagator carries trace context in W3C traceparent headers
+var propagator = propagation.TraceContext{}
+
+// newTracer returns a tracer that sends spans to exporter. A nil exporter uses the
+// global tracer provider, which drops spans unless the application sets one
+func newTracer(exporter sdktrace.SpanExporter) (trace.Tracer, *sdktrace.TracerProvider) {
+	if exporter == nil {
+		return otel.GetTracerProvider().Tracer(tracerName), nil
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	return tp.Tracer(tracerName), tp
+}
+
+// sagaContext returns the context of a saga's span. Must hold c.mtx
+func (c *Coordinator) sagaContext(sagaID string) context.Context {
+	if ctx, ok := c.traces[sagaID]; ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// startSagaSpan starts the span that lasts for a saga's lifetime. The saga outlives
+// the request that started it, so its context keeps the caller's trace but not its
+// cancellation or deadline. Must hold c.mtx
+func (c *Coordinator) startSagaSpan(ctx context.Context, saga Saga, recovered bool) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = context.WithoutCancel(ctx)
+	ctx, _ = c.tracer.Start(ctx, "saga", trace.WithAttributes(
+		attribute.String("saga.id", saga.ID),
+		attribute.Bool("saga.recovered", recovered),
+	))
+	c.traces[saga.ID] = ctx
+}
+
+// endSagaSpan ends a saga's span. Must hold c.mtx
+func (c *Coordinator) endSagaSpan(sagaID string, aborted bool) {
+	ctx, ok := c.traces[sagaID]
+	if !ok {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Bool("saga.aborted", aborted))
+	span.End()
+	delete(c.traces, sagaID)
+}
+
+// statusEvent records a vertex's status transition on the span in ctx
+func statusEvent(ctx context.Context, from, to Status) {
+	if from == to {
+		return
+	}
+	trace.SpanFromContext(ctx).AddEvent("status", trace.WithAttributes(
+		attribute.String("from", from.String()),
+		attribute.String("to", to.String()),
+	))
+}
+
+// extractMetadata returns ctx with the trace context in its incoming metadata
+func extractMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return propagator.Extract(ctx, utils.MetadataCarrier(md))
+}
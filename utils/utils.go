@@ -2,15 +2,21 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/hashicorp/go-msgpack/codec"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // Publicly exported errors
@@ -86,33 +92,116 @@ func ParseGraphFile(filename string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	txt := string(out)
-	return strings.Split(txt, "-------"), nil
-}
-
-// ParseGraphText takes in a specific type of string and outputs
-// the name and graph that it contains
-func ParseGraphText(in string) (name string, graph map[string][]string, err error) {
-	graph = make(map[string][]string, 0)
-	levels := strings.Split(in, "\n")
-
-	if len(levels) == 0 {
-		err = ErrInvalidGraphText
-		return
+	var graphs []string
+	for _, graph := range strings.Split(string(out), "-------") {
+		if strings.TrimSpace(graph) != "" {
+			graphs = append(graphs, graph)
+		}
 	}
+	return graphs, nil
+}
 
-	if strings.HasPrefix(levels[0], "# ") {
-		err = ErrInvalidGraphText
-		return
+// graphStatuses decodes the cells of a graph file into saga Status values. A
+// committed vertex is written 1 rather than the value 2 of END_T, and statuses
+// that cannot end a saga have no code
+var graphStatuses = map[int]int32{0: 0, 1: 2, 4: 4, 5: 5, 6: 6}
+
+// ParseGraphText parses one graph of a graph file. The first line is "# <name>"
+// and each following line is a level of the DAG, written as cells between '|'. A
+// cell is blank or holds the final status of a vertex: 0 NOT_REACHED, 1 END_T,
+// 4 END_C, 5 ABORT or 6 SKIPPED. A vertex has an edge to each vertex in the next
+// level whose cell is at most one column away, and its id is "<level>-<column>".
+// Returns the children of each vertex and each vertex's Status value
+func ParseGraphText(in string) (name string, graph map[string][]string, statuses map[string]int32, err error) {
+	levels := strings.Split(strings.TrimSpace(in), "\n")
+
+	if len(levels) < 2 || !strings.HasPrefix(levels[0], "# ") {
+		return "", nil, nil, ErrInvalidGraphText
 	}
+	name = strings.TrimSpace(strings.TrimPrefix(levels[0], "# "))
 
-	name = strings.TrimPrefix(levels[0], "# ")
+	graph = make(map[string][]string)
+	statuses = make(map[string]int32)
+	var prev map[int]string
 
 	for i := 1; i < len(levels); i++ {
-		arr := strings.Split(levels[i], "|")
+		line := strings.TrimSpace(levels[i])
+		if len(line) < 2 || line[0] != '|' || line[len(line)-1] != '|' {
+			return "", nil, nil, ErrInvalidGraphText
+		}
+		arr := strings.Split(line, "|")
 		arr = arr[1 : len(arr)-1]
-		for j := 0; j < len(arr); j++ {
 
+		cur := make(map[int]string)
+		for j := 0; j < len(arr); j++ {
+			cell := strings.TrimSpace(arr[j])
+			if cell == "" {
+				continue
+			}
+			code, err := strconv.Atoi(cell)
+			if err != nil {
+				return "", nil, nil, ErrInvalidGraphText
+			}
+			status, ok := graphStatuses[code]
+			if !ok {
+				return "", nil, nil, ErrInvalidGraphText
+			}
+			id := fmt.Sprintf("%d-%d", i-1, j)
+			graph[id] = []string{}
+			statuses[id] = status
+			cur[j] = id
+
+			for _, col := range []int{j - 1, j, j + 1} {
+				if parent, ok := prev[col]; ok {
+					graph[parent] = append(graph[parent], id)
+				}
+			}
+		}
+		if len(cur) == 0 {
+			return "", nil, nil, ErrInvalidGraphText
 		}
+		prev = cur
+	}
+
+	return name, graph, statuses, nil
+}
+
+// MetadataCarrier adapts gRPC metadata to an OpenTelemetry TextMapCarrier so trace
+// context can be propagated in request metadata
+type MetadataCarrier metadata.MD
+
+// Get returns the first value of key
+func (c MetadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// Set replaces the values of key with value
+func (c MetadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys lists the keys in the carrier
+func (c MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ClientInterceptor injects the W3C trace context of a call into its outgoing
+// metadata
+func ClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
 	}
+	propagation.TraceContext{}.Inject(ctx, MetadataCarrier(md))
+	return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
 }